@@ -0,0 +1,316 @@
+package abi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Argument defines an input of a function in the JSON ABI format, Components are the
+// fields of a tuple
+type Argument struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Indexed    bool       `json:"indexed,omitempty"`
+	Components []Argument `json:"components,omitempty"`
+}
+
+// Method defines a function of a contract
+type Method struct {
+	Name   string
+	Inputs []Argument
+}
+
+// Event defines an event of a contract, an anonymous event has no topic identifying it
+type Event struct {
+	Name      string
+	Inputs    []Argument
+	Anonymous bool
+}
+
+// ABI defines the functions of a contract by selector and its events by topic
+type ABI struct {
+	Methods map[string]Method
+	Events  map[string]Event
+}
+
+// entry is an element of a JSON ABI
+type entry struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Inputs    []Argument `json:"inputs"`
+	Anonymous bool       `json:"anonymous,omitempty"`
+}
+
+// Parse parses a JSON ABI, the entries other than functions and events are ignored
+func Parse(data []byte) (ABI, error) {
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return ABI{}, fmt.Errorf("invalid abi: %w", err)
+	}
+	abi := ABI{Methods: make(map[string]Method), Events: make(map[string]Event)}
+	for _, e := range entries {
+		if e.Type != "function" && e.Type != "event" {
+			continue
+		}
+		if e.Name == "" {
+			return ABI{}, fmt.Errorf("invalid abi: %s without name", e.Type)
+		}
+		if err := validate(e.Inputs); err != nil {
+			return ABI{}, fmt.Errorf("invalid abi: %s %s: %w", e.Type, e.Name, err)
+		}
+		if e.Type == "function" {
+			m := Method{Name: e.Name, Inputs: e.Inputs}
+			abi.Methods[m.Selector()] = m
+			continue
+		}
+		ev := Event{Name: e.Name, Inputs: e.Inputs, Anonymous: e.Anonymous}
+		if !ev.Anonymous {
+			abi.Events[ev.Topic()] = ev
+		}
+	}
+	return abi, nil
+}
+
+// ParseSignature parses a function signature such as "transfer(address,uint256)",
+// the inputs have no name
+func ParseSignature(signature string) (Method, error) {
+	signature = strings.TrimSpace(signature)
+	open := strings.Index(signature, "(")
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return Method{}, fmt.Errorf("invalid signature [%s]", signature)
+	}
+	inputs, err := parseList(signature[open+1 : len(signature)-1])
+	if err != nil {
+		return Method{}, fmt.Errorf("invalid signature [%s]: %w", signature, err)
+	}
+	m := Method{Name: signature[:open], Inputs: inputs}
+	if err := validate(m.Inputs); err != nil {
+		return Method{}, fmt.Errorf("invalid signature [%s]: %w", signature, err)
+	}
+	return m, nil
+}
+
+// parseList parses the comma separated types of a signature, a tuple is a parenthesized list
+func parseList(list string) ([]Argument, error) {
+	args := make([]Argument, 0)
+	if strings.TrimSpace(list) == "" {
+		return args, nil
+	}
+	depth, start := 0, 0
+	for i := 0; i <= len(list); i++ {
+		if i < len(list) {
+			switch list[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				if depth < 0 {
+					return nil, errors.New("unbalanced parentheses")
+				}
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if depth != 0 {
+			return nil, errors.New("unbalanced parentheses")
+		}
+		arg, err := parseArgument(strings.TrimSpace(list[start:i]))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		start = i + 1
+	}
+	return args, nil
+}
+
+func parseArgument(t string) (Argument, error) {
+	if !strings.HasPrefix(t, "(") {
+		return Argument{Type: t}, nil
+	}
+	end := strings.LastIndex(t, ")")
+	components, err := parseList(t[1:end])
+	if err != nil {
+		return Argument{}, err
+	}
+	return Argument{Type: "tuple" + t[end+1:], Components: components}, nil
+}
+
+// Signature returns the canonical signature of the method such as "transfer(address,uint256)"
+func (m Method) Signature() string {
+	return m.Name + "(" + canonicalList(m.Inputs) + ")"
+}
+
+// Selector returns the first 4 bytes of the keccak256 hash of the signature as a hex string
+func (m Method) Selector() string {
+	return "0x" + hex.EncodeToString(Keccak256([]byte(m.Signature()))[:4])
+}
+
+// Signature returns the canonical signature of the event such as "Transfer(address,address,uint256)"
+func (e Event) Signature() string {
+	return e.Name + "(" + canonicalList(e.Inputs) + ")"
+}
+
+// Topic returns the keccak256 hash of the signature as a hex string, the first topic of the logs
+func (e Event) Topic() string {
+	return "0x" + hex.EncodeToString(Keccak256([]byte(e.Signature())))
+}
+
+// JSON returns the JSON ABI holding the event alone
+func (e Event) JSON() ([]byte, error) {
+	return json.Marshal([]entry{{Type: "event", Name: e.Name, Inputs: e.Inputs, Anonymous: e.Anonymous}})
+}
+
+// Keccak256 returns the keccak256 hash of the data
+func Keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func canonicalList(args []Argument) string {
+	types := make([]string, 0, len(args))
+	for _, a := range args {
+		types = append(types, canonical(a))
+	}
+	return strings.Join(types, ",")
+}
+
+// canonical returns the type of the argument as written in signatures, a tuple is the
+// parenthesized list of its components and int and uint are 256 bits
+func canonical(a Argument) string {
+	base, suffix := a.Type, ""
+	if i := strings.Index(a.Type, "["); i >= 0 {
+		base, suffix = a.Type[:i], a.Type[i:]
+	}
+	switch base {
+	case "tuple":
+		base = "(" + canonicalList(a.Components) + ")"
+	case "uint", "int":
+		base += "256"
+	}
+	return base + suffix
+}
+
+// validate checks that the types of the arguments are known
+func validate(args []Argument) error {
+	for _, a := range args {
+		if _, err := parseType(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kinds of ABI types
+const (
+	kindUint = iota
+	kindInt
+	kindAddress
+	kindBool
+	kindFixedBytes
+	kindFunction
+	kindBytes
+	kindString
+	kindSlice
+	kindArray
+	kindTuple
+)
+
+// abiType defines a parsed ABI type, size is the number of bits of an integer, of bytes of
+// a fixed bytes or of elements of an array
+type abiType struct {
+	kind       int
+	size       int
+	elem       *abiType
+	components []Argument
+	fields     []*abiType
+}
+
+func parseType(a Argument) (*abiType, error) {
+	t := a.Type
+	if strings.HasSuffix(t, "]") {
+		open := strings.LastIndex(t, "[")
+		if open < 0 {
+			return nil, fmt.Errorf("invalid type [%s]", t)
+		}
+		elem, err := parseType(Argument{Type: t[:open], Components: a.Components})
+		if err != nil {
+			return nil, err
+		}
+		dim := t[open+1 : len(t)-1]
+		if dim == "" {
+			return &abiType{kind: kindSlice, elem: elem}, nil
+		}
+		n, err := strconv.Atoi(dim)
+		if err != nil || n <= 0 || n > maxHeadSize/elem.headSize() {
+			return nil, fmt.Errorf("invalid array size [%s]", t)
+		}
+		return &abiType{kind: kindArray, size: n, elem: elem}, nil
+	}
+
+	switch t {
+	case "address":
+		return &abiType{kind: kindAddress}, nil
+	case "bool":
+		return &abiType{kind: kindBool}, nil
+	case "string":
+		return &abiType{kind: kindString}, nil
+	case "bytes":
+		return &abiType{kind: kindBytes}, nil
+	case "function":
+		return &abiType{kind: kindFunction, size: 24}, nil
+	case "tuple":
+		fields := make([]*abiType, 0, len(a.Components))
+		for _, c := range a.Components {
+			field, err := parseType(c)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		tuple := &abiType{kind: kindTuple, components: a.Components, fields: fields}
+		if tuple.headSize() > maxHeadSize {
+			return nil, fmt.Errorf("tuple [%s] too large", canonical(a))
+		}
+		return tuple, nil
+	}
+
+	for _, prefix := range []string{"uint", "int"} {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		bits := 256
+		if size := t[len(prefix):]; size != "" {
+			var err error
+			if bits, err = strconv.Atoi(size); err != nil || bits <= 0 || bits > 256 || bits%8 != 0 {
+				return nil, fmt.Errorf("invalid type [%s]", t)
+			}
+		}
+		kind := kindUint
+		if prefix == "int" {
+			kind = kindInt
+		}
+		return &abiType{kind: kind, size: bits}, nil
+	}
+	if strings.HasPrefix(t, "bytes") {
+		n, err := strconv.Atoi(t[len("bytes"):])
+		if err != nil || n <= 0 || n > 32 {
+			return nil, fmt.Errorf("invalid type [%s]", t)
+		}
+		return &abiType{kind: kindFixedBytes, size: n}, nil
+	}
+	return nil, fmt.Errorf("invalid type [%s]", t)
+}
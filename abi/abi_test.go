@@ -0,0 +1,396 @@
+package abi
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	storage "github.com/tonyxu1/transactionhistory/storage"
+)
+
+const (
+	testRecipient = "0xe946502872da09009aa6dc975272ac24ab5b4f36"
+	testToken     = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	testWeth      = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+)
+
+// word returns the hex string of a number or an address left padded to 32 bytes
+func word(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return fmt.Sprintf("%064x", v)
+	case string:
+		return strings.Repeat("0", 24) + strings.TrimPrefix(v, "0x")
+	}
+	return ""
+}
+
+func TestMethod_Selector(t *testing.T) {
+	tests := []struct {
+		signature string
+		want      string
+	}{
+		{signature: "transfer(address,uint256)", want: "0xa9059cbb"},
+		{signature: "approve(address,uint)", want: "0x095ea7b3"},
+		{signature: "multicall(bytes[])", want: "0xac9650d8"},
+		{signature: "exactInputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))", want: "0x414bf389"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.signature, func(t *testing.T) {
+			m, err := ParseSignature(tt.signature)
+			if err != nil {
+				t.Fatalf("ParseSignature() error = %v", err)
+			}
+			if got := m.Selector(); got != tt.want {
+				t.Errorf("Method.Selector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   bool
+	}{
+		{name: "Without arguments", signature: "deposit()"},
+		{name: "Nested tuples", signature: "f((uint8,(bool,string)[])[2],bytes4)"},
+		{name: "Missing parentheses", signature: "transfer", wantErr: true},
+		{name: "Unbalanced tuple", signature: "f((address,uint256)", wantErr: true},
+		{name: "Unknown type", signature: "f(uint7)", wantErr: true},
+		{name: "Zero array size", signature: "f(address[0])", wantErr: true},
+		{name: "Array size overflowing the head size", signature: "f(uint256[576460752303423489])", wantErr: true},
+		{name: "Nested arrays larger than an input", signature: "f(uint256[4096][4096])", wantErr: true},
+		{name: "Array of tuples larger than an input", signature: "f((uint256,uint256)[524289])", wantErr: true},
+		{name: "Large array within an input", signature: "f(uint256[524288])"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseSignature(tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && m.Signature() != tt.signature {
+				t.Errorf("Method.Signature() = %v, want %v", m.Signature(), tt.signature)
+			}
+		})
+	}
+}
+
+func TestDecodeCall(t *testing.T) {
+	transfer := "0xa9059cbb" + word(testRecipient) + word(1000000)
+	contract, err := Parse([]byte(`[
+		{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+		{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true}]}
+	]`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		tr       common.Transaction
+		contract *ABI
+		want     *common.DecodedCall
+	}{
+		{
+			name: "Known selector",
+			tr:   common.Transaction{To: testToken, Input: transfer},
+			want: &common.DecodedCall{
+				Selector:  "0xa9059cbb",
+				Method:    "transfer",
+				Signature: "transfer(address,uint256)",
+				Args: []common.DecodedArg{
+					{Type: "address", Value: testRecipient},
+					{Type: "uint256", Value: "1000000"},
+				},
+				Source: common.DECODEDBYSELECTOR,
+			},
+		}, {
+			name:     "Registered abi names the arguments",
+			tr:       common.Transaction{To: testToken, Input: transfer},
+			contract: &contract,
+			want: &common.DecodedCall{
+				Selector:  "0xa9059cbb",
+				Method:    "transfer",
+				Signature: "transfer(address,uint256)",
+				Args: []common.DecodedArg{
+					{Name: "to", Type: "address", Value: testRecipient},
+					{Name: "amount", Type: "uint256", Value: "1000000"},
+				},
+				Source: common.DECODEDBYABI,
+			},
+		}, {
+			name: "Dynamic array",
+			tr: common.Transaction{
+				To:    testRecipient,
+				Input: "0x7ff36ab5" + word(5) + word(0x80) + word(testRecipient) + word(1700000000) + word(2) + word(testWeth) + word(testToken),
+			},
+			want: &common.DecodedCall{
+				Selector:  "0x7ff36ab5",
+				Method:    "swapExactETHForTokens",
+				Signature: "swapExactETHForTokens(uint256,address[],address,uint256)",
+				Args: []common.DecodedArg{
+					{Type: "uint256", Value: "5"},
+					{Type: "address[]", Value: []interface{}{testWeth, testToken}},
+					{Type: "address", Value: testRecipient},
+					{Type: "uint256", Value: "1700000000"},
+				},
+				Source: common.DECODEDBYSELECTOR,
+			},
+		}, {
+			name: "Truncated arguments",
+			tr:   common.Transaction{To: testToken, Input: "0xa9059cbb" + word(testRecipient)},
+			want: &common.DecodedCall{
+				Selector:  "0xa9059cbb",
+				Method:    "transfer",
+				Signature: "transfer(address,uint256)",
+				Source:    common.DECODEDBYSELECTOR,
+				Error:     "data too short",
+			},
+		}, {
+			name: "Unknown selector",
+			tr:   common.Transaction{To: testToken, Input: "0xdeadbeef" + word(1)},
+			want: &common.DecodedCall{Selector: "0xdeadbeef"},
+		}, {
+			name: "Transfer without input",
+			tr:   common.Transaction{To: testToken, Input: "0x"},
+		}, {
+			name: "Contract creation",
+			tr:   common.Transaction{Input: transfer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeCall(tt.tr, tt.contract); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeCall() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArgs(t *testing.T) {
+	// the static tuple and array are encoded in the head, the string after it
+	args := []Argument{
+		{Name: "params", Type: "tuple", Components: []Argument{{Name: "tokenIn", Type: "address"}, {Name: "fee", Type: "uint24"}}},
+		{Name: "memo", Type: "string"},
+		{Name: "flags", Type: "bool[2]"},
+	}
+	data := word(testWeth) + word(3000) + word(0xa0) + word(1) + word(0) + word(2) + fmt.Sprintf("%-64s", "6869")
+	data = strings.ReplaceAll(data, " ", "0")
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []common.DecodedArg
+		wantErr bool
+	}{
+		{
+			name: "Tuple, string and static array",
+			data: data,
+			want: []common.DecodedArg{
+				{Name: "params", Type: "(address,uint24)", Value: []common.DecodedArg{
+					{Name: "tokenIn", Type: "address", Value: testWeth},
+					{Name: "fee", Type: "uint24", Value: "3000"},
+				}},
+				{Name: "memo", Type: "string", Value: "hi"},
+				{Name: "flags", Type: "bool[2]", Value: []interface{}{true, false}},
+			},
+		}, {
+			name:    "Value out of range of uint24",
+			data:    strings.Replace(data, word(3000), word(1<<24), 1),
+			wantErr: true,
+		}, {
+			name:    "Offset out of range",
+			data:    strings.Replace(data, word(0xa0), word(0xffff), 1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := hex.DecodeString(tt.data)
+			if err != nil {
+				t.Fatalf("hex.DecodeString() error = %v", err)
+			}
+			got, err := DecodeArgs(args, raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeArgs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name           string
+		arg            common.DecodedArg
+		want           string
+		wantComponents []common.DecodedArg
+	}{
+		{name: "Integer", arg: common.DecodedArg{Name: "fee", Type: "uint24", Value: "3000"}, want: "3000"},
+		{name: "Bool", arg: common.DecodedArg{Type: "bool", Value: true}, want: "true"},
+		{
+			name:           "Array elements typed by the element type",
+			arg:            common.DecodedArg{Name: "flags", Type: "bool[2][]", Value: []interface{}{[]interface{}{true, false}}},
+			wantComponents: []common.DecodedArg{{Type: "bool[2]", Value: []interface{}{true, false}}},
+		},
+		{
+			name:           "Tuple fields",
+			arg:            common.DecodedArg{Name: "params", Type: "(address,uint24)", Value: []common.DecodedArg{{Name: "fee", Type: "uint24", Value: "3000"}}},
+			wantComponents: []common.DecodedArg{{Name: "fee", Type: "uint24", Value: "3000"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, components := Text(tt.arg)
+			if got != tt.want || !reflect.DeepEqual(components, tt.wantComponents) {
+				t.Errorf("Text() = %q, %+v, want %q, %+v", got, components, tt.want, tt.wantComponents)
+			}
+		})
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	from, to := "0x"+word(testRecipient), "0x"+word(testWeth)
+	transfer := common.Log{Address: testToken, Topics: []string{common.TRANSFERTOPIC, from, to}, Data: "0x" + word(1000000)}
+	nft := common.Log{Address: testToken, Topics: []string{common.TRANSFERTOPIC, from, to, "0x" + word(7)}, Data: "0x"}
+
+	// Named(string indexed name, address owner), the indexed string is hashed into the topic
+	named := Event{Name: "Named", Inputs: []Argument{{Name: "name", Type: "string", Indexed: true}, {Name: "owner", Type: "address"}}}
+	nameTopic := "0x" + hex.EncodeToString(Keccak256([]byte("vitalik")))
+	contract, err := Parse([]byte(`[{"type":"event","name":"Transfer","inputs":[
+		{"name":"src","type":"address","indexed":true},{"name":"dst","type":"address","indexed":true},{"name":"wad","type":"uint256"}]}]`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		log        common.Log
+		contract   *ABI
+		registered *Event
+		want       *common.DecodedLog
+	}{
+		{
+			name: "Builtin ERC-20 transfer",
+			log:  transfer,
+			want: &common.DecodedLog{
+				Topic:     common.TRANSFERTOPIC,
+				Event:     "Transfer",
+				Signature: "Transfer(address,address,uint256)",
+				Args: []common.DecodedArg{
+					{Name: "from", Type: "address", Value: testRecipient},
+					{Name: "to", Type: "address", Value: testWeth},
+					{Name: "value", Type: "uint256", Value: "1000000"},
+				},
+				Source: common.DECODEDBYEVENT,
+			},
+		}, {
+			name: "Builtin ERC-721 transfer told apart by its indexed token id",
+			log:  nft,
+			want: &common.DecodedLog{
+				Topic:     common.TRANSFERTOPIC,
+				Event:     "Transfer",
+				Signature: "Transfer(address,address,uint256)",
+				Args: []common.DecodedArg{
+					{Name: "from", Type: "address", Value: testRecipient},
+					{Name: "to", Type: "address", Value: testWeth},
+					{Name: "tokenId", Type: "uint256", Value: "7"},
+				},
+				Source: common.DECODEDBYEVENT,
+			},
+		}, {
+			name:     "Contract abi first",
+			log:      transfer,
+			contract: &contract,
+			want: &common.DecodedLog{
+				Topic:     common.TRANSFERTOPIC,
+				Event:     "Transfer",
+				Signature: "Transfer(address,address,uint256)",
+				Args: []common.DecodedArg{
+					{Name: "src", Type: "address", Value: testRecipient},
+					{Name: "dst", Type: "address", Value: testWeth},
+					{Name: "wad", Type: "uint256", Value: "1000000"},
+				},
+				Source: common.DECODEDBYABI,
+			},
+		}, {
+			name:       "Registered event with a hashed indexed string",
+			log:        common.Log{Address: testToken, Topics: []string{named.Topic(), nameTopic}, Data: "0x" + word(testWeth)},
+			registered: &named,
+			want: &common.DecodedLog{
+				Topic:     named.Topic(),
+				Event:     "Named",
+				Signature: "Named(string,address)",
+				Args: []common.DecodedArg{
+					{Name: "name", Type: "string", Value: nameTopic},
+					{Name: "owner", Type: "address", Value: testWeth},
+				},
+				Source: common.DECODEDBYEVENT,
+			},
+		}, {
+			name: "Topics not matching",
+			log:  common.Log{Address: testToken, Topics: []string{common.TRANSFERTOPIC, from}, Data: "0x"},
+			want: &common.DecodedLog{
+				Topic:     common.TRANSFERTOPIC,
+				Event:     "Transfer",
+				Signature: "Transfer(address,address,uint256)",
+				Source:    common.DECODEDBYEVENT,
+				Error:     "topics do not match the indexed arguments",
+			},
+		}, {
+			name: "Unknown topic",
+			log:  common.Log{Address: testToken, Topics: []string{nameTopic}, Data: "0x"},
+			want: &common.DecodedLog{Topic: nameTopic},
+		}, {
+			name: "Anonymous log",
+			log:  common.Log{Address: testToken, Data: "0x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeLog(tt.log, tt.contract, tt.registered); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeLog() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.Storage{}
+	named := Event{Name: "Named", Inputs: []Argument{{Name: "owner", Type: "address", Indexed: true}}}
+	data, err := named.JSON()
+	if err != nil {
+		t.Fatalf("Event.JSON() error = %v", err)
+	}
+	if err := s.SaveEventABI(ctx, common.EventABI{Topic: named.Topic(), Signature: named.Signature(), ABI: data}); err != nil {
+		t.Fatalf("Storage.SaveEventABI() error = %v", err)
+	}
+	contract := `[{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]}]`
+	if err := s.SaveABI(ctx, common.ContractABI{Address: testToken, ABI: []byte(contract)}); err != nil {
+		t.Fatalf("Storage.SaveABI() error = %v", err)
+	}
+
+	logs := []common.Log{{Address: testWeth, Topics: []string{named.Topic(), "0x" + word(testRecipient)}, Data: "0x"}}
+	trans := []common.Transaction{{To: testToken, Input: "0xa9059cbb" + word(testRecipient) + word(1), Logs: logs}}
+	got := Decode(ctx, s, trans)
+
+	if call := got[0].Decoded; call == nil || call.Source != common.DECODEDBYABI || call.Args[0].Name != "to" {
+		t.Errorf("Decode() input = %+v, want decoded with the contract abi", call)
+	}
+	if l := got[0].Logs[0].Decoded; l == nil || l.Event != "Named" || l.Args[0].Value != testRecipient {
+		t.Errorf("Decode() log = %+v, want decoded with the registered event", l)
+	}
+	if trans[0].Decoded != nil || logs[0].Decoded != nil {
+		t.Errorf("Decode() changed the transactions given")
+	}
+}
@@ -0,0 +1,138 @@
+package abi
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+)
+
+// selectorSize is the size of the function selector starting an input
+const selectorSize = 4
+
+// DecodeCall decodes the input of the transaction with the ABI of its recipient when not nil,
+// otherwise with the selector database. It returns nil for a transfer without input and for a
+// contract creation, and the selector alone when the function is unknown.
+func DecodeCall(tr common.Transaction, contract *ABI) *common.DecodedCall {
+	if tr.To == "" || len(tr.Input) < 2+2*selectorSize {
+		return nil
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(tr.Input, "0x"))
+	if err != nil {
+		return &common.DecodedCall{Selector: strings.ToLower(tr.Input[:2+2*selectorSize]), Error: "invalid input"}
+	}
+	call := &common.DecodedCall{Selector: "0x" + hex.EncodeToString(data[:selectorSize])}
+
+	if contract != nil {
+		if m, ok := contract.Methods[call.Selector]; ok {
+			decode(call, m, data[selectorSize:], common.DECODEDBYABI)
+			return call
+		}
+	}
+
+	// several functions may share the selector, the first one matching the arguments wins
+	candidates := Selectors.Lookup(call.Selector)
+	for _, m := range candidates {
+		if decode(call, m, data[selectorSize:], common.DECODEDBYSELECTOR) {
+			return call
+		}
+	}
+	if len(candidates) > 0 {
+		decode(call, candidates[0], data[selectorSize:], common.DECODEDBYSELECTOR)
+	}
+	return call
+}
+
+// decode sets the method and the arguments decoded of the call, or the decoding error
+func decode(call *common.DecodedCall, m Method, data []byte, source string) bool {
+	call.Method, call.Signature, call.Source = m.Name, m.Signature(), source
+	args, err := DecodeArgs(m.Inputs, data)
+	if err != nil {
+		call.Args, call.Error = nil, err.Error()
+		return false
+	}
+	call.Args, call.Error = args, ""
+	return true
+}
+
+// Decode returns copies of the transactions with their decoded input and logs, the ABIs
+// of the contracts and the event ABIs are read from the storage once
+func Decode(ctx context.Context, s common.Storage, trans []common.Transaction) []common.Transaction {
+	d := &decoder{ctx: ctx, s: s, contracts: make(map[string]*ABI), events: make(map[string]*Event)}
+	decoded := make([]common.Transaction, 0, len(trans))
+	for _, tr := range trans {
+		tr.Decoded = DecodeCall(tr, d.contract(tr.To))
+		if len(tr.Logs) > 0 {
+			// the logs are shared with the storage
+			logs := make([]common.Log, len(tr.Logs))
+			copy(logs, tr.Logs)
+			for i, l := range logs {
+				var registered *Event
+				if len(l.Topics) > 0 {
+					registered = d.event(l.Topics[0])
+				}
+				logs[i].Decoded = DecodeLog(l, d.contract(l.Address), registered)
+			}
+			tr.Logs = logs
+		}
+		decoded = append(decoded, tr)
+	}
+	return decoded
+}
+
+// decoder caches the ABIs read from the storage while decoding transactions
+type decoder struct {
+	ctx       context.Context
+	s         common.Storage
+	contracts map[string]*ABI
+	events    map[string]*Event
+}
+
+// contract returns the parsed ABI registered for the contract, nil when there is none
+func (d *decoder) contract(address string) *ABI {
+	address = strings.ToLower(address)
+	if address == "" {
+		return nil
+	}
+	if parsed, ok := d.contracts[address]; ok {
+		return parsed
+	}
+	d.contracts[address] = nil
+	contract, err := d.s.GetABI(d.ctx, address)
+	if err != nil {
+		return nil
+	}
+	parsed, err := Parse(contract.ABI)
+	if err != nil {
+		logging.FromContext(d.ctx).Warn("registered abi is invalid", "contract", address, logging.ErrorKey, err)
+		return nil
+	}
+	d.contracts[address] = &parsed
+	return &parsed
+}
+
+// event returns the event ABI registered for the topic, nil when there is none
+func (d *decoder) event(topic string) *Event {
+	topic = strings.ToLower(topic)
+	if ev, ok := d.events[topic]; ok {
+		return ev
+	}
+	d.events[topic] = nil
+	registered, err := d.s.GetEventABI(d.ctx, topic)
+	if err != nil {
+		return nil
+	}
+	parsed, err := Parse(registered.ABI)
+	if err != nil {
+		logging.FromContext(d.ctx).Warn("registered event abi is invalid", "topic", topic, logging.ErrorKey, err)
+		return nil
+	}
+	ev, ok := parsed.Events[topic]
+	if !ok {
+		return nil
+	}
+	d.events[topic] = &ev
+	return &ev
+}
@@ -0,0 +1,242 @@
+package abi
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+)
+
+// wordSize is the size of a word of the ABI encoding
+const wordSize = 32
+
+// maxHeadSize is the maximum size of a type in the head of the encoding, the fixed arrays
+// and tuples above it cannot fit in the input of a transaction and are rejected when parsed
+const maxHeadSize = 1 << 24
+
+var errShort = errors.New("data too short")
+
+// DecodeArgs decodes the ABI encoded arguments, the bytes after the encoding are ignored
+func DecodeArgs(args []Argument, data []byte) ([]common.DecodedArg, error) {
+	types := make([]*abiType, 0, len(args))
+	for _, a := range args {
+		t, err := parseType(a)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	values, err := decodeTuple(types, data)
+	if err != nil {
+		return nil, err
+	}
+	decoded := make([]common.DecodedArg, 0, len(args))
+	for i, a := range args {
+		decoded = append(decoded, common.DecodedArg{Name: a.Name, Type: canonical(a), Value: values[i]})
+	}
+	return decoded, nil
+}
+
+// dynamic reports whether the type is encoded after the head, at the offset held by the head
+func (t *abiType) dynamic() bool {
+	switch t.kind {
+	case kindBytes, kindString, kindSlice:
+		return true
+	case kindArray:
+		return t.elem.dynamic()
+	case kindTuple:
+		for _, f := range t.fields {
+			if f.dynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize returns the size of the type in the head of the encoding
+func (t *abiType) headSize() int {
+	if t.dynamic() {
+		return wordSize
+	}
+	switch t.kind {
+	case kindArray:
+		return t.size * t.elem.headSize()
+	case kindTuple:
+		size := 0
+		for _, f := range t.fields {
+			size += f.headSize()
+		}
+		return size
+	}
+	return wordSize
+}
+
+// decodeTuple decodes the types encoded one after the other, the offsets of the dynamic
+// types are relative to the start of data
+func decodeTuple(types []*abiType, data []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(types))
+	pos := 0
+	for _, t := range types {
+		if pos+t.headSize() > len(data) {
+			return nil, errShort
+		}
+		var value interface{}
+		var err error
+		if t.dynamic() {
+			offset, err := readSize(data[pos:], len(data))
+			if err != nil {
+				return nil, err
+			}
+			value, err = decodeValue(t, data[offset:])
+			if err != nil {
+				return nil, err
+			}
+		} else if value, err = decodeValue(t, data[pos:]); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		pos += t.headSize()
+	}
+	return values, nil
+}
+
+// readSize reads a word holding an offset or a length not greater than max
+func readSize(data []byte, max int) (int, error) {
+	if len(data) < wordSize {
+		return 0, errShort
+	}
+	n := new(big.Int).SetBytes(data[:wordSize])
+	if !n.IsInt64() || n.Int64() > int64(max) {
+		return 0, fmt.Errorf("offset or length %s out of range", n.String())
+	}
+	return int(n.Int64()), nil
+}
+
+func decodeValue(t *abiType, data []byte) (interface{}, error) {
+	switch t.kind {
+	case kindSlice:
+		n, err := readSize(data, len(data))
+		if err != nil {
+			return nil, err
+		}
+		// each element takes a word at least, which bounds the allocation to the data size
+		if n*wordSize > len(data)-wordSize {
+			return nil, errShort
+		}
+		return decodeElements(t.elem, n, data[wordSize:])
+	case kindArray:
+		if t.size > len(data)/wordSize {
+			return nil, errShort
+		}
+		return decodeElements(t.elem, t.size, data)
+	case kindTuple:
+		values, err := decodeTuple(t.fields, data)
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]common.DecodedArg, 0, len(values))
+		for i, c := range t.components {
+			fields = append(fields, common.DecodedArg{Name: c.Name, Type: canonical(c), Value: values[i]})
+		}
+		return fields, nil
+	case kindBytes, kindString:
+		n, err := readSize(data, len(data))
+		if err != nil {
+			return nil, err
+		}
+		if wordSize+n > len(data) {
+			return nil, errShort
+		}
+		b := data[wordSize : wordSize+n]
+		if t.kind == kindString {
+			return string(b), nil
+		}
+		return "0x" + hex.EncodeToString(b), nil
+	}
+
+	if len(data) < wordSize {
+		return nil, errShort
+	}
+	word := data[:wordSize]
+	switch t.kind {
+	case kindUint:
+		n := new(big.Int).SetBytes(word)
+		if n.BitLen() > t.size {
+			return nil, fmt.Errorf("uint%d out of range", t.size)
+		}
+		return n.String(), nil
+	case kindInt:
+		n := new(big.Int).SetBytes(word)
+		if word[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(t.size-1))
+		if n.Cmp(limit) >= 0 || n.Cmp(new(big.Int).Neg(limit)) < 0 {
+			return nil, fmt.Errorf("int%d out of range", t.size)
+		}
+		return n.String(), nil
+	case kindAddress:
+		if !zero(word[:12]) {
+			return nil, errors.New("address out of range")
+		}
+		return "0x" + hex.EncodeToString(word[12:]), nil
+	case kindBool:
+		if !zero(word[:31]) || word[31] > 1 {
+			return nil, errors.New("bool out of range")
+		}
+		return word[31] == 1, nil
+	case kindFixedBytes, kindFunction:
+		if !zero(word[t.size:]) {
+			return nil, fmt.Errorf("bytes%d out of range", t.size)
+		}
+		return "0x" + hex.EncodeToString(word[:t.size]), nil
+	}
+	return nil, fmt.Errorf("unknown type kind %d", t.kind)
+}
+
+// decodeElements decodes n elements of the type encoded as a tuple
+func decodeElements(elem *abiType, n int, data []byte) (interface{}, error) {
+	types := make([]*abiType, n)
+	for i := range types {
+		types[i] = elem
+	}
+	return decodeTuple(types, data)
+}
+
+func zero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Text returns the value of the decoded argument as a string, or the elements of an array or
+// the fields of a tuple with an empty string, for the APIs without values of several types
+func Text(arg common.DecodedArg) (string, []common.DecodedArg) {
+	switch v := arg.Value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []common.DecodedArg:
+		return "", v
+	case []interface{}:
+		elem := arg.Type
+		if i := strings.LastIndex(elem, "["); i > 0 {
+			elem = elem[:i]
+		}
+		elems := make([]common.DecodedArg, 0, len(v))
+		for _, e := range v {
+			elems = append(elems, common.DecodedArg{Type: elem, Value: e})
+		}
+		return "", elems
+	}
+	return fmt.Sprint(arg.Value), nil
+}
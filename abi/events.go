@@ -0,0 +1,153 @@
+package abi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+)
+
+// builtinEventsABI are the events decoded without registered ABI: token standards, wrapped
+// ether and Uniswap pairs and pools. The ERC-20 and ERC-721 Transfer and Approval share their
+// topic and differ by the number of indexed arguments.
+const builtinEventsABI = `[
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256"}]},
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"tokenId","type":"uint256","indexed":true}]},
+	{"type":"event","name":"Approval","inputs":[{"name":"owner","type":"address","indexed":true},{"name":"spender","type":"address","indexed":true},{"name":"value","type":"uint256"}]},
+	{"type":"event","name":"Approval","inputs":[{"name":"owner","type":"address","indexed":true},{"name":"approved","type":"address","indexed":true},{"name":"tokenId","type":"uint256","indexed":true}]},
+	{"type":"event","name":"ApprovalForAll","inputs":[{"name":"owner","type":"address","indexed":true},{"name":"operator","type":"address","indexed":true},{"name":"approved","type":"bool"}]},
+	{"type":"event","name":"TransferSingle","inputs":[{"name":"operator","type":"address","indexed":true},{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"id","type":"uint256"},{"name":"value","type":"uint256"}]},
+	{"type":"event","name":"TransferBatch","inputs":[{"name":"operator","type":"address","indexed":true},{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"ids","type":"uint256[]"},{"name":"values","type":"uint256[]"}]},
+	{"type":"event","name":"Deposit","inputs":[{"name":"dst","type":"address","indexed":true},{"name":"wad","type":"uint256"}]},
+	{"type":"event","name":"Withdrawal","inputs":[{"name":"src","type":"address","indexed":true},{"name":"wad","type":"uint256"}]},
+	{"type":"event","name":"Swap","inputs":[{"name":"sender","type":"address","indexed":true},{"name":"amount0In","type":"uint256"},{"name":"amount1In","type":"uint256"},{"name":"amount0Out","type":"uint256"},{"name":"amount1Out","type":"uint256"},{"name":"to","type":"address","indexed":true}]},
+	{"type":"event","name":"Swap","inputs":[{"name":"sender","type":"address","indexed":true},{"name":"recipient","type":"address","indexed":true},{"name":"amount0","type":"int256"},{"name":"amount1","type":"int256"},{"name":"sqrtPriceX96","type":"uint160"},{"name":"liquidity","type":"uint128"},{"name":"tick","type":"int24"}]},
+	{"type":"event","name":"Sync","inputs":[{"name":"reserve0","type":"uint112"},{"name":"reserve1","type":"uint112"}]}
+]`
+
+// builtinEvents holds the builtin events by topic
+var builtinEvents = func() map[string][]Event {
+	var entries []entry
+	if err := json.Unmarshal([]byte(builtinEventsABI), &entries); err != nil {
+		panic(err)
+	}
+	events := make(map[string][]Event)
+	for _, e := range entries {
+		ev := Event{Name: e.Name, Inputs: e.Inputs}
+		events[ev.Topic()] = append(events[ev.Topic()], ev)
+	}
+	return events
+}()
+
+// DecodeLog decodes the log with the event of the ABI of its contract when not nil, then with
+// the event registered for its first topic when not nil, then with the builtin events. It returns
+// nil for a log without topic, and the topic alone when the event is unknown.
+func DecodeLog(l common.Log, contract *ABI, registered *Event) *common.DecodedLog {
+	if len(l.Topics) == 0 {
+		return nil
+	}
+	decoded := &common.DecodedLog{Topic: strings.ToLower(l.Topics[0])}
+
+	type candidate struct {
+		event  Event
+		source string
+	}
+	candidates := make([]candidate, 0)
+	if contract != nil {
+		if ev, ok := contract.Events[decoded.Topic]; ok {
+			candidates = append(candidates, candidate{ev, common.DECODEDBYABI})
+		}
+	}
+	if registered != nil {
+		candidates = append(candidates, candidate{*registered, common.DECODEDBYEVENT})
+	}
+	for _, ev := range builtinEvents[decoded.Topic] {
+		candidates = append(candidates, candidate{ev, common.DECODEDBYEVENT})
+	}
+
+	// the first event matching the topics and data wins
+	for _, c := range candidates {
+		if decodeEvent(decoded, c.event, l, c.source) {
+			return decoded
+		}
+	}
+	if len(candidates) > 0 {
+		decodeEvent(decoded, candidates[0].event, l, candidates[0].source)
+	}
+	return decoded
+}
+
+// decodeEvent sets the event and the arguments decoded of the log, or the decoding error
+func decodeEvent(decoded *common.DecodedLog, ev Event, l common.Log, source string) bool {
+	decoded.Event, decoded.Signature, decoded.Source = ev.Name, ev.Signature(), source
+	args, err := eventArgs(ev, l)
+	if err != nil {
+		decoded.Args, decoded.Error = nil, err.Error()
+		return false
+	}
+	decoded.Args, decoded.Error = args, ""
+	return true
+}
+
+// eventArgs decodes the indexed arguments from the topics following the first one and the
+// others from the data, in the order of the event inputs
+func eventArgs(ev Event, l common.Log) ([]common.DecodedArg, error) {
+	indexed := make([]Argument, 0)
+	data := make([]Argument, 0)
+	for _, a := range ev.Inputs {
+		if a.Indexed {
+			indexed = append(indexed, a)
+		} else {
+			data = append(data, a)
+		}
+	}
+	if len(indexed) != len(l.Topics)-1 {
+		return nil, errors.New("topics do not match the indexed arguments")
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(l.Data, "0x"))
+	if err != nil {
+		return nil, errors.New("invalid data")
+	}
+	values, err := DecodeArgs(data, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]common.DecodedArg, 0, len(ev.Inputs))
+	for _, a := range ev.Inputs {
+		if !a.Indexed {
+			args = append(args, values[0])
+			values = values[1:]
+			continue
+		}
+		topic := strings.ToLower(l.Topics[len(l.Topics)-len(indexed)])
+		indexed = indexed[1:]
+		value, err := topicValue(a, topic)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, common.DecodedArg{Name: a.Name, Type: canonical(a), Value: value})
+	}
+	return args, nil
+}
+
+// topicValue decodes an indexed argument, the topic of a dynamic, array or tuple type
+// is the hash of its encoding and returned as is
+func topicValue(a Argument, topic string) (interface{}, error) {
+	word, err := hex.DecodeString(strings.TrimPrefix(topic, "0x"))
+	if err != nil || len(word) != wordSize {
+		return nil, errors.New("invalid topic")
+	}
+	t, err := parseType(a)
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case kindBytes, kindString, kindSlice, kindArray, kindTuple:
+		return topic, nil
+	}
+	return decodeValue(t, word)
+}
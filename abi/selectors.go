@@ -0,0 +1,134 @@
+package abi
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// builtin are the signatures the selector database starts with: token standards, wrapped ether,
+// routers and multicalls
+var builtin = []string{
+	// ERC-20
+	"transfer(address,uint256)",
+	"transferFrom(address,address,uint256)",
+	"approve(address,uint256)",
+	"increaseAllowance(address,uint256)",
+	"decreaseAllowance(address,uint256)",
+	"permit(address,address,uint256,uint256,uint8,bytes32,bytes32)",
+	"mint(address,uint256)",
+	"burn(uint256)",
+	"burnFrom(address,uint256)",
+	// ERC-721 and ERC-1155
+	"safeTransferFrom(address,address,uint256)",
+	"safeTransferFrom(address,address,uint256,bytes)",
+	"setApprovalForAll(address,bool)",
+	"safeTransferFrom(address,address,uint256,uint256,bytes)",
+	"safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)",
+	// wrapped ether
+	"deposit()",
+	"withdraw(uint256)",
+	// Uniswap V2 router
+	"swapExactTokensForTokens(uint256,uint256,address[],address,uint256)",
+	"swapTokensForExactTokens(uint256,uint256,address[],address,uint256)",
+	"swapExactETHForTokens(uint256,address[],address,uint256)",
+	"swapTokensForExactETH(uint256,uint256,address[],address,uint256)",
+	"swapExactTokensForETH(uint256,uint256,address[],address,uint256)",
+	"swapETHForExactTokens(uint256,address[],address,uint256)",
+	"swapExactTokensForTokensSupportingFeeOnTransferTokens(uint256,uint256,address[],address,uint256)",
+	"swapExactETHForTokensSupportingFeeOnTransferTokens(uint256,address[],address,uint256)",
+	"swapExactTokensForETHSupportingFeeOnTransferTokens(uint256,uint256,address[],address,uint256)",
+	"addLiquidity(address,address,uint256,uint256,uint256,uint256,address,uint256)",
+	"addLiquidityETH(address,uint256,uint256,uint256,address,uint256)",
+	"removeLiquidity(address,address,uint256,uint256,uint256,address,uint256)",
+	"removeLiquidityETH(address,uint256,uint256,uint256,address,uint256)",
+	// Uniswap V3 router and universal router
+	"exactInputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))",
+	"exactInput((bytes,address,uint256,uint256,uint256))",
+	"exactOutputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))",
+	"exactOutput((bytes,address,uint256,uint256,uint256))",
+	"multicall(bytes[])",
+	"multicall(uint256,bytes[])",
+	"execute(bytes,bytes[])",
+	"execute(bytes,bytes[],uint256)",
+	// Multicall3
+	"aggregate((address,bytes)[])",
+	"aggregate3((address,bool,bytes)[])",
+	"aggregate3Value((address,bool,uint256,bytes)[])",
+}
+
+// Database holds the known functions by selector, several functions may share a selector
+type Database struct {
+	mu      sync.RWMutex
+	methods map[string][]Method
+}
+
+// Selectors is the selector database used to decode the inputs of the contracts without ABI
+var Selectors = NewDatabase()
+
+// NewDatabase returns a selector database holding the builtin signatures
+func NewDatabase() *Database {
+	d := &Database{methods: make(map[string][]Method)}
+	for _, signature := range builtin {
+		if err := d.Add(signature); err != nil {
+			panic(err)
+		}
+	}
+	return d
+}
+
+// Add adds the function signature to the database, a signature already known is skipped
+func (d *Database) Add(signature string) error {
+	m, err := ParseSignature(signature)
+	if err != nil {
+		return err
+	}
+	selector := m.Selector()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, known := range d.methods[selector] {
+		if known.Signature() == m.Signature() {
+			return nil
+		}
+	}
+	d.methods[selector] = append(d.methods[selector], m)
+	return nil
+}
+
+// Load adds the signatures read one per line, blank lines and lines starting with # are
+// skipped. It returns the number of signatures read before the first invalid one.
+func (d *Database) Load(r io.Reader) (int, error) {
+	n := 0
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := d.Add(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, lines.Err()
+}
+
+// LoadFile adds the signatures of the file as Load
+func (d *Database) LoadFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return d.Load(f)
+}
+
+// Lookup returns the functions with the selector, in the order they were added
+func (d *Database) Lookup(selector string) []Method {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.methods[strings.ToLower(selector)]
+}
@@ -0,0 +1,354 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// Entry types of an archive
+const (
+	ACCOUNT       = "account"
+	TRANSACTION   = "transaction"
+	TOKENTRANSFER = "token_transfer"
+	ABI           = "abi"
+	EVENTABI      = "event_abi"
+	HEADER        = "header"
+	TOKEN         = "token"
+)
+
+// importBatchSize is the number of transactions saved at once while importing
+const importBatchSize = 500
+
+// Entry is a line of a JSON Lines archive, an account entry with its checkpoint
+// comes before the transactions and token transfers of the account, contract ABIs, event ABIs,
+// block headers and token metadata come last
+type Entry struct {
+	Type          string                     `json:"type"`
+	Address       string                     `json:"address,omitempty"`
+	Checkpoint    int                        `json:"checkpoint,omitempty"`
+	TokenBlock    int                        `json:"token_block,omitempty"`
+	Retention     *common.RetentionPolicy    `json:"retention,omitempty"`
+	Balances      []common.BalanceCheckpoint `json:"balances,omitempty"`
+	TokenBalances []common.TokenCheckpoint   `json:"token_balances,omitempty"`
+	Transaction   *common.Transaction        `json:"transaction,omitempty"`
+	TokenTransfer *common.TokenTransfer      `json:"token_transfer,omitempty"`
+	ABI           *common.ContractABI        `json:"abi,omitempty"`
+	EventABI      *common.EventABI           `json:"event_abi,omitempty"`
+	Header        *common.Header             `json:"header,omitempty"`
+	Token         *common.Token              `json:"token,omitempty"`
+}
+
+// Stats reports the outcome of an import
+type Stats struct {
+	Accounts       int `json:"accounts"`
+	Transactions   int `json:"transactions"`
+	TokenTransfers int `json:"token_transfers"`
+	Duplicates     int `json:"duplicates"`
+	Invalid        int `json:"invalid"`
+	ABIs           int `json:"abis"`
+	EventABIs      int `json:"event_abis"`
+	Headers        int `json:"headers"`
+	Tokens         int `json:"tokens"`
+}
+
+// Write writes the accounts of the storage with their checkpoints, transactions and token
+// transfers, oldest first, the registered contract and event ABIs, the cached block headers and
+// the cached token metadata as a JSON Lines archive
+func Write(ctx context.Context, w io.Writer, s common.Storage) error {
+	addresses, err := s.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, address := range addresses {
+		checkpoint, err := s.GetCurrentBlock(ctx, address)
+		if err != nil {
+			// the account was removed meanwhile
+			continue
+		}
+		trans, err := s.GetTransactions(ctx, address)
+		if err != nil {
+			continue
+		}
+		account := Entry{Type: ACCOUNT, Address: address, Checkpoint: checkpoint}
+		// the token block is only written while it is behind the checkpoint
+		if block, err := s.GetTokenBlock(ctx, address); err == nil && block != checkpoint {
+			account.TokenBlock = block
+		}
+		if policy, err := s.GetRetention(ctx, address); err == nil && policy != (common.RetentionPolicy{}) {
+			account.Retention = &policy
+		}
+		if checkpoints, err := s.GetBalances(ctx, address); err == nil && len(checkpoints) > 0 {
+			account.Balances = checkpoints
+		}
+		if checkpoints, err := s.GetTokenCheckpoints(ctx, address); err == nil && len(checkpoints) > 0 {
+			account.TokenBalances = checkpoints
+		}
+		if err := writeAccount(enc, account, trans); err != nil {
+			return err
+		}
+		transfers, err := s.GetTokenTransfers(ctx, address)
+		if err != nil {
+			continue
+		}
+		for i := range transfers {
+			if err := enc.Encode(Entry{Type: TOKENTRANSFER, Address: address, TokenTransfer: &transfers[i]}); err != nil {
+				return err
+			}
+		}
+	}
+
+	abis, err := s.GetABIs(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range abis {
+		if err := enc.Encode(Entry{Type: ABI, ABI: &abis[i]}); err != nil {
+			return err
+		}
+	}
+	events, err := s.GetEventABIs(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		if err := enc.Encode(Entry{Type: EVENTABI, EventABI: &events[i]}); err != nil {
+			return err
+		}
+	}
+
+	headers, err := s.GetHeaders(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range headers {
+		if err := enc.Encode(Entry{Type: HEADER, Header: &headers[i]}); err != nil {
+			return err
+		}
+	}
+
+	tokens, err := s.GetTokens(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range tokens {
+		if err := enc.Encode(Entry{Type: TOKEN, Token: &tokens[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAccount writes the account entry followed by the transactions of the account, given
+// ordered by block number descending as stored, oldest first
+func WriteAccount(w io.Writer, account Entry, trans []common.Transaction) error {
+	return writeAccount(json.NewEncoder(w), account, trans)
+}
+
+func writeAccount(enc *json.Encoder, account Entry, trans []common.Transaction) error {
+	if err := enc.Encode(account); err != nil {
+		return err
+	}
+	for i := len(trans) - 1; i >= 0; i-- {
+		if err := enc.Encode(Entry{Type: TRANSACTION, Address: account.Address, Transaction: &trans[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import loads a JSON Lines archive into the storage. Accounts not subscribed yet are
+// created at their archived checkpoint, the checkpoint of an existing account only moves
+// forward, the archived retention policy replaces the one of the account, the archived
+// balance checkpoints replace those of the same blocks and the archived contract and event
+// ABIs the registered ones. Transactions with an invalid hash or not sent from or to their
+// account are skipped, as well as the transactions and token transfers already stored.
+func Import(ctx context.Context, r io.Reader, s common.Storage) (Stats, error) {
+	stats := Stats{}
+	logger := logging.FromContext(ctx)
+
+	var address string
+	// block hash and hash of the transactions imported for the account
+	seen := make(map[string]struct{})
+	batch := make([]common.Transaction, 0, importBatchSize)
+	transfers := make([]common.TokenTransfer, 0, importBatchSize)
+	flush := func() error {
+		if len(transfers) > 0 {
+			err := s.SaveTokenTransfers(ctx, address, transfers)
+			transfers = transfers[:0]
+			if err != nil {
+				return err
+			}
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.SaveTransactions(ctx, address, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return stats, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+
+		switch e.Type {
+		case ACCOUNT:
+			if err := flush(); err != nil {
+				return stats, err
+			}
+			if err := importAccount(ctx, s, e); err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			address = e.Address
+			seen = make(map[string]struct{})
+			stats.Accounts++
+		case TRANSACTION:
+			if e.Address != address {
+				return stats, fmt.Errorf("line %d: transaction of [%s] before its account", line, e.Address)
+			}
+			if err := validate(e); err != nil {
+				logger.Warn("invalid transaction skipped", logging.AddressKey, e.Address, "line", line, logging.ErrorKey, err)
+				stats.Invalid++
+				continue
+			}
+			key := e.Transaction.BlockHash + "|" + e.Transaction.Hash
+			if _, ok := seen[key]; ok {
+				stats.Duplicates++
+				continue
+			}
+			seen[key] = struct{}{}
+			if stored, err := s.GetTransaction(ctx, address, e.Transaction.Hash); err == nil && stored.BlockHash == e.Transaction.BlockHash {
+				stats.Duplicates++
+				continue
+			}
+			batch = append(batch, *e.Transaction)
+			stats.Transactions++
+			if len(batch) == importBatchSize {
+				if err := flush(); err != nil {
+					return stats, err
+				}
+			}
+		case TOKENTRANSFER:
+			if e.Address != address {
+				return stats, fmt.Errorf("line %d: token transfer of [%s] before its account", line, e.Address)
+			}
+			if e.TokenTransfer == nil {
+				return stats, fmt.Errorf("line %d: missing token transfer", line)
+			}
+			transfers = append(transfers, *e.TokenTransfer)
+			stats.TokenTransfers++
+			if len(transfers) == importBatchSize {
+				if err := flush(); err != nil {
+					return stats, err
+				}
+			}
+		case ABI:
+			if e.ABI == nil {
+				return stats, fmt.Errorf("line %d: missing abi", line)
+			}
+			if err := s.SaveABI(ctx, *e.ABI); err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.ABIs++
+		case EVENTABI:
+			if e.EventABI == nil {
+				return stats, fmt.Errorf("line %d: missing event abi", line)
+			}
+			if err := s.SaveEventABI(ctx, *e.EventABI); err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.EventABIs++
+		case HEADER:
+			if e.Header == nil {
+				return stats, fmt.Errorf("line %d: missing header", line)
+			}
+			if err := s.SaveHeader(ctx, *e.Header); err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.Headers++
+		case TOKEN:
+			if e.Token == nil {
+				return stats, fmt.Errorf("line %d: missing token", line)
+			}
+			if err := s.SaveToken(ctx, *e.Token); err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.Tokens++
+		default:
+			return stats, fmt.Errorf("line %d: unknown entry type [%s]", line, e.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	return stats, flush()
+}
+
+// importAccount creates the account at its checkpoint and token block or moves the checkpoint of
+// the existing account forward, then sets the archived retention policy, balance and token
+// balance checkpoints
+func importAccount(ctx context.Context, s common.Storage, e Entry) error {
+	current, err := s.GetCurrentBlock(ctx, e.Address)
+	if err != nil {
+		err = s.RestoreAccount(ctx, e.Address, e.Checkpoint)
+		if err == nil && e.TokenBlock > 0 {
+			err = s.SaveTokenBlock(ctx, e.Address, e.TokenBlock)
+		}
+	} else if e.Checkpoint > current {
+		err = s.SaveCheckpoint(ctx, e.Address, e.Checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+	if e.Retention != nil {
+		if err := s.SetRetention(ctx, e.Address, *e.Retention); err != nil {
+			return err
+		}
+	}
+	for _, checkpoint := range e.Balances {
+		if err := s.SaveBalance(ctx, e.Address, checkpoint); err != nil {
+			return err
+		}
+	}
+	for _, checkpoint := range e.TokenBalances {
+		if err := s.SaveTokenCheckpoint(ctx, e.Address, checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the hashes of the transaction and that it belongs to its account
+func validate(e Entry) error {
+	if e.Transaction == nil {
+		return fmt.Errorf("missing transaction")
+	}
+	if err := util.ValidateHash(e.Transaction.Hash); err != nil {
+		return err
+	}
+	if err := util.ValidateHash(e.Transaction.BlockHash); err != nil {
+		return err
+	}
+	if _, ok := util.MatchAccount(*e.Transaction, e.Address); !ok {
+		return fmt.Errorf("transaction [%s] is not sent from or to the account", e.Transaction.Hash)
+	}
+	return nil
+}
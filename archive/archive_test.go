@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	storage "github.com/tonyxu1/transactionhistory/storage"
+)
+
+const (
+	address1 = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	address2 = "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"
+	token    = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+)
+
+func hash(n int) string {
+	return fmt.Sprintf("0x%064x", n)
+}
+
+func seeded(t *testing.T) *storage.Storage {
+	s := &storage.Storage{}
+	ctx := context.Background()
+	for i, address := range []string{address1, address2} {
+		if err := s.RestoreAccount(ctx, address, 15000000+i); err != nil {
+			t.Fatalf("Storage.RestoreAccount() error = %v", err)
+		}
+	}
+	err := s.SaveTransactions(ctx, address1, []common.Transaction{
+		{BlockHash: hash(0xb1), BlockNumber: "0x10", Hash: hash(1), From: address1, To: address2},
+		{BlockHash: hash(0xb2), BlockNumber: "0x11", Hash: hash(2), From: address2, To: address1},
+	})
+	if err != nil {
+		t.Fatalf("Storage.SaveTransactions() error = %v", err)
+	}
+	if err := s.SaveBalance(ctx, address1, common.BalanceCheckpoint{Block: 0x11, Balance: "1000"}); err != nil {
+		t.Fatalf("Storage.SaveBalance() error = %v", err)
+	}
+	err = s.SaveTokenTransfers(ctx, address1, []common.TokenTransfer{
+		{Token: token, From: address2, To: address1, Value: "500", BlockNumber: 0x11, BlockHash: hash(0xb2), TransactionHash: hash(3), LogIndex: 1},
+	})
+	if err != nil {
+		t.Fatalf("Storage.SaveTokenTransfers() error = %v", err)
+	}
+	checkpoint := common.TokenCheckpoint{Token: token, BalanceCheckpoint: common.BalanceCheckpoint{Block: 0x11, Balance: "500"}}
+	if err := s.SaveTokenCheckpoint(ctx, address1, checkpoint); err != nil {
+		t.Fatalf("Storage.SaveTokenCheckpoint() error = %v", err)
+	}
+	if err := s.SaveTokenBlock(ctx, address1, 14999990); err != nil {
+		t.Fatalf("Storage.SaveTokenBlock() error = %v", err)
+	}
+	if err := s.SaveABI(ctx, common.ContractABI{Address: token, ABI: []byte(`[]`)}); err != nil {
+		t.Fatalf("Storage.SaveABI() error = %v", err)
+	}
+	if err := s.SaveEventABI(ctx, common.EventABI{Topic: common.TRANSFERTOPIC, ABI: []byte(`[]`)}); err != nil {
+		t.Fatalf("Storage.SaveEventABI() error = %v", err)
+	}
+	if err := s.SaveToken(ctx, common.Token{Address: token, Symbol: "USDC", Name: "USD Coin", Decimals: 6}); err != nil {
+		t.Fatalf("Storage.SaveToken() error = %v", err)
+	}
+	return s
+}
+
+func TestWrite_Import(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := Write(ctx, &buf, seeded(t)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		target  func() *storage.Storage
+		want    Stats
+		wantErr bool
+	}{
+		{
+			name:   "Empty storage",
+			target: func() *storage.Storage { return &storage.Storage{} },
+			want:   Stats{Accounts: 2, Transactions: 2, TokenTransfers: 1, ABIs: 1, EventABIs: 1, Tokens: 1},
+		}, {
+			name:   "Duplicates skipped",
+			target: func() *storage.Storage { return seeded(t) },
+			want:   Stats{Accounts: 2, Duplicates: 2, TokenTransfers: 1, ABIs: 1, EventABIs: 1, Tokens: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.target()
+			got, err := Import(ctx, bytes.NewReader(buf.Bytes()), s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Import() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Import() = %+v, want %+v", got, tt.want)
+			}
+			checkpoint, err := s.GetCurrentBlock(ctx, address2)
+			if err != nil || checkpoint != 15000001 {
+				t.Errorf("Import() checkpoint = %v, %v, want 15000001", checkpoint, err)
+			}
+			trans, _ := s.GetTransactions(ctx, address1)
+			want := []string{hash(2), hash(1)}
+			if got := []string{trans[0].Hash, trans[1].Hash}; len(trans) != 2 || !reflect.DeepEqual(got, want) {
+				t.Errorf("Import() transactions = %v, want %v", trans, want)
+			}
+			balances, _ := s.GetBalances(ctx, address1)
+			if len(balances) != 1 || balances[0].Balance != "1000" {
+				t.Errorf("Import() balances = %v, want one checkpoint of 1000", balances)
+			}
+			transfers, _ := s.GetTokenTransfers(ctx, address1)
+			if len(transfers) != 1 || transfers[0].Value != "500" {
+				t.Errorf("Import() token transfers = %v, want one transfer of 500", transfers)
+			}
+			if block, err := s.GetTokenBlock(ctx, address1); err != nil || block != 14999990 {
+				t.Errorf("Import() token block = %v, %v, want 14999990", block, err)
+			}
+			if block, err := s.GetTokenBlock(ctx, address2); err != nil || block != 15000001 {
+				t.Errorf("Import() token block = %v, %v, want the checkpoint 15000001", block, err)
+			}
+			tokenBalances, _ := s.GetTokenCheckpoints(ctx, address1)
+			if len(tokenBalances) != 1 || tokenBalances[0].Token != token {
+				t.Errorf("Import() token balances = %v, want one checkpoint of %s", tokenBalances, token)
+			}
+			if _, err := s.GetABI(ctx, token); err != nil {
+				t.Errorf("Import() abi of %s error = %v", token, err)
+			}
+			if _, err := s.GetEventABI(ctx, common.TRANSFERTOPIC); err != nil {
+				t.Errorf("Import() event abi error = %v", err)
+			}
+			if got, err := s.GetToken(ctx, token); err != nil || got.Symbol != "USDC" || got.Decimals != 6 {
+				t.Errorf("Import() token = %+v, %v, want USDC with 6 decimals", got, err)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	account := `{"type":"account","address":"` + address1 + `","checkpoint":100}` + "\n"
+	tests := []struct {
+		name    string
+		archive string
+		want    Stats
+		wantErr bool
+	}{
+		{
+			name:    "Invalid hash skipped",
+			archive: account + `{"type":"transaction","address":"` + address1 + `","transaction":{"hash":"0x01","blockHash":"` + hash(1) + `","to":"` + address1 + `"}}` + "\n",
+			want:    Stats{Accounts: 1, Invalid: 1},
+		}, {
+			name:    "Transaction of another account skipped",
+			archive: account + `{"type":"transaction","address":"` + address1 + `","transaction":{"hash":"` + hash(1) + `","blockHash":"` + hash(1) + `","to":"` + address2 + `"}}` + "\n",
+			want:    Stats{Accounts: 1, Invalid: 1},
+		}, {
+			name:    "Transaction before its account",
+			archive: `{"type":"transaction","address":"` + address1 + `","transaction":{}}` + "\n",
+			wantErr: true,
+		}, {
+			name:    "Malformed line",
+			archive: account + "{not json\n",
+			want:    Stats{Accounts: 1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Import(context.Background(), strings.NewReader(tt.archive), &storage.Storage{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Import() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Import() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
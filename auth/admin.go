@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	network "github.com/tonyxu1/transactionhistory/network"
+)
+
+// AdminOnly : middleware rejecting the requests that do not carry the admin token,
+// all requests are rejected when adminToken is empty
+func AdminOnly(adminToken string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "admin api is disabled", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(ADMINTOKENHEADER)), []byte(adminToken)) != 1 {
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// AdminHandler : admin endpoint to issue (POST), list (GET) and revoke (DELETE) API keys,
+// requests must carry the admin token. Accounts no longer owned by any key are
+// removed from the storage of their network when a key is revoked.
+func AdminHandler(ks *KeyStore, chains *network.Set, adminToken string) http.HandlerFunc {
+	return AdminOnly(adminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, ks.Keys())
+		case http.MethodPost:
+			maxSubscriptions := common.MAXSUBSCRIPTIONSPERKEY
+			if v := r.URL.Query().Get("max_subscriptions"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					http.Error(w, "invalid max_subscriptions: "+v, http.StatusBadRequest)
+					return
+				}
+				maxSubscriptions = n
+			}
+			key, err := ks.Issue(r.URL.Query().Get("name"), maxSubscriptions)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, key)
+		case http.MethodDelete:
+			// the orphans are removed before a snapshot sees the key revoked
+			defer ks.change()()
+			orphans, err := ks.Revoke(r.URL.Query().Get("key"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			for _, sub := range orphans {
+				c, err := chains.Get(sub.Chain)
+				if err == nil {
+					err = c.Storage.RemoveAccount(c.Context(r.Context()), sub.Address)
+				}
+				if err != nil {
+					logging.FromContext(r.Context()).Error("remove account failed", logging.ChainKey, sub.Chain, logging.AddressKey, sub.Address, logging.ErrorKey, err)
+				}
+			}
+			writeJSON(w, map[string]string{"message": "revocation succeed"})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// HTTP header and gRPC metadata key carrying the API key
+	APIKEYHEADER = "X-API-Key"
+
+	// HTTP header carrying the admin token for the admin API
+	ADMINTOKENHEADER = "X-Admin-Token"
+)
+
+type contextKey struct{}
+
+// Subscription identifies a subscribed account by network name and address
+type Subscription struct {
+	Chain   string `json:"chain"`
+	Address string `json:"address"`
+}
+
+// Key defines an API key and the accounts subscribed with it, the quota
+// counts the subscriptions of all the networks
+type Key struct {
+	Token            string    `json:"key"`
+	Name             string    `json:"name"`
+	MaxSubscriptions int       `json:"max_subscriptions"`
+	Subscriptions    int       `json:"subscriptions"`
+	CreatedAt        time.Time `json:"created_at"`
+
+	subscriptions map[Subscription]struct{}
+}
+
+// KeyStore keeps the issued API keys in memory
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+
+	// held by the subscription changes spanning the keys and a storage, locked by View
+	changes sync.RWMutex
+}
+
+// NewKeyStore initiate an empty key store
+func NewKeyStore() *KeyStore {
+	return &KeyStore{keys: make(map[string]*Key)}
+}
+
+// Issue creates a new API key allowed to subscribe up to maxSubscriptions addresses
+func (ks *KeyStore) Issue(name string, maxSubscriptions int) (Key, error) {
+	if maxSubscriptions < 0 {
+		return Key{}, fmt.Errorf("max subscriptions [%d] cannot be negative", maxSubscriptions)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Key{}, err
+	}
+
+	key := &Key{
+		Token:            hex.EncodeToString(b),
+		Name:             name,
+		MaxSubscriptions: maxSubscriptions,
+		CreatedAt:        time.Now().UTC(),
+		subscriptions:    make(map[Subscription]struct{}),
+	}
+	ks.mu.Lock()
+	ks.keys[key.Token] = key
+	ks.mu.Unlock()
+	return *key, nil
+}
+
+// Revoke deletes the API key and returns the subscriptions no longer owned by any key
+func (ks *KeyStore) Revoke(token string) ([]Subscription, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[token]
+	if !ok {
+		return nil, errors.New("api key does not exist")
+	}
+	delete(ks.keys, token)
+
+	orphans := make([]Subscription, 0)
+	for sub := range key.subscriptions {
+		if !ks.isOwnedLocked(sub) {
+			orphans = append(orphans, sub)
+		}
+	}
+	return orphans, nil
+}
+
+// Keys returns all issued keys
+func (ks *KeyStore) Keys() []Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]Key, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		k := *key
+		k.Subscriptions = len(key.subscriptions)
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Record defines an API key with the accounts subscribed with it, as saved in snapshots
+type Record struct {
+	Key
+	Accounts []Subscription `json:"accounts"`
+}
+
+// Records returns all issued keys with their subscriptions
+func (ks *KeyStore) Records() []Record {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	records := make([]Record, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		record := Record{Key: *key, Accounts: make([]Subscription, 0, len(key.subscriptions))}
+		record.Subscriptions = len(key.subscriptions)
+		record.subscriptions = nil
+		for sub := range key.subscriptions {
+			record.Accounts = append(record.Accounts, sub)
+		}
+		sort.Slice(record.Accounts, func(i, j int) bool {
+			if record.Accounts[i].Chain != record.Accounts[j].Chain {
+				return record.Accounts[i].Chain < record.Accounts[j].Chain
+			}
+			return record.Accounts[i].Address < record.Accounts[j].Address
+		})
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Token < records[j].Token })
+	return records
+}
+
+// Restore adds the keys with their subscriptions, a key already issued is replaced
+func (ks *KeyStore) Restore(records []Record) error {
+	for _, record := range records {
+		if record.Token == "" {
+			return errors.New("api key cannot be empty")
+		}
+		if record.MaxSubscriptions < 0 {
+			return fmt.Errorf("max subscriptions [%d] cannot be negative", record.MaxSubscriptions)
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, record := range records {
+		key := record.Key
+		key.Subscriptions = 0
+		key.subscriptions = make(map[Subscription]struct{}, len(record.Accounts))
+		for _, sub := range record.Accounts {
+			key.subscriptions[sub] = struct{}{}
+		}
+		ks.keys[key.Token] = &key
+	}
+	return nil
+}
+
+// View waits for the subscription changes in progress and blocks the next ones until the
+// returned function is called, the keys then own exactly the accounts of the storages, e.g.
+// while a snapshot of both is saved
+func (ks *KeyStore) View() func() {
+	ks.changes.Lock()
+	return ks.changes.Unlock
+}
+
+// change marks a subscription change in progress until the returned function is called
+func (ks *KeyStore) change() func() {
+	ks.changes.RLock()
+	return ks.changes.RUnlock
+}
+
+// Valid checks the API key has been issued and not revoked
+func (ks *KeyStore) Valid(token string) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	_, ok := ks.keys[token]
+	return ok
+}
+
+// Owns checks the address of the network is subscribed with the API key
+func (ks *KeyStore) Owns(token, chain, address string) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[token]
+	if !ok {
+		return false
+	}
+	_, ok = key.subscriptions[Subscription{Chain: chain, Address: address}]
+	return ok
+}
+
+// Addresses returns the addresses of the network subscribed with the API key
+func (ks *KeyStore) Addresses(token, chain string) []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[token]
+	if !ok {
+		return []string{}
+	}
+	addresses := make([]string, 0, len(key.subscriptions))
+	for sub := range key.subscriptions {
+		if sub.Chain == chain {
+			addresses = append(addresses, sub.Address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// IsOwned checks the address of the network is subscribed with any API key
+func (ks *KeyStore) IsOwned(chain, address string) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.isOwnedLocked(Subscription{Chain: chain, Address: address})
+}
+
+func (ks *KeyStore) isOwnedLocked(sub Subscription) bool {
+	for _, key := range ks.keys {
+		if _, ok := key.subscriptions[sub]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Claim records the address of the network as subscribed with the API key, error is
+// returned when the key already owns the address or its quota is reached
+func (ks *KeyStore) Claim(token, chain, address string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[token]
+	if !ok {
+		return errors.New("api key does not exist")
+	}
+	sub := Subscription{Chain: chain, Address: address}
+	if _, ok := key.subscriptions[sub]; ok {
+		return fmt.Errorf("account for address [%s] already subscribed", address)
+	}
+	if len(key.subscriptions) >= key.MaxSubscriptions {
+		return fmt.Errorf("subscription quota [%d] reached for api key", key.MaxSubscriptions)
+	}
+	key.subscriptions[sub] = struct{}{}
+	return nil
+}
+
+// Release removes the address of the network from the API key and reports whether
+// the address is still owned by other keys
+func (ks *KeyStore) Release(token, chain, address string) (bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[token]
+	if !ok {
+		return false, errors.New("api key does not exist")
+	}
+	sub := Subscription{Chain: chain, Address: address}
+	if _, ok := key.subscriptions[sub]; !ok {
+		return false, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+	delete(key.subscriptions, sub)
+	return ks.isOwnedLocked(sub), nil
+}
+
+// WithKey returns a copy of ctx carrying the API key
+func WithKey(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, contextKey{}, token)
+}
+
+// KeyFromContext returns the API key carried by ctx
+func KeyFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(contextKey{}).(string)
+	return token, ok
+}
+
+// Authenticate rejects requests without a valid API key, the key is
+// carried by the request context for the next handler
+func (ks *KeyStore) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(APIKEYHEADER)
+		if token == "" || !ks.Valid(token) {
+			http.Error(w, "invalid or missing api key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithKey(r.Context(), token)))
+	})
+}
+
+// UnaryServerInterceptor rejects gRPC calls without a valid API key in metadata
+func (ks *KeyStore) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := ks.authenticateGRPC(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects gRPC streams without a valid API key in metadata
+func (ks *KeyStore) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := ks.authenticateGRPC(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (ks *KeyStore) authenticateGRPC(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(APIKEYHEADER)
+	if len(values) == 0 || !ks.Valid(values[0]) {
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing api key")
+	}
+	return WithKey(ctx, values[0]), nil
+}
+
+// serverStream overrides the context of grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+)
+
+const (
+	address1 = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	address2 = "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"
+)
+
+// mockStorage implements the methods of common.Storage used by the tests without reaching
+// the chain, the embedded nil common.Storage panics on the others
+type mockStorage struct {
+	common.Storage
+	accounts map[string]int
+}
+
+func (m *mockStorage) CreateAccount(ctx context.Context, address string) error {
+	if _, ok := m.accounts[address]; ok {
+		return errors.New("already subscribed")
+	}
+	m.accounts[address] = 14000000
+	return nil
+}
+
+func (m *mockStorage) RemoveAccount(ctx context.Context, address string) error {
+	if _, ok := m.accounts[address]; !ok {
+		return errors.New("does not exist")
+	}
+	delete(m.accounts, address)
+	return nil
+}
+
+func (m *mockStorage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
+	block, ok := m.accounts[address]
+	if !ok {
+		return -1, errors.New("does not exist")
+	}
+	return block, nil
+}
+
+func TestKeyStore_Claim(t *testing.T) {
+	tests := []struct {
+		name      string
+		claimed   []string
+		address   string
+		wantErr   bool
+		wantOwner bool
+	}{
+		{
+			name:      "Address claimed",
+			address:   address1,
+			wantErr:   false,
+			wantOwner: true,
+		}, {
+			name:      "Address already claimed",
+			claimed:   []string{address1},
+			address:   address1,
+			wantErr:   true,
+			wantOwner: true,
+		}, {
+			name:      "Quota reached",
+			claimed:   []string{address1},
+			address:   address2,
+			wantErr:   true,
+			wantOwner: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := NewKeyStore()
+			key, err := ks.Issue("test", 1)
+			if err != nil {
+				t.Errorf("ks.Issue() error : %v", err)
+				return
+			}
+			for _, address := range tt.claimed {
+				if err := ks.Claim(key.Token, common.DEFAULTNETWORK, address); err != nil {
+					t.Errorf("ks.Claim() error : %v", err)
+				}
+			}
+			if err := ks.Claim(key.Token, common.DEFAULTNETWORK, tt.address); (err != nil) != tt.wantErr {
+				t.Errorf("KeyStore.Claim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := ks.Owns(key.Token, common.DEFAULTNETWORK, tt.address); got != tt.wantOwner {
+				t.Errorf("KeyStore.Owns() = %v, want %v", got, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestKeyStore_Revoke(t *testing.T) {
+	ks := NewKeyStore()
+	key1, _ := ks.Issue("key1", 10)
+	key2, _ := ks.Issue("key2", 10)
+	ks.Claim(key1.Token, common.DEFAULTNETWORK, address1)
+	ks.Claim(key1.Token, common.DEFAULTNETWORK, address2)
+	ks.Claim(key2.Token, common.DEFAULTNETWORK, address2)
+
+	orphans, err := ks.Revoke(key1.Token)
+	if err != nil {
+		t.Errorf("KeyStore.Revoke() error = %v", err)
+		return
+	}
+	want := Subscription{Chain: common.DEFAULTNETWORK, Address: address1}
+	if len(orphans) != 1 || orphans[0] != want {
+		t.Errorf("KeyStore.Revoke() = %v, want [%v]", orphans, want)
+	}
+	if ks.Valid(key1.Token) {
+		t.Errorf("KeyStore.Valid() = true after revocation")
+	}
+	if _, err := ks.Revoke(key1.Token); err == nil {
+		t.Errorf("KeyStore.Revoke() error = nil for revoked key")
+	}
+}
+
+func TestScopedStorage(t *testing.T) {
+	ks := NewKeyStore()
+	key1, _ := ks.Issue("key1", 10)
+	key2, _ := ks.Issue("key2", 10)
+	m := &mockStorage{accounts: map[string]int{}}
+	s1 := &ScopedStorage{Keys: ks, Token: key1.Token, Chain: common.DEFAULTNETWORK, Storage: m}
+	s2 := &ScopedStorage{Keys: ks, Token: key2.Token, Chain: common.DEFAULTNETWORK, Storage: m}
+	other := &ScopedStorage{Keys: ks, Token: key1.Token, Chain: "sepolia", Storage: &mockStorage{accounts: map[string]int{}}}
+
+	if err := s1.CreateAccount(context.Background(), address1); err != nil {
+		t.Errorf("ScopedStorage.CreateAccount() error = %v", err)
+	}
+	if _, err := other.GetCurrentBlock(context.Background(), address1); err == nil {
+		t.Errorf("ScopedStorage.GetCurrentBlock() error = nil for address of other network")
+	}
+	if _, err := s2.GetCurrentBlock(context.Background(), address1); err == nil {
+		t.Errorf("ScopedStorage.GetCurrentBlock() error = nil for address of other key")
+	}
+	// the accounts of other keys are not listed, as by /status
+	if got, _ := s2.GetAccounts(context.Background()); len(got) != 0 {
+		t.Errorf("ScopedStorage.GetAccounts() = %v for address of other key", got)
+	}
+	if err := s2.CreateAccount(context.Background(), address1); err != nil {
+		t.Errorf("ScopedStorage.CreateAccount() error = %v for address shared with other key", err)
+	}
+	if _, err := s2.GetCurrentBlock(context.Background(), address1); err != nil {
+		t.Errorf("ScopedStorage.GetCurrentBlock() error = %v", err)
+	}
+	if err := s2.SetRetention(context.Background(), address1, common.RetentionPolicy{Days: 1}); err == nil {
+		t.Errorf("ScopedStorage.SetRetention() error = nil for address shared with other key")
+	}
+	if err := s1.RemoveAccount(context.Background(), address1); err != nil {
+		t.Errorf("ScopedStorage.RemoveAccount() error = %v", err)
+	}
+	if _, ok := m.accounts[address1]; !ok {
+		t.Errorf("ScopedStorage.RemoveAccount() removed account still owned by other key")
+	}
+	if err := s2.RemoveAccount(context.Background(), address1); err != nil {
+		t.Errorf("ScopedStorage.RemoveAccount() error = %v", err)
+	}
+	if _, ok := m.accounts[address1]; ok {
+		t.Errorf("ScopedStorage.RemoveAccount() account not removed from storage")
+	}
+}
+
+func TestKeyStore_View(t *testing.T) {
+	ks := NewKeyStore()
+	key, _ := ks.Issue("key", 10)
+	m := &mockStorage{accounts: map[string]int{}}
+	s := &ScopedStorage{Keys: ks, Token: key.Token, Chain: common.DEFAULTNETWORK, Storage: m}
+
+	unlock := ks.View()
+	done := make(chan error)
+	go func() { done <- s.CreateAccount(context.Background(), address1) }()
+	select {
+	case <-done:
+		t.Fatalf("ScopedStorage.CreateAccount() completed during KeyStore.View()")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if ks.Owns(key.Token, common.DEFAULTNETWORK, address1) {
+		t.Errorf("KeyStore.Owns() = true during KeyStore.View()")
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Errorf("ScopedStorage.CreateAccount() error = %v", err)
+	}
+	if _, ok := m.accounts[address1]; !ok || !ks.Owns(key.Token, common.DEFAULTNETWORK, address1) {
+		t.Errorf("ScopedStorage.CreateAccount() account not subscribed after KeyStore.View()")
+	}
+}
+
+func TestKeyStore_Authenticate(t *testing.T) {
+	ks := NewKeyStore()
+	key, _ := ks.Issue("test", 10)
+
+	tests := []struct {
+		name   string
+		apiKey string
+		want   int
+	}{
+		{
+			name:   "Missing api key",
+			apiKey: "",
+			want:   http.StatusUnauthorized,
+		}, {
+			name:   "Unknown api key",
+			apiKey: "abc",
+			want:   http.StatusUnauthorized,
+		}, {
+			name:   "Valid api key",
+			apiKey: key.Token,
+			want:   http.StatusOK,
+		},
+	}
+	h := ks.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := KeyFromContext(r.Context()); !ok {
+			t.Errorf("KeyFromContext() api key not found")
+		}
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/transaction?address="+address1, nil)
+			r.Header.Set(APIKEYHEADER, tt.apiKey)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("KeyStore.Authenticate() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// ScopedStorage implements common.Storage of a network for a single API key,
+// the key only sees and manages the addresses it subscribed on the network
+type ScopedStorage struct {
+	Keys    *KeyStore
+	Token   string
+	Chain   string
+	Storage common.Storage
+}
+
+// errABIReadOnly rejects the writes of the contract ABIs with an API key
+var errABIReadOnly = errors.New("contract abis are managed by the admin api")
+
+// errRetentionReadOnly rejects the writes of the retention policies with an API key, an address
+// may be owned by several keys and a policy prunes the history for all of them
+var errRetentionReadOnly = errors.New("retention policies are managed by the admin api")
+
+// Storage returns the storage of the network carried by ctx scoped to the API key carried by ctx
+func (ks *KeyStore) Storage(ctx context.Context, s common.Storage) (common.Storage, error) {
+	token, ok := KeyFromContext(ctx)
+	if !ok {
+		return nil, errors.New("missing api key")
+	}
+	return &ScopedStorage{Keys: ks, Token: token, Chain: util.NetworkFromContext(ctx).Name, Storage: s}, nil
+}
+
+// Scoped builds the handler with the storage scoped to the API key of each request,
+// it must be wrapped by Authenticate
+func (ks *KeyStore) Scoped(s common.Storage, build func(common.Storage) http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scoped, err := ks.Storage(r.Context(), s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		build(scoped).ServeHTTP(w, r)
+	})
+}
+
+// CreateAccount subscribes the address with the API key, an address already
+// subscribed by another key is shared without creating it again
+func (s *ScopedStorage) CreateAccount(ctx context.Context, address string) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	defer s.Keys.change()()
+	if err := s.Keys.Claim(s.Token, s.Chain, address); err != nil {
+		return err
+	}
+
+	if _, err := s.Storage.GetCurrentBlock(ctx, address); err == nil {
+		return nil
+	}
+	if err := s.Storage.CreateAccount(ctx, address); err != nil {
+		s.Keys.Release(s.Token, s.Chain, address)
+		return err
+	}
+	return nil
+}
+
+// RestoreAccount subscribes the address with the API key at the given checkpoint
+func (s *ScopedStorage) RestoreAccount(ctx context.Context, address string, block int) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	defer s.Keys.change()()
+	if err := s.Keys.Claim(s.Token, s.Chain, address); err != nil {
+		return err
+	}
+	if err := s.Storage.RestoreAccount(ctx, address, block); err != nil {
+		s.Keys.Release(s.Token, s.Chain, address)
+		return err
+	}
+	return nil
+}
+
+// RemoveAccount unsubscribes the address from the API key, the account is
+// removed from storage once no key owns it
+func (s *ScopedStorage) RemoveAccount(ctx context.Context, address string) error {
+	defer s.Keys.change()()
+	owned, err := s.Keys.Release(s.Token, s.Chain, address)
+	if err != nil {
+		return err
+	}
+	if owned {
+		return nil
+	}
+	return s.Storage.RemoveAccount(ctx, address)
+}
+
+func (s *ScopedStorage) SaveTransactions(ctx context.Context, address string, transactions []common.Transaction) error {
+	if err := s.checkOwner(address); err != nil {
+		return err
+	}
+	return s.Storage.SaveTransactions(ctx, address, transactions)
+}
+
+func (s *ScopedStorage) DeduplicateTransactions(ctx context.Context, address string) (int, error) {
+	if err := s.checkOwner(address); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeduplicateTransactions(ctx, address)
+}
+
+func (s *ScopedStorage) RemoveTransactions(ctx context.Context, address string, transactions []common.Transaction) (int, error) {
+	if err := s.checkOwner(address); err != nil {
+		return 0, err
+	}
+	return s.Storage.RemoveTransactions(ctx, address, transactions)
+}
+
+// SetRetention is rejected, the policy of an address shared by several keys is set by the admin api
+func (s *ScopedStorage) SetRetention(ctx context.Context, address string, policy common.RetentionPolicy) error {
+	return errRetentionReadOnly
+}
+
+func (s *ScopedStorage) GetRetention(ctx context.Context, address string) (common.RetentionPolicy, error) {
+	if err := s.checkOwner(address); err != nil {
+		return common.RetentionPolicy{}, err
+	}
+	return s.Storage.GetRetention(ctx, address)
+}
+
+func (s *ScopedStorage) SaveBalance(ctx context.Context, address string, checkpoint common.BalanceCheckpoint) error {
+	if err := s.checkOwner(address); err != nil {
+		return err
+	}
+	return s.Storage.SaveBalance(ctx, address, checkpoint)
+}
+
+func (s *ScopedStorage) GetBalances(ctx context.Context, address string) ([]common.BalanceCheckpoint, error) {
+	if err := s.checkOwner(address); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetBalances(ctx, address)
+}
+
+func (s *ScopedStorage) SaveTokenTransfers(ctx context.Context, address string, transfers []common.TokenTransfer) error {
+	if err := s.checkOwner(address); err != nil {
+		return err
+	}
+	return s.Storage.SaveTokenTransfers(ctx, address, transfers)
+}
+
+func (s *ScopedStorage) GetTokenTransfers(ctx context.Context, address string) ([]common.TokenTransfer, error) {
+	if err := s.checkOwner(address); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTokenTransfers(ctx, address)
+}
+
+func (s *ScopedStorage) SaveTokenCheckpoint(ctx context.Context, address string, checkpoint common.TokenCheckpoint) error {
+	if err := s.checkOwner(address); err != nil {
+		return err
+	}
+	return s.Storage.SaveTokenCheckpoint(ctx, address, checkpoint)
+}
+
+func (s *ScopedStorage) GetTokenCheckpoints(ctx context.Context, address string) ([]common.TokenCheckpoint, error) {
+	if err := s.checkOwner(address); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTokenCheckpoints(ctx, address)
+}
+
+func (s *ScopedStorage) SaveTokenBlock(ctx context.Context, address string, block int) error {
+	if err := s.checkOwner(address); err != nil {
+		return err
+	}
+	return s.Storage.SaveTokenBlock(ctx, address, block)
+}
+
+func (s *ScopedStorage) GetTokenBlock(ctx context.Context, address string) (int, error) {
+	if err := s.checkOwner(address); err != nil {
+		return -1, err
+	}
+	return s.Storage.GetTokenBlock(ctx, address)
+}
+
+func (s *ScopedStorage) SaveCheckpoint(ctx context.Context, address string, block int) error {
+	if err := s.checkOwner(address); err != nil {
+		return err
+	}
+	return s.Storage.SaveCheckpoint(ctx, address, block)
+}
+
+func (s *ScopedStorage) GetAccounts(ctx context.Context) ([]string, error) {
+	return s.Keys.Addresses(s.Token, s.Chain), nil
+}
+
+func (s *ScopedStorage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
+	if err := s.checkOwner(address); err != nil {
+		return -1, err
+	}
+	return s.Storage.GetCurrentBlock(ctx, address)
+}
+
+func (s *ScopedStorage) GetTransactions(ctx context.Context, address string) ([]common.Transaction, error) {
+	if err := s.checkOwner(address); err != nil {
+		return []common.Transaction{}, err
+	}
+	return s.Storage.GetTransactions(ctx, address)
+}
+
+func (s *ScopedStorage) GetTransactionsByBlockRange(ctx context.Context, address string, fromBlock, toBlock int) ([]common.Transaction, error) {
+	if err := s.checkOwner(address); err != nil {
+		return []common.Transaction{}, err
+	}
+	return s.Storage.GetTransactionsByBlockRange(ctx, address, fromBlock, toBlock)
+}
+
+func (s *ScopedStorage) GetTransactionsByCounterparty(ctx context.Context, address string, counterparty string) ([]common.Transaction, error) {
+	if err := s.checkOwner(address); err != nil {
+		return []common.Transaction{}, err
+	}
+	return s.Storage.GetTransactionsByCounterparty(ctx, address, counterparty)
+}
+
+func (s *ScopedStorage) GetTransaction(ctx context.Context, address string, hash string) (common.Transaction, error) {
+	if err := s.checkOwner(address); err != nil {
+		return common.Transaction{}, err
+	}
+	return s.Storage.GetTransaction(ctx, address, hash)
+}
+
+// FindTransaction finds the stored transaction in the accounts owned by the API key only
+func (s *ScopedStorage) FindTransaction(ctx context.Context, hash string) (common.Transaction, []common.AccountMatch, error) {
+	tr, matches, err := s.Storage.FindTransaction(ctx, hash)
+	if err != nil {
+		return common.Transaction{}, nil, err
+	}
+	owned := []common.AccountMatch{}
+	for _, match := range matches {
+		if s.Keys.Owns(s.Token, s.Chain, match.Address) {
+			owned = append(owned, match)
+		}
+	}
+	if len(owned) == 0 {
+		return common.Transaction{}, nil, fmt.Errorf("transaction [%s] not found", hash)
+	}
+	return tr, owned, nil
+}
+
+// SaveHeader saves the header, headers are chain data shared by all API keys
+func (s *ScopedStorage) SaveHeader(ctx context.Context, header common.Header) error {
+	return s.Storage.SaveHeader(ctx, header)
+}
+
+func (s *ScopedStorage) GetHeader(ctx context.Context, number int) (common.Header, error) {
+	return s.Storage.GetHeader(ctx, number)
+}
+
+func (s *ScopedStorage) GetHeaders(ctx context.Context) ([]common.Header, error) {
+	return s.Storage.GetHeaders(ctx)
+}
+
+// SaveToken saves the token metadata, token metadata are chain data shared by all API keys
+func (s *ScopedStorage) SaveToken(ctx context.Context, token common.Token) error {
+	return s.Storage.SaveToken(ctx, token)
+}
+
+func (s *ScopedStorage) GetToken(ctx context.Context, address string) (common.Token, error) {
+	return s.Storage.GetToken(ctx, address)
+}
+
+func (s *ScopedStorage) GetTokens(ctx context.Context) ([]common.Token, error) {
+	return s.Storage.GetTokens(ctx)
+}
+
+// SaveABI is rejected, contract ABIs are shared by all API keys and registered by the admin api
+func (s *ScopedStorage) SaveABI(ctx context.Context, contract common.ContractABI) error {
+	return errABIReadOnly
+}
+
+func (s *ScopedStorage) GetABI(ctx context.Context, address string) (common.ContractABI, error) {
+	return s.Storage.GetABI(ctx, address)
+}
+
+func (s *ScopedStorage) GetABIs(ctx context.Context) ([]common.ContractABI, error) {
+	return s.Storage.GetABIs(ctx)
+}
+
+// RemoveABI is rejected as SaveABI
+func (s *ScopedStorage) RemoveABI(ctx context.Context, address string) error {
+	return errABIReadOnly
+}
+
+// SaveEventABI is rejected as SaveABI
+func (s *ScopedStorage) SaveEventABI(ctx context.Context, event common.EventABI) error {
+	return errABIReadOnly
+}
+
+func (s *ScopedStorage) GetEventABI(ctx context.Context, topic string) (common.EventABI, error) {
+	return s.Storage.GetEventABI(ctx, topic)
+}
+
+func (s *ScopedStorage) GetEventABIs(ctx context.Context) ([]common.EventABI, error) {
+	return s.Storage.GetEventABIs(ctx)
+}
+
+// RemoveEventABI is rejected as SaveABI
+func (s *ScopedStorage) RemoveEventABI(ctx context.Context, topic string) error {
+	return errABIReadOnly
+}
+
+func (s *ScopedStorage) WatchTransactions(ctx context.Context, address string) (<-chan common.Transaction, func(), error) {
+	if err := s.checkOwner(address); err != nil {
+		return nil, nil, err
+	}
+	return s.Storage.WatchTransactions(ctx, address)
+}
+
+// checkOwner reports addresses of other keys as non-existent
+func (s *ScopedStorage) checkOwner(address string) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+	if !s.Keys.Owns(s.Token, s.Chain, address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+	return nil
+}
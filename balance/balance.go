@@ -0,0 +1,379 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	token "github.com/tonyxu1/transactionhistory/token"
+	util "github.com/tonyxu1/transactionhistory/util"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+// Chain defines the chain data used by the reconciler
+type Chain interface {
+	// native balance of the address at the block
+	GetBalance(ctx context.Context, address string, block int) (*big.Int, error)
+
+	// balance of the token held by the holder at the block
+	BalanceOf(ctx context.Context, token string, holder string, block int) (*big.Int, error)
+
+	// symbol, name and decimals of the token
+	TokenMetadata(ctx context.Context, token string) (common.Token, error)
+}
+
+// rpcChain reads the chain through the Json RPC endpoint
+type rpcChain struct{}
+
+func (rpcChain) GetBalance(ctx context.Context, address string, block int) (*big.Int, error) {
+	return util.GetBalance(ctx, address, block)
+}
+
+func (rpcChain) BalanceOf(ctx context.Context, tokenAddress string, holder string, block int) (*big.Int, error) {
+	return token.BalanceOf(ctx, tokenAddress, holder, block)
+}
+
+func (rpcChain) TokenMetadata(ctx context.Context, tokenAddress string) (common.Token, error) {
+	return token.Metadata(ctx, tokenAddress)
+}
+
+// Kinds of balance of the drift metric
+const (
+	kindNative = "native"
+	kindToken  = "token"
+)
+
+// Reconciler records the balance checkpoints of the accounts, native and of each token they
+// transferred: the balance read from the node when the account is subscribed or the token first
+// transferred, then every Interval once the scanner has passed the previous checkpoint, with the
+// drift from the balance computed over the stored transactions or token transfers
+type Reconciler struct {
+	Storage  common.Storage
+	Chain    Chain
+	Interval time.Duration
+	Now      func() time.Time
+}
+
+// New returns a reconciler of the storage reading balances through the Json RPC endpoint
+func New(s common.Storage) *Reconciler {
+	return &Reconciler{Storage: s, Chain: rpcChain{}, Interval: common.BALANCEINTERVAL, Now: time.Now}
+}
+
+// Delta returns the change of the native balance of the address caused by the transaction,
+// including the fee it paid as sender, and that fee. A failed transaction only charges its fee.
+// The fee of a sent transaction stored without receipt is unknown, it is not counted and
+// known is false.
+func Delta(address string, tr common.Transaction) (delta *big.Int, fee *big.Int, known bool, err error) {
+	value, err := util.DecodeQuantity(tr.Value)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("transaction [%s] value: %w", tr.Hash, err)
+	}
+	from := strings.EqualFold(tr.From, address)
+	to := strings.EqualFold(tr.To, address)
+
+	delta, fee, known = new(big.Int), new(big.Int), true
+	// transactions stored before receipts were retrieved have no status and are assumed executed
+	if tr.Status == "" || tr.Status == common.RECEIPTSUCCESS {
+		if to {
+			delta.Add(delta, value)
+		}
+		if from {
+			delta.Sub(delta, value)
+		}
+	}
+	if !from {
+		return delta, fee, known, nil
+	}
+	if tr.GasUsed == "" {
+		return delta, fee, false, nil
+	}
+	gasUsed, err := util.DecodeQuantity(tr.GasUsed)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("transaction [%s] gas used: %w", tr.Hash, err)
+	}
+	price := tr.EffectiveGasPrice
+	if price == "" {
+		price = tr.GasPrice
+	}
+	gasPrice, err := util.DecodeQuantity(price)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("transaction [%s] gas price: %w", tr.Hash, err)
+	}
+	fee.Mul(gasUsed, gasPrice)
+	delta.Sub(delta, fee)
+	return delta, fee, known, nil
+}
+
+// Computed returns the balance of the address at block toBlock expected from the balance
+// at block fromBlock and the transactions within (fromBlock, toBlock], and the number of
+// transactions whose fee is unknown
+func Computed(address string, balance *big.Int, trans []common.Transaction, fromBlock, toBlock int) (*big.Int, int, error) {
+	computed := new(big.Int).Set(balance)
+	unknown := 0
+	for _, tr := range trans {
+		block, err := hexutil.DecodeUint64(tr.BlockNumber)
+		if err != nil {
+			return nil, 0, fmt.Errorf("transaction [%s] block number: %w", tr.Hash, err)
+		}
+		if int(block) <= fromBlock || int(block) > toBlock {
+			continue
+		}
+		delta, _, known, err := Delta(address, tr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !known {
+			unknown++
+		}
+		computed.Add(computed, delta)
+	}
+	return computed, unknown, nil
+}
+
+// History returns the balance of the address after each of the transactions, given ordered
+// by block number descending as stored, oldest first. A transaction after the first checkpoint
+// is applied to the last checkpoint before its block, the transactions up to the first
+// checkpoint are rewound from it. The history is empty without checkpoint.
+func History(address string, checkpoints []common.BalanceCheckpoint, trans []common.Transaction) ([]common.BalancePoint, error) {
+	points := make([]common.BalancePoint, 0, len(trans))
+	if len(checkpoints) == 0 {
+		return points, nil
+	}
+
+	blocks := make([]int, 0, len(trans))
+	deltas := make([]*big.Int, 0, len(trans))
+	for i := len(trans) - 1; i >= 0; i-- {
+		tr := trans[i]
+		block, err := hexutil.DecodeUint64(tr.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("transaction [%s] block number: %w", tr.Hash, err)
+		}
+		delta, fee, known, err := Delta(address, tr)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, common.BalancePoint{
+			BlockNumber: int(block),
+			Hash:        tr.Hash,
+			Delta:       delta.String(),
+			Fee:         fee.String(),
+			FeeUnknown:  !known,
+		})
+		blocks = append(blocks, int(block))
+		deltas = append(deltas, delta)
+	}
+
+	balances, err := chainBalances(checkpoints, blocks, deltas)
+	if err != nil {
+		return nil, err
+	}
+	for i := range points {
+		points[i].Balance = balances[i].String()
+	}
+	return points, nil
+}
+
+// chainBalances returns the balance after each change of balance, given oldest first with
+// their block. A change after the first checkpoint is applied to the last checkpoint before
+// its block, the changes up to the first checkpoint are rewound from it.
+func chainBalances(checkpoints []common.BalanceCheckpoint, blocks []int, deltas []*big.Int) ([]*big.Int, error) {
+	anchors := make([]*big.Int, len(checkpoints))
+	for i, c := range checkpoints {
+		b, ok := new(big.Int).SetString(c.Balance, 10)
+		if !ok {
+			return nil, fmt.Errorf("balance checkpoint of block [%d] is invalid: %s", c.Block, c.Balance)
+		}
+		anchors[i] = b
+	}
+	balances := make([]*big.Int, len(blocks))
+
+	// rewind from the first checkpoint
+	first := 0
+	for first < len(blocks) && blocks[first] <= checkpoints[0].Block {
+		first++
+	}
+	balance := new(big.Int).Set(anchors[0])
+	for i := first - 1; i >= 0; i-- {
+		balances[i] = new(big.Int).Set(balance)
+		balance.Sub(balance, deltas[i])
+	}
+
+	// apply forward from the last checkpoint before each block
+	c := 0
+	balance = new(big.Int).Set(anchors[0])
+	for i := first; i < len(blocks); i++ {
+		for c+1 < len(checkpoints) && checkpoints[c+1].Block < blocks[i] {
+			c++
+			balance = new(big.Int).Set(anchors[c])
+		}
+		balance.Add(balance, deltas[i])
+		balances[i] = new(big.Int).Set(balance)
+	}
+	return balances, nil
+}
+
+// Current returns the balance of the address at the last block scanned, computed from its
+// last balance checkpoint and the transactions stored after it
+func Current(ctx context.Context, s common.Storage, address string) (common.AccountBalance, error) {
+	checkpoints, err := s.GetBalances(ctx, address)
+	if err != nil {
+		return common.AccountBalance{}, err
+	}
+	if len(checkpoints) == 0 {
+		return common.AccountBalance{}, fmt.Errorf("balance of address [%s] is not known yet", address)
+	}
+	next, err := s.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return common.AccountBalance{}, err
+	}
+	trans, err := s.GetTransactions(ctx, address)
+	if err != nil {
+		return common.AccountBalance{}, err
+	}
+
+	last := checkpoints[len(checkpoints)-1]
+	anchor, ok := new(big.Int).SetString(last.Balance, 10)
+	if !ok {
+		return common.AccountBalance{}, fmt.Errorf("balance checkpoint of block [%d] is invalid: %s", last.Block, last.Balance)
+	}
+	block := last.Block
+	if next-1 > block {
+		block = next - 1
+	}
+	balance, unknown, err := Computed(address, anchor, trans, last.Block, block)
+	if err != nil {
+		return common.AccountBalance{}, err
+	}
+	return common.AccountBalance{
+		Address:      address,
+		Block:        block,
+		Balance:      balance.String(),
+		BalanceEther: util.FormatUnits(balance, common.ETHERDECIMALS),
+		Checkpoint:   &last,
+		FeesUnknown:  unknown,
+	}, nil
+}
+
+// Reconcile records a balance checkpoint for each account and token due: the balance at block
+// head for those without checkpoint, accounts being anchored when they are subscribed unless
+// their balance could not be read then, and for the others the balance at the last block
+// scanned once Interval has elapsed since their last checkpoint. An account failing is
+// skipped and reported in the joined error.
+func (r *Reconciler) Reconcile(ctx context.Context, head int) error {
+	addresses, err := r.Storage.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, address := range addresses {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := r.reconcileAccount(ctx, address, head); err != nil {
+			logging.FromContext(ctx).Warn("balance reconciliation failed", logging.AddressKey, address, logging.ErrorKey, err)
+			errs = append(errs, fmt.Errorf("%s: %w", address, err))
+		}
+		if err := r.reconcileTokens(ctx, address, head); err != nil {
+			logging.FromContext(ctx).Warn("token balance reconciliation failed", logging.AddressKey, address, logging.ErrorKey, err)
+			errs = append(errs, fmt.Errorf("%s: %w", address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Reconciler) reconcileAccount(ctx context.Context, address string, head int) error {
+	logger := logging.FromContext(ctx)
+	checkpoints, err := r.Storage.GetBalances(ctx, address)
+	if err != nil {
+		// the account was removed meanwhile
+		return nil
+	}
+	now := r.Now().UTC()
+
+	if len(checkpoints) == 0 {
+		balance, err := r.Chain.GetBalance(ctx, address, head)
+		if err != nil {
+			return err
+		}
+		logger.Info("balance anchored", logging.AddressKey, address, logging.BlockKey, head, "balance", balance.String())
+		return r.Storage.SaveBalance(ctx, address, common.BalanceCheckpoint{Block: head, Balance: balance.String(), FetchedAt: now})
+	}
+
+	last := checkpoints[len(checkpoints)-1]
+	if now.Sub(last.FetchedAt) < r.Interval {
+		return nil
+	}
+	next, err := r.Storage.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return nil
+	}
+	block := next - 1
+	if block <= last.Block {
+		// the scanner has not passed the last checkpoint yet
+		return nil
+	}
+	trans, err := r.Storage.GetTransactions(ctx, address)
+	if err != nil {
+		return nil
+	}
+	anchor, ok := new(big.Int).SetString(last.Balance, 10)
+	if !ok {
+		return fmt.Errorf("balance checkpoint of block [%d] is invalid: %s", last.Block, last.Balance)
+	}
+	computed, _, err := Computed(address, anchor, trans, last.Block, block)
+	if err != nil {
+		return err
+	}
+	balance, err := r.Chain.GetBalance(ctx, address, block)
+	if err != nil {
+		return err
+	}
+
+	drift := new(big.Int).Sub(balance, computed)
+	if drift.Sign() != 0 {
+		metrics.BalanceDrifts.WithLabelValues(util.NetworkFromContext(ctx).Name, kindNative).Inc()
+		logger.Warn("balance drift", logging.AddressKey, address, logging.BlockKey, block,
+			"since", last.Block, "balance", balance.String(), "computed", computed.String(), "drift", drift.String())
+	}
+	return r.Storage.SaveBalance(ctx, address, common.BalanceCheckpoint{
+		Block:     block,
+		Balance:   balance.String(),
+		Computed:  computed.String(),
+		Drift:     drift.String(),
+		FetchedAt: now,
+	})
+}
+
+// Reanchor records a balance checkpoint without drift at the last block scanned for the address
+// whose transactions were pruned up to block pruned by the compaction, when pruned is after the
+// last checkpoint: the balance computed from the transactions left since that checkpoint would
+// drift. The drift is checked again from the new checkpoint.
+func (r *Reconciler) Reanchor(ctx context.Context, address string, pruned int) error {
+	checkpoints, err := r.Storage.GetBalances(ctx, address)
+	if err != nil || len(checkpoints) == 0 {
+		// the account was removed meanwhile or is anchored by the next reconciliation
+		return nil
+	}
+	if pruned <= checkpoints[len(checkpoints)-1].Block {
+		return nil
+	}
+	next, err := r.Storage.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return nil
+	}
+	block := next - 1
+	balance, err := r.Chain.GetBalance(ctx, address, block)
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("balance reanchored after compaction", logging.AddressKey, address, logging.BlockKey, block, "pruned", pruned, "balance", balance.String())
+	return r.Storage.SaveBalance(ctx, address, common.BalanceCheckpoint{Block: block, Balance: balance.String(), FetchedAt: r.Now().UTC()})
+}
@@ -0,0 +1,396 @@
+package balance
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	storage "github.com/tonyxu1/transactionhistory/storage"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+const (
+	testAddress  = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	otherAddress = "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"
+)
+
+var now = time.Unix(0x65540a00, 0).UTC()
+
+// history returns transactions of blocks 20 down to 14 as stored: a transfer sent with a fee
+// of 21000, a transfer received, a failed transfer sent with a fee of 42000 and a transfer
+// received stored without receipt
+func history() []common.Transaction {
+	return []common.Transaction{
+		{BlockHash: "0xb14", BlockNumber: "0x14", Hash: "0x04", From: testAddress, To: otherAddress, Value: "0x64", Status: "0x1", GasUsed: "0x5208", EffectiveGasPrice: "0x1"},
+		{BlockHash: "0xb12", BlockNumber: "0x12", Hash: "0x03", From: otherAddress, To: testAddress, Value: "0x3e8", Status: "0x1", GasUsed: "0x5208", EffectiveGasPrice: "0x1"},
+		{BlockHash: "0xb10", BlockNumber: "0x10", Hash: "0x02", From: testAddress, To: otherAddress, Value: "0x32", Status: "0x0", GasUsed: "0x5208", GasPrice: "0x2"},
+		{BlockHash: "0xb0e", BlockNumber: "0xe", Hash: "0x01", From: otherAddress, To: testAddress, Value: "0x1f4"},
+	}
+}
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		tr        common.Transaction
+		wantDelta string
+		wantFee   string
+		wantKnown bool
+	}{
+		{name: "Sent with fee", tr: history()[0], wantDelta: "-21100", wantFee: "21000", wantKnown: true},
+		{name: "Received, fee paid by the sender", tr: history()[1], wantDelta: "1000", wantFee: "0", wantKnown: true},
+		{name: "Failed transfer only charges the fee", tr: history()[2], wantDelta: "-42000", wantFee: "42000", wantKnown: true},
+		{name: "Received without receipt", tr: history()[3], wantDelta: "500", wantFee: "0", wantKnown: true},
+		{
+			name:      "Sent without receipt, fee unknown",
+			tr:        common.Transaction{From: testAddress, To: otherAddress, Value: "0x64", GasPrice: "0x1"},
+			wantDelta: "-100",
+			wantFee:   "0",
+			wantKnown: false,
+		}, {
+			name:      "Sent to self only charges the fee",
+			tr:        common.Transaction{From: testAddress, To: testAddress, Value: "0x64", Status: "0x1", GasUsed: "0x5208", EffectiveGasPrice: "0x1"},
+			wantDelta: "-21000",
+			wantFee:   "21000",
+			wantKnown: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, fee, known, err := Delta(testAddress, tt.tr)
+			if err != nil {
+				t.Fatalf("Delta() error = %v", err)
+			}
+			if delta.String() != tt.wantDelta || fee.String() != tt.wantFee || known != tt.wantKnown {
+				t.Errorf("Delta() = %s, %s, %v, want %s, %s, %v", delta, fee, known, tt.wantDelta, tt.wantFee, tt.wantKnown)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tests := []struct {
+		name        string
+		checkpoints []common.BalanceCheckpoint
+		want        []string
+	}{
+		{
+			name:        "No checkpoint",
+			checkpoints: nil,
+			want:        []string{},
+		}, {
+			name:        "Rewound and applied from a checkpoint",
+			checkpoints: []common.BalanceCheckpoint{{Block: 17, Balance: "100000"}},
+			want:        []string{"142000", "100000", "101000", "79900"},
+		}, {
+			name:        "Applied from the last checkpoint before the block",
+			checkpoints: []common.BalanceCheckpoint{{Block: 17, Balance: "100000"}, {Block: 19, Balance: "200000"}},
+			want:        []string{"142000", "100000", "101000", "178900"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := History(testAddress, tt.checkpoints, history())
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			got := make([]string, 0, len(points))
+			for _, p := range points {
+				got = append(got, p.Balance)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("History() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// mockChain returns the same native and token balances at every block and records the blocks read
+type mockChain struct {
+	balance      int64
+	tokenBalance int64
+	blocks       []int
+}
+
+func (m *mockChain) GetBalance(ctx context.Context, address string, block int) (*big.Int, error) {
+	m.blocks = append(m.blocks, block)
+	return big.NewInt(m.balance), nil
+}
+
+func (m *mockChain) BalanceOf(ctx context.Context, token string, holder string, block int) (*big.Int, error) {
+	m.blocks = append(m.blocks, block)
+	return big.NewInt(m.tokenBalance), nil
+}
+
+func (m *mockChain) TokenMetadata(ctx context.Context, token string) (common.Token, error) {
+	return common.Token{Address: token, Symbol: "USDC", Name: "USD Coin", Decimals: 6}, nil
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	tests := []struct {
+		name        string
+		checkpoint  *common.BalanceCheckpoint
+		balance     int64
+		wantBlocks  []int
+		wantLast    common.BalanceCheckpoint
+		wantDrifted bool
+	}{
+		{
+			name:       "Balance anchored at head when not anchored at subscription",
+			balance:    7,
+			wantBlocks: []int{25},
+			wantLast:   common.BalanceCheckpoint{Block: 25, Balance: "7", FetchedAt: now},
+		}, {
+			name:       "Balance matching the transactions",
+			checkpoint: &common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Hour)},
+			balance:    79900,
+			wantBlocks: []int{20},
+			wantLast:   common.BalanceCheckpoint{Block: 20, Balance: "79900", Computed: "79900", Drift: "0", FetchedAt: now},
+		}, {
+			name:       "Drift recorded",
+			checkpoint: &common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Hour)},
+			balance:    80000,
+			wantBlocks: []int{20},
+			wantLast:   common.BalanceCheckpoint{Block: 20, Balance: "80000", Computed: "79900", Drift: "100", FetchedAt: now},
+		}, {
+			name:       "Checkpoint not due yet",
+			checkpoint: &common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Minute)},
+			wantLast:   common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Minute)},
+		}, {
+			name:       "Scanner behind the last checkpoint",
+			checkpoint: &common.BalanceCheckpoint{Block: 20, Balance: "100000", FetchedAt: now.Add(-time.Hour)},
+			wantLast:   common.BalanceCheckpoint{Block: 20, Balance: "100000", FetchedAt: now.Add(-time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := &storage.Storage{}
+			// blocks up to 20 scanned
+			if err := s.RestoreAccount(ctx, testAddress, 21); err != nil {
+				t.Fatalf("Storage.RestoreAccount() error = %v", err)
+			}
+			if err := s.SaveTransactions(ctx, testAddress, history()); err != nil {
+				t.Fatalf("Storage.SaveTransactions() error = %v", err)
+			}
+			if tt.checkpoint != nil {
+				if err := s.SaveBalance(ctx, testAddress, *tt.checkpoint); err != nil {
+					t.Fatalf("Storage.SaveBalance() error = %v", err)
+				}
+			}
+
+			chain := &mockChain{balance: tt.balance}
+			r := &Reconciler{Storage: s, Chain: chain, Interval: 10 * time.Minute, Now: func() time.Time { return now }}
+			if err := r.Reconcile(ctx, 25); err != nil {
+				t.Fatalf("Reconciler.Reconcile() error = %v", err)
+			}
+			if !reflect.DeepEqual(chain.blocks, tt.wantBlocks) {
+				t.Errorf("Reconciler.Reconcile() blocks read = %v, want %v", chain.blocks, tt.wantBlocks)
+			}
+			checkpoints, _ := s.GetBalances(ctx, testAddress)
+			if got := checkpoints[len(checkpoints)-1]; !reflect.DeepEqual(got, tt.wantLast) {
+				t.Errorf("Reconciler.Reconcile() last checkpoint = %+v, want %+v", got, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestReconciler_Reanchor(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint *common.BalanceCheckpoint
+		pruned     int
+		wantBlocks []int
+		wantLast   *common.BalanceCheckpoint
+	}{
+		{
+			name:       "Reanchored when transactions after the last checkpoint are pruned",
+			checkpoint: &common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Minute)},
+			pruned:     18,
+			wantBlocks: []int{20},
+			wantLast:   &common.BalanceCheckpoint{Block: 20, Balance: "80000", FetchedAt: now},
+		}, {
+			name:       "Transactions pruned before the last checkpoint",
+			checkpoint: &common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Minute)},
+			pruned:     16,
+			wantLast:   &common.BalanceCheckpoint{Block: 17, Balance: "100000", FetchedAt: now.Add(-time.Minute)},
+		}, {
+			name:   "Account not anchored yet",
+			pruned: 18,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := &storage.Storage{}
+			// blocks up to 20 scanned
+			if err := s.RestoreAccount(ctx, testAddress, 21); err != nil {
+				t.Fatalf("Storage.RestoreAccount() error = %v", err)
+			}
+			if tt.checkpoint != nil {
+				if err := s.SaveBalance(ctx, testAddress, *tt.checkpoint); err != nil {
+					t.Fatalf("Storage.SaveBalance() error = %v", err)
+				}
+			}
+
+			chain := &mockChain{balance: 80000}
+			r := &Reconciler{Storage: s, Chain: chain, Interval: 10 * time.Minute, Now: func() time.Time { return now }}
+			if err := r.Reanchor(ctx, testAddress, tt.pruned); err != nil {
+				t.Fatalf("Reconciler.Reanchor() error = %v", err)
+			}
+			if !reflect.DeepEqual(chain.blocks, tt.wantBlocks) {
+				t.Errorf("Reconciler.Reanchor() blocks read = %v, want %v", chain.blocks, tt.wantBlocks)
+			}
+			checkpoints, _ := s.GetBalances(ctx, testAddress)
+			if tt.wantLast == nil {
+				if len(checkpoints) != 0 {
+					t.Errorf("Reconciler.Reanchor() checkpoints = %+v, want none", checkpoints)
+				}
+				return
+			}
+			if got := checkpoints[len(checkpoints)-1]; !reflect.DeepEqual(got, *tt.wantLast) {
+				t.Errorf("Reconciler.Reanchor() last checkpoint = %+v, want %+v", got, *tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.Storage{}
+	if err := s.RestoreAccount(ctx, testAddress, 21); err != nil {
+		t.Fatalf("Storage.RestoreAccount() error = %v", err)
+	}
+	if _, err := Current(ctx, s, testAddress); err == nil {
+		t.Errorf("Current() error = nil without checkpoint")
+	}
+	if err := s.SaveTransactions(ctx, testAddress, history()); err != nil {
+		t.Fatalf("Storage.SaveTransactions() error = %v", err)
+	}
+	if err := s.SaveBalance(ctx, testAddress, common.BalanceCheckpoint{Block: 17, Balance: "100000"}); err != nil {
+		t.Fatalf("Storage.SaveBalance() error = %v", err)
+	}
+	got, err := Current(ctx, s, testAddress)
+	if err != nil {
+		t.Fatalf("Current() error = %v", err)
+	}
+	if got.Block != 20 || got.Balance != "79900" || got.BalanceEther != "0.0000000000000799" {
+		t.Errorf("Current() = %+v, want balance 79900 at block 20", got)
+	}
+}
+
+const testToken = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+
+// transfers returns token transfers of blocks 14 to 20: 500 received, 200 sent, 1000 received
+// and a transfer to self
+func transfers() []common.TokenTransfer {
+	return []common.TokenTransfer{
+		{Token: testToken, From: otherAddress, To: testAddress, Value: "500", BlockNumber: 14, TransactionHash: "0x11"},
+		{Token: testToken, From: testAddress, To: otherAddress, Value: "200", BlockNumber: 16, TransactionHash: "0x12"},
+		{Token: testToken, From: otherAddress, To: testAddress, Value: "1000", BlockNumber: 18, TransactionHash: "0x13"},
+		{Token: testToken, From: testAddress, To: testAddress, Value: "5", BlockNumber: 20, TransactionHash: "0x14"},
+	}
+}
+
+func TestTokenHistory(t *testing.T) {
+	checkpoints := []common.TokenCheckpoint{{Token: testToken, BalanceCheckpoint: common.BalanceCheckpoint{Block: 17, Balance: "300"}}}
+	history, err := TokenHistory(testAddress, common.Token{Address: testToken}, checkpoints, transfers())
+	if err != nil {
+		t.Fatalf("TokenHistory() error = %v", err)
+	}
+	got := make([]string, 0, len(history.Points))
+	for _, p := range history.Points {
+		got = append(got, p.Balance)
+	}
+	if want := []string{"500", "300", "1300", "1300"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TokenHistory() = %v, want %v", got, want)
+	}
+}
+
+func TestReconciler_Reconcile_Tokens(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.Storage{}
+	// blocks up to 20 scanned
+	if err := s.RestoreAccount(ctx, testAddress, 21); err != nil {
+		t.Fatalf("Storage.RestoreAccount() error = %v", err)
+	}
+	if err := s.SaveTokenTransfers(ctx, testAddress, transfers()); err != nil {
+		t.Fatalf("Storage.SaveTokenTransfers() error = %v", err)
+	}
+	// the native balance is anchored already
+	if err := s.SaveBalance(ctx, testAddress, common.BalanceCheckpoint{Block: 17, Balance: "0", FetchedAt: now}); err != nil {
+		t.Fatalf("Storage.SaveBalance() error = %v", err)
+	}
+
+	chain := &mockChain{tokenBalance: 300}
+	r := &Reconciler{Storage: s, Chain: chain, Interval: 10 * time.Minute, Now: func() time.Time { return now }}
+	if err := r.Reconcile(ctx, 17); err != nil {
+		t.Fatalf("Reconciler.Reconcile() error = %v", err)
+	}
+	if meta, err := s.GetToken(ctx, testToken); err != nil || meta.Symbol != "USDC" {
+		t.Errorf("Storage.GetToken() = %+v, %v, want USDC metadata cached", meta, err)
+	}
+
+	// native and token balances reconciled at the last block scanned, 300 + 1000 tokens expected
+	chain.tokenBalance = 1400
+	r.Now = func() time.Time { return now.Add(time.Hour) }
+	if err := r.Reconcile(ctx, 25); err != nil {
+		t.Fatalf("Reconciler.Reconcile() error = %v", err)
+	}
+	if want := []int{17, 20, 20}; !reflect.DeepEqual(chain.blocks, want) {
+		t.Errorf("Reconciler.Reconcile() blocks read = %v, want %v", chain.blocks, want)
+	}
+	checkpoints, _ := s.GetTokenCheckpoints(ctx, testAddress)
+	last := checkpoints[len(checkpoints)-1]
+	if len(checkpoints) != 2 || last.Computed != "1300" || last.Drift != "100" {
+		t.Errorf("Reconciler.Reconcile() token checkpoints = %+v, want drift 100 at block 20", checkpoints)
+	}
+
+	holdings, err := Holdings(ctx, s, testAddress)
+	if err != nil {
+		t.Fatalf("Holdings() error = %v", err)
+	}
+	if len(holdings) != 1 || holdings[0].Balance != "1400" || holdings[0].BalanceFormatted != "0.0014" || holdings[0].Token.Symbol != "USDC" {
+		t.Errorf("Holdings() = %+v, want 0.0014 USDC", holdings)
+	}
+}
+
+// throttledChain reads the metadata through the Json RPC endpoint of ctx and the balances from mockChain
+type throttledChain struct {
+	*mockChain
+}
+
+func (throttledChain) TokenMetadata(ctx context.Context, tokenAddress string) (common.Token, error) {
+	return rpcChain{}.TokenMetadata(ctx, tokenAddress)
+}
+
+func TestReconciler_Reconcile_ThrottledMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","error":{"code":-32005,"message":"request rate exceeded"},"id":7}`))
+	}))
+	defer srv.Close()
+	ctx := util.WithNetwork(context.Background(), common.Network{Name: "test", RPCEndpoints: []string{srv.URL}})
+
+	s := &storage.Storage{}
+	if err := s.RestoreAccount(ctx, testAddress, 21); err != nil {
+		t.Fatalf("Storage.RestoreAccount() error = %v", err)
+	}
+	if err := s.SaveTokenTransfers(ctx, testAddress, transfers()); err != nil {
+		t.Fatalf("Storage.SaveTokenTransfers() error = %v", err)
+	}
+	if err := s.SaveBalance(ctx, testAddress, common.BalanceCheckpoint{Block: 17, Balance: "0", FetchedAt: now}); err != nil {
+		t.Fatalf("Storage.SaveBalance() error = %v", err)
+	}
+
+	r := &Reconciler{Storage: s, Chain: throttledChain{&mockChain{tokenBalance: 300}}, Interval: 10 * time.Minute, Now: func() time.Time { return now }}
+	if err := r.Reconcile(ctx, 17); err != nil {
+		t.Fatalf("Reconciler.Reconcile() error = %v", err)
+	}
+	if meta, err := s.GetToken(ctx, testToken); err == nil {
+		t.Errorf("Storage.GetToken() = %+v, want the throttled metadata not cached", meta)
+	}
+}
@@ -0,0 +1,314 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// TransferDelta returns the change of the token balance of the address caused by the transfer,
+// a transfer to self does not change it
+func TransferDelta(address string, t common.TokenTransfer) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(t.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("transfer [%s:%d] value [%s] is invalid", t.TransactionHash, t.LogIndex, t.Value)
+	}
+	delta := new(big.Int)
+	if strings.EqualFold(t.To, address) {
+		delta.Add(delta, value)
+	}
+	if strings.EqualFold(t.From, address) {
+		delta.Sub(delta, value)
+	}
+	return delta, nil
+}
+
+// TokenComputed returns the balance of the token held by the address at block toBlock expected
+// from the balance at block fromBlock and the transfers of the token within (fromBlock, toBlock]
+func TokenComputed(address string, tokenAddress string, balance *big.Int, transfers []common.TokenTransfer, fromBlock, toBlock int) (*big.Int, error) {
+	computed := new(big.Int).Set(balance)
+	for _, t := range transfers {
+		if !strings.EqualFold(t.Token, tokenAddress) || t.BlockNumber <= fromBlock || t.BlockNumber > toBlock {
+			continue
+		}
+		delta, err := TransferDelta(address, t)
+		if err != nil {
+			return nil, err
+		}
+		computed.Add(computed, delta)
+	}
+	return computed, nil
+}
+
+// TokenHistory returns the balance of the token held by the address after each of its
+// transfers, given oldest first, chained from the checkpoints of the token as History
+func TokenHistory(address string, meta common.Token, checkpoints []common.TokenCheckpoint, transfers []common.TokenTransfer) (common.TokenHistory, error) {
+	history := common.TokenHistory{Token: meta, Checkpoints: checkpoints, Points: []common.TokenBalancePoint{}}
+	if len(checkpoints) == 0 {
+		return history, nil
+	}
+
+	blocks := make([]int, 0)
+	deltas := make([]*big.Int, 0)
+	for _, t := range transfers {
+		if !strings.EqualFold(t.Token, meta.Address) {
+			continue
+		}
+		delta, err := TransferDelta(address, t)
+		if err != nil {
+			return common.TokenHistory{}, err
+		}
+		history.Points = append(history.Points, common.TokenBalancePoint{
+			BlockNumber:     t.BlockNumber,
+			TransactionHash: t.TransactionHash,
+			LogIndex:        t.LogIndex,
+			Delta:           delta.String(),
+		})
+		blocks = append(blocks, t.BlockNumber)
+		deltas = append(deltas, delta)
+	}
+
+	balances, err := chainBalances(balanceCheckpoints(checkpoints), blocks, deltas)
+	if err != nil {
+		return common.TokenHistory{}, err
+	}
+	for i := range history.Points {
+		history.Points[i].Balance = balances[i].String()
+	}
+	return history, nil
+}
+
+// balanceCheckpoints returns the balance checkpoints of the token checkpoints
+func balanceCheckpoints(checkpoints []common.TokenCheckpoint) []common.BalanceCheckpoint {
+	result := make([]common.BalanceCheckpoint, 0, len(checkpoints))
+	for _, c := range checkpoints {
+		result = append(result, c.BalanceCheckpoint)
+	}
+	return result
+}
+
+// byToken groups the token checkpoints by token, each group ordered by block as stored
+func byToken(checkpoints []common.TokenCheckpoint) map[string][]common.TokenCheckpoint {
+	groups := make(map[string][]common.TokenCheckpoint)
+	for _, c := range checkpoints {
+		groups[c.Token] = append(groups[c.Token], c)
+	}
+	return groups
+}
+
+// tokenMetadata returns the cached metadata of the token, only its address when not cached
+func tokenMetadata(ctx context.Context, s common.Storage, tokenAddress string) common.Token {
+	meta, err := s.GetToken(ctx, tokenAddress)
+	if err != nil {
+		return common.Token{Address: tokenAddress, Decimals: -1}
+	}
+	return meta
+}
+
+// Holdings returns the balance of each token held by the address at the last block scanned,
+// computed from the last checkpoint of the token and the transfers stored after it, ordered
+// by token contract address. Tokens without checkpoint yet are not listed.
+func Holdings(ctx context.Context, s common.Storage, address string) ([]common.TokenHolding, error) {
+	checkpoints, err := s.GetTokenCheckpoints(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := s.GetTokenTransfers(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := byToken(checkpoints)
+	tokens := make([]string, 0, len(groups))
+	for t := range groups {
+		tokens = append(tokens, t)
+	}
+	sort.Strings(tokens)
+
+	holdings := make([]common.TokenHolding, 0, len(tokens))
+	for _, t := range tokens {
+		last := groups[t][len(groups[t])-1]
+		anchor, ok := new(big.Int).SetString(last.Balance, 10)
+		if !ok {
+			return nil, fmt.Errorf("balance checkpoint of token [%s] at block [%d] is invalid: %s", t, last.Block, last.Balance)
+		}
+		block := last.Block
+		if next-1 > block {
+			block = next - 1
+		}
+		balance, err := TokenComputed(address, t, anchor, transfers, last.Block, block)
+		if err != nil {
+			return nil, err
+		}
+		holding := common.TokenHolding{
+			Token:      tokenMetadata(ctx, s, t),
+			Block:      block,
+			Balance:    balance.String(),
+			Checkpoint: &last,
+		}
+		if holding.Token.Decimals >= 0 {
+			holding.BalanceFormatted = util.FormatUnits(balance, holding.Token.Decimals)
+		}
+		holdings = append(holdings, holding)
+	}
+	return holdings, nil
+}
+
+// TokenHistories returns the balance history of each token held by the address, or of the
+// given token only when not empty, ordered by token contract address
+func TokenHistories(ctx context.Context, s common.Storage, address string, tokenAddress string) ([]common.TokenHistory, error) {
+	checkpoints, err := s.GetTokenCheckpoints(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := s.GetTokenTransfers(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := byToken(checkpoints)
+	tokens := make([]string, 0, len(groups))
+	for t := range groups {
+		if tokenAddress == "" || strings.EqualFold(t, tokenAddress) {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Strings(tokens)
+
+	histories := make([]common.TokenHistory, 0, len(tokens))
+	for _, t := range tokens {
+		history, err := TokenHistory(address, tokenMetadata(ctx, s, t), groups[t], transfers)
+		if err != nil {
+			return nil, err
+		}
+		histories = append(histories, history)
+	}
+	return histories, nil
+}
+
+// reconcileTokens records a balance checkpoint for each token transferred by the account that is
+// due, caching the metadata of the tokens seen for the first time. A token failing is skipped
+// and reported in the joined error.
+func (r *Reconciler) reconcileTokens(ctx context.Context, address string, head int) error {
+	transfers, err := r.Storage.GetTokenTransfers(ctx, address)
+	if err != nil {
+		// the account was removed meanwhile
+		return nil
+	}
+	checkpoints, err := r.Storage.GetTokenCheckpoints(ctx, address)
+	if err != nil {
+		return nil
+	}
+	groups := byToken(checkpoints)
+	tokens := make([]string, 0, len(groups))
+	for t := range groups {
+		tokens = append(tokens, t)
+	}
+	for _, t := range transfers {
+		if _, ok := groups[t.Token]; !ok {
+			groups[t.Token] = nil
+			tokens = append(tokens, t.Token)
+		}
+	}
+	sort.Strings(tokens)
+
+	var errs []error
+	for _, t := range tokens {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.cacheMetadata(ctx, t)
+		if err := r.reconcileToken(ctx, address, t, groups[t], transfers, head); err != nil {
+			errs = append(errs, fmt.Errorf("token [%s]: %w", t, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Reconciler) reconcileToken(ctx context.Context, address string, tokenAddress string, checkpoints []common.TokenCheckpoint, transfers []common.TokenTransfer, head int) error {
+	logger := logging.FromContext(ctx)
+	now := r.Now().UTC()
+
+	if len(checkpoints) == 0 {
+		balance, err := r.Chain.BalanceOf(ctx, tokenAddress, address, head)
+		if err != nil {
+			return err
+		}
+		logger.Info("token balance anchored", logging.AddressKey, address, "token", tokenAddress, logging.BlockKey, head, "balance", balance.String())
+		return r.Storage.SaveTokenCheckpoint(ctx, address, common.TokenCheckpoint{
+			Token:             tokenAddress,
+			BalanceCheckpoint: common.BalanceCheckpoint{Block: head, Balance: balance.String(), FetchedAt: now},
+		})
+	}
+
+	last := checkpoints[len(checkpoints)-1]
+	if now.Sub(last.FetchedAt) < r.Interval {
+		return nil
+	}
+	next, err := r.Storage.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return nil
+	}
+	block := next - 1
+	if block <= last.Block {
+		// the scanner has not passed the last checkpoint yet
+		return nil
+	}
+	anchor, ok := new(big.Int).SetString(last.Balance, 10)
+	if !ok {
+		return fmt.Errorf("balance checkpoint of block [%d] is invalid: %s", last.Block, last.Balance)
+	}
+	computed, err := TokenComputed(address, tokenAddress, anchor, transfers, last.Block, block)
+	if err != nil {
+		return err
+	}
+	balance, err := r.Chain.BalanceOf(ctx, tokenAddress, address, block)
+	if err != nil {
+		return err
+	}
+
+	drift := new(big.Int).Sub(balance, computed)
+	if drift.Sign() != 0 {
+		metrics.BalanceDrifts.WithLabelValues(util.NetworkFromContext(ctx).Name, kindToken).Inc()
+		logger.Warn("token balance drift", logging.AddressKey, address, "token", tokenAddress, logging.BlockKey, block,
+			"since", last.Block, "balance", balance.String(), "computed", computed.String(), "drift", drift.String())
+	}
+	return r.Storage.SaveTokenCheckpoint(ctx, address, common.TokenCheckpoint{
+		Token: tokenAddress,
+		BalanceCheckpoint: common.BalanceCheckpoint{
+			Block:     block,
+			Balance:   balance.String(),
+			Computed:  computed.String(),
+			Drift:     drift.String(),
+			FetchedAt: now,
+		},
+	})
+}
+
+// cacheMetadata fetches the metadata of the token when not cached yet, a failure is retried
+// on the next reconciliation
+func (r *Reconciler) cacheMetadata(ctx context.Context, tokenAddress string) {
+	if _, err := r.Storage.GetToken(ctx, tokenAddress); err == nil {
+		return
+	}
+	meta, err := r.Chain.TokenMetadata(ctx, tokenAddress)
+	if err != nil {
+		logging.FromContext(ctx).Warn("token metadata not fetched", "token", tokenAddress, logging.ErrorKey, err)
+		return
+	}
+	if err := r.Storage.SaveToken(ctx, meta); err != nil {
+		logging.FromContext(ctx).Warn("token metadata not cached", "token", tokenAddress, logging.ErrorKey, err)
+	}
+}
@@ -0,0 +1,286 @@
+package classify
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	common "github.com/tonyxu1/transactionhistory/common"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+// Rule labels a transaction relative to the account, false when the rule does not apply.
+// The input and the logs of the transaction are decoded when the rule is applied.
+type Rule interface {
+	Label(address string, tr common.Transaction) (string, bool)
+}
+
+// RuleFunc adapts a function to a Rule
+type RuleFunc func(address string, tr common.Transaction) (string, bool)
+
+// Label calls f(address, tr)
+func (f RuleFunc) Label(address string, tr common.Transaction) (string, bool) {
+	return f(address, tr)
+}
+
+// Classifier labels the transactions with the first rule applying
+type Classifier struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// Default is the classifier used by the API, holding the default rules
+var Default = New(DefaultRules()...)
+
+// New returns a classifier trying the rules in order
+func New(rules ...Rule) *Classifier {
+	return &Classifier{rules: append([]Rule{}, rules...)}
+}
+
+// DefaultRules returns the rules labeling, in order, the failed transactions, the contract
+// deployments, the token approvals, the swaps, the token and ether transfers and the other
+// contract calls
+func DefaultRules() []Rule {
+	return []Rule{
+		RuleFunc(failed),
+		RuleFunc(deployment),
+		RuleFunc(approval),
+		RuleFunc(swap),
+		RuleFunc(tokenTransfer),
+		RuleFunc(valueTransfer),
+		RuleFunc(contractCall),
+	}
+}
+
+// Register adds the rule before the rules of the classifier, so it overrides them
+func (c *Classifier) Register(rule Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append([]Rule{rule}, c.rules...)
+}
+
+// Classify returns the label of the transaction relative to the address, empty when no rule
+// applies. The input and the logs not decoded yet are decoded with the selector database and
+// the builtin events.
+func (c *Classifier) Classify(address string, tr common.Transaction) string {
+	tr = decoded(tr)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, rule := range c.rules {
+		if label, ok := rule.Label(address, tr); ok {
+			return label
+		}
+	}
+	return ""
+}
+
+// Label sets the label relative to the address of the transactions in place
+func (c *Classifier) Label(address string, trans []common.Transaction) {
+	for i := range trans {
+		trans[i].Label = c.Classify(address, trans[i])
+	}
+}
+
+// Apply returns copies of the transactions decoded with the ABIs registered in the storage, as
+// abi.Decode, and labeled relative to the address. Every API labels the transactions with it, so
+// that a transaction has the same label whatever the API.
+func (c *Classifier) Apply(ctx context.Context, s common.Storage, address string, trans []common.Transaction) []common.Transaction {
+	decoded := abi.Decode(ctx, s, trans)
+	c.Label(address, decoded)
+	return decoded
+}
+
+// Filter returns the transactions with the label, all of them when the label is empty
+func Filter(trans []common.Transaction, label string) []common.Transaction {
+	if label == "" {
+		return trans
+	}
+	filtered := make([]common.Transaction, 0, len(trans))
+	for _, tr := range trans {
+		if tr.Label == label {
+			filtered = append(filtered, tr)
+		}
+	}
+	return filtered
+}
+
+// decoded returns a copy of the transaction with its input and its logs decoded
+func decoded(tr common.Transaction) common.Transaction {
+	if tr.Decoded == nil {
+		tr.Decoded = abi.DecodeCall(tr, nil)
+	}
+	logs := make([]common.Log, len(tr.Logs))
+	copy(logs, tr.Logs)
+	for i, l := range logs {
+		if l.Decoded == nil {
+			logs[i].Decoded = abi.DecodeLog(l, nil, nil)
+		}
+	}
+	tr.Logs = logs
+	return tr
+}
+
+// failed labels the transactions whose receipt status is not a success
+func failed(address string, tr common.Transaction) (string, bool) {
+	return common.LABELFAILED, tr.Status != "" && tr.Status != common.RECEIPTSUCCESS
+}
+
+// deployment labels the transactions without recipient
+func deployment(address string, tr common.Transaction) (string, bool) {
+	return common.LABELDEPLOYMENT, tr.To == ""
+}
+
+// approvalMethods are the functions granting an allowance or an operator
+var approvalMethods = map[string]bool{
+	"approve":           true,
+	"increaseAllowance": true,
+	"setApprovalForAll": true,
+	"permit":            true,
+}
+
+// approval labels the calls granting an allowance or an operator
+func approval(address string, tr common.Transaction) (string, bool) {
+	return common.LABELAPPROVAL, tr.Decoded != nil && approvalMethods[tr.Decoded.Method]
+}
+
+// swapPrefixes are the prefixes of the router functions swapping tokens
+var swapPrefixes = []string{"swap", "exactInput", "exactOutput"}
+
+// swap labels the swaps sent by the account, known by the router function or the Swap event of
+// a pool, and the transactions where the account sends an asset and receives another one
+func swap(address string, tr common.Transaction) (string, bool) {
+	if strings.EqualFold(tr.From, address) {
+		if tr.Decoded != nil {
+			for _, prefix := range swapPrefixes {
+				if strings.HasPrefix(tr.Decoded.Method, prefix) {
+					return common.LABELSWAP, true
+				}
+			}
+		}
+		for _, l := range tr.Logs {
+			if l.Decoded != nil && l.Decoded.Event == "Swap" {
+				return common.LABELSWAP, true
+			}
+		}
+	}
+
+	sent, received := make(map[string]bool), make(map[string]bool)
+	if strings.EqualFold(tr.From, address) && !strings.EqualFold(tr.To, address) && hasValue(tr) {
+		sent[""] = true
+	}
+	for _, t := range transfers(tr) {
+		if strings.EqualFold(t.from, address) && !strings.EqualFold(t.to, address) {
+			sent[t.token] = true
+		}
+		if strings.EqualFold(t.to, address) && !strings.EqualFold(t.from, address) {
+			received[t.token] = true
+		}
+	}
+	if len(sent) == 0 {
+		return "", false
+	}
+	for token := range received {
+		if !sent[token] {
+			return common.LABELSWAP, true
+		}
+	}
+	return "", false
+}
+
+// transferMethods are the functions transferring tokens
+var transferMethods = map[string]bool{
+	"transfer":              true,
+	"transferFrom":          true,
+	"safeTransferFrom":      true,
+	"safeBatchTransferFrom": true,
+}
+
+// tokenTransfer labels the transactions transferring tokens from or to the account, by the
+// Transfer events involving it, or by the function called by the account when there is no log
+func tokenTransfer(address string, tr common.Transaction) (string, bool) {
+	sent, received := false, false
+	for _, t := range transfers(tr) {
+		sent = sent || strings.EqualFold(t.from, address)
+		received = received || strings.EqualFold(t.to, address)
+	}
+	switch {
+	case sent && received:
+		return common.LABELSELF, true
+	case sent:
+		return common.LABELOUTGOING, true
+	case received:
+		return common.LABELINCOMING, true
+	}
+	if len(tr.Logs) == 0 && tr.Decoded != nil && transferMethods[tr.Decoded.Method] && strings.EqualFold(tr.From, address) {
+		return common.LABELOUTGOING, true
+	}
+	return "", false
+}
+
+// valueTransfer labels the ether transfers without input and the transactions the account
+// sends to itself
+func valueTransfer(address string, tr common.Transaction) (string, bool) {
+	self := strings.EqualFold(tr.From, address) && strings.EqualFold(tr.To, address)
+	if tr.Input != "" && tr.Input != "0x" && !self {
+		return "", false
+	}
+	switch {
+	case self:
+		return common.LABELSELF, true
+	case strings.EqualFold(tr.To, address):
+		return common.LABELINCOMING, true
+	}
+	return common.LABELOUTGOING, true
+}
+
+// contractCall labels any transaction, it is the last default rule
+func contractCall(address string, tr common.Transaction) (string, bool) {
+	return common.LABELCALL, true
+}
+
+// hasValue checks the transaction transfers ether
+func hasValue(tr common.Transaction) bool {
+	value, err := hexutil.DecodeBig(tr.Value)
+	return err == nil && value.Sign() > 0
+}
+
+// transfer defines a token moved by a log, token is the contract emitting it
+type transfer struct {
+	token string
+	from  string
+	to    string
+}
+
+// transfers returns the tokens moved by the decoded ERC-20, ERC-721 and ERC-1155 transfer
+// events of the transaction, the removed logs are skipped. The sender and the recipient are
+// the first two address arguments of a Transfer event, whatever their names in the ABI of the
+// contract, and follow the operator in the ERC-1155 events.
+func transfers(tr common.Transaction) []transfer {
+	result := make([]transfer, 0)
+	for _, l := range tr.Logs {
+		if l.Removed || l.Decoded == nil || l.Decoded.Error != "" {
+			continue
+		}
+		skip := 0
+		switch l.Decoded.Event {
+		case "Transfer":
+		case "TransferSingle", "TransferBatch":
+			skip = 1
+		default:
+			continue
+		}
+		addresses := make([]string, 0, 3)
+		for _, arg := range l.Decoded.Args {
+			if value, ok := arg.Value.(string); ok && arg.Type == "address" {
+				addresses = append(addresses, value)
+			}
+		}
+		if len(addresses) < skip+2 {
+			continue
+		}
+		result = append(result, transfer{token: strings.ToLower(l.Address), from: addresses[skip], to: addresses[skip+1]})
+	}
+	return result
+}
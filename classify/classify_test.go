@@ -0,0 +1,194 @@
+package classify
+
+import (
+	"strings"
+	"testing"
+
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	common "github.com/tonyxu1/transactionhistory/common"
+)
+
+const (
+	testAddress  = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	otherAddress = "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"
+	tokenA       = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	tokenB       = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	router       = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+	valueWord    = "0x0000000000000000000000000000000000000000000000000000000000000064"
+)
+
+// input returns the input calling the function with the signature, without arguments
+func input(signature string) string {
+	m, err := abi.ParseSignature(signature)
+	if err != nil {
+		panic(err)
+	}
+	return m.Selector()
+}
+
+func topic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
+
+// transferLog returns the ERC-20 Transfer log of the token
+func transferLog(token, from, to string) common.Log {
+	return common.Log{Address: token, Topics: []string{common.TRANSFERTOPIC, topic(from), topic(to)}, Data: valueWord}
+}
+
+func TestClassifier_Classify(t *testing.T) {
+	swapTopic := abi.Event{Name: "Swap", Inputs: []abi.Argument{
+		{Name: "sender", Type: "address", Indexed: true},
+		{Name: "amount0In", Type: "uint256"},
+		{Name: "amount1In", Type: "uint256"},
+		{Name: "amount0Out", Type: "uint256"},
+		{Name: "amount1Out", Type: "uint256"},
+		{Name: "to", Type: "address", Indexed: true},
+	}}.Topic()
+	tests := []struct {
+		name string
+		tr   common.Transaction
+		want string
+	}{
+		{
+			name: "Ether received",
+			tr:   common.Transaction{From: otherAddress, To: testAddress, Value: "0x64", Input: "0x", Status: "0x1"},
+			want: common.LABELINCOMING,
+		}, {
+			name: "Ether sent",
+			tr:   common.Transaction{From: testAddress, To: otherAddress, Value: "0x64", Input: "0x", Status: "0x1"},
+			want: common.LABELOUTGOING,
+		}, {
+			name: "Sent to self with data",
+			tr:   common.Transaction{From: testAddress, To: strings.ToLower(testAddress), Value: "0x0", Input: "0x1234"},
+			want: common.LABELSELF,
+		}, {
+			name: "Failed transfer",
+			tr:   common.Transaction{From: testAddress, To: otherAddress, Value: "0x64", Input: "0x", Status: "0x0"},
+			want: common.LABELFAILED,
+		}, {
+			name: "Contract deployment",
+			tr:   common.Transaction{From: testAddress, Value: "0x0", Input: "0x6080604052", Status: "0x1"},
+			want: common.LABELDEPLOYMENT,
+		}, {
+			name: "Token approval",
+			tr:   common.Transaction{From: testAddress, To: tokenA, Value: "0x0", Input: input("approve(address,uint256)"), Status: "0x1"},
+			want: common.LABELAPPROVAL,
+		}, {
+			name: "Token sent",
+			tr: common.Transaction{From: testAddress, To: tokenA, Value: "0x0", Input: input("transfer(address,uint256)"), Status: "0x1",
+				Logs: []common.Log{transferLog(tokenA, testAddress, otherAddress)}},
+			want: common.LABELOUTGOING,
+		}, {
+			name: "Token received from a transaction of another account",
+			tr: common.Transaction{From: otherAddress, To: tokenA, Value: "0x0", Input: input("transfer(address,uint256)"), Status: "0x1",
+				Logs: []common.Log{transferLog(tokenA, otherAddress, testAddress)}},
+			want: common.LABELINCOMING,
+		}, {
+			name: "Token sent without receipt",
+			tr:   common.Transaction{From: testAddress, To: tokenA, Value: "0x0", Input: input("transfer(address,uint256)")},
+			want: common.LABELOUTGOING,
+		}, {
+			name: "Swap by the router function",
+			tr:   common.Transaction{From: testAddress, To: router, Value: "0x64", Input: input("swapExactETHForTokens(uint256,address[],address,uint256)"), Status: "0x1"},
+			want: common.LABELSWAP,
+		}, {
+			name: "Swap by the pool event",
+			tr: common.Transaction{From: testAddress, To: otherAddress, Value: "0x0", Input: "0x12345678", Status: "0x1",
+				Logs: []common.Log{{Address: otherAddress, Topics: []string{swapTopic, topic(router), topic(testAddress)}, Data: "0x" + strings.Repeat("0", 256)}}},
+			want: common.LABELSWAP,
+		}, {
+			name: "Swap by the tokens sent and received",
+			tr: common.Transaction{From: testAddress, To: otherAddress, Value: "0x0", Input: "0x12345678", Status: "0x1",
+				Logs: []common.Log{transferLog(tokenA, testAddress, otherAddress), transferLog(tokenB, otherAddress, testAddress)}},
+			want: common.LABELSWAP,
+		}, {
+			name: "Swap by ether sent and token received",
+			tr: common.Transaction{From: testAddress, To: otherAddress, Value: "0x64", Input: "0x12345678", Status: "0x1",
+				Logs: []common.Log{transferLog(tokenA, otherAddress, testAddress)}},
+			want: common.LABELSWAP,
+		}, {
+			name: "Swap of another account paying the account",
+			tr: common.Transaction{From: otherAddress, To: router, Value: "0x0", Input: input("swapExactTokensForTokens(uint256,uint256,address[],address,uint256)"), Status: "0x1",
+				Logs: []common.Log{transferLog(tokenA, otherAddress, router), transferLog(tokenB, router, testAddress)}},
+			want: common.LABELINCOMING,
+		}, {
+			name: "Removed transfer log is ignored",
+			tr: common.Transaction{From: otherAddress, To: tokenA, Value: "0x0", Input: "0x12345678", Status: "0x1",
+				Logs: []common.Log{func() common.Log { l := transferLog(tokenA, otherAddress, testAddress); l.Removed = true; return l }()}},
+			want: common.LABELCALL,
+		}, {
+			name: "Contract call",
+			tr:   common.Transaction{From: testAddress, To: otherAddress, Value: "0x0", Input: "0x12345678", Status: "0x1"},
+			want: common.LABELCALL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Default.Classify(testAddress, tt.tr); got != tt.want {
+				t.Errorf("Classifier.Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifier_Register(t *testing.T) {
+	c := New(DefaultRules()...)
+	c.Register(RuleFunc(func(address string, tr common.Transaction) (string, bool) {
+		return "bridge", strings.EqualFold(tr.To, router)
+	}))
+	tests := []struct {
+		name string
+		tr   common.Transaction
+		want string
+	}{
+		{
+			name: "Registered rule overrides the default rules",
+			tr:   common.Transaction{From: testAddress, To: router, Value: "0x64", Input: "0x", Status: "0x1"},
+			want: "bridge",
+		}, {
+			name: "Default rules apply otherwise",
+			tr:   common.Transaction{From: testAddress, To: otherAddress, Value: "0x64", Input: "0x", Status: "0x1"},
+			want: common.LABELOUTGOING,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Classify(testAddress, tt.tr); got != tt.want {
+				t.Errorf("Classifier.Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := New().Classify(testAddress, common.Transaction{}); got != "" {
+		t.Errorf("Classifier.Classify() without rule = %v, want empty", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	trans := []common.Transaction{
+		{Hash: "0x01", From: otherAddress, To: testAddress, Value: "0x64"},
+		{Hash: "0x02", From: testAddress, To: otherAddress, Value: "0x64"},
+		{Hash: "0x03", From: otherAddress, To: testAddress, Value: "0x64", Status: "0x0"},
+	}
+	Default.Label(testAddress, trans)
+	tests := []struct {
+		name  string
+		label string
+		want  []string
+	}{
+		{name: "Empty label keeps all", label: "", want: []string{"0x01", "0x02", "0x03"}},
+		{name: "Incoming", label: common.LABELINCOMING, want: []string{"0x01"}},
+		{name: "Failed", label: common.LABELFAILED, want: []string{"0x03"}},
+		{name: "Unknown label", label: "bridge", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, tr := range Filter(trans, tt.label) {
+				got = append(got, tr.Hash)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	auth "github.com/tonyxu1/transactionhistory/auth"
+	common "github.com/tonyxu1/transactionhistory/common"
+	export "github.com/tonyxu1/transactionhistory/export"
+)
+
+// Run runs the command named by the first argument against a running server
+func Run(ctx context.Context, args []string, stdout io.Writer) error {
+	switch args[0] {
+	case "export":
+		return Export(ctx, args[1:], stdout)
+	case "archive":
+		return Archive(ctx, args[1:], stdout)
+	case "import":
+		return Import(ctx, args[1:], stdout)
+	case "repair":
+		return Repair(ctx, args[1:], stdout)
+	}
+	return fmt.Errorf("unknown command [%s], expecting export, archive, import or repair", args[0])
+}
+
+// Export downloads the transactions of an account from the /export endpoint of the
+// server to the output file, or to stdout
+func Export(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	server := fs.String("server", common.SERVERURL, "base url of the server")
+	key := fs.String("key", os.Getenv(common.APIKEYENV), "api key, defaults to $"+common.APIKEYENV)
+	chain := fs.String("chain", "", "network of the account, defaults to the default network of the server")
+	address := fs.String("address", "", "address of the subscribed account")
+	format := fs.String("format", export.CSV, "csv, jsonl or parquet")
+	fromBlock := fs.String("from-block", "", "first block, inclusive")
+	toBlock := fs.String("to-block", "", "last block, inclusive")
+	fromTime := fs.String("from-time", "", "first block time, unix seconds, RFC 3339 or YYYY-MM-DD")
+	toTime := fs.String("to-time", "", "block time upper bound, exclusive")
+	label := fs.String("label", "", "label of the transactions such as incoming_transfer or swap")
+	output := fs.String("o", "", "output file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("address", *address)
+	query.Set("format", *format)
+	for name, v := range map[string]string{common.CHAINPARAM: *chain, "fromBlock": *fromBlock, "toBlock": *toBlock, "fromTime": *fromTime, "toTime": *toTime, common.LABELPARAM: *label} {
+		if v != "" {
+			query.Set(name, v)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *server+"/export?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(auth.APIKEYHEADER, *key)
+	return download(req, *output, stdout)
+}
+
+// Archive downloads all the accounts of a network with their checkpoints and transactions
+// from the /admin/archive endpoint of the server as a JSON Lines archive
+func Archive(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	server := fs.String("server", common.SERVERURL, "base url of the server")
+	token := fs.String("token", os.Getenv(common.ADMINTOKENENV), "admin token, defaults to $"+common.ADMINTOKENENV)
+	chain := fs.String("chain", "", "network archived, defaults to the default network of the server")
+	output := fs.String("o", "", "output file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *server+"/admin/archive"+chainQuery(*chain), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(auth.ADMINTOKENHEADER, *token)
+	return download(req, *output, stdout)
+}
+
+// Import uploads a JSON Lines archive of a network to the /admin/import endpoint of the
+// server and prints the import statistics
+func Import(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	server := fs.String("server", common.SERVERURL, "base url of the server")
+	token := fs.String("token", os.Getenv(common.ADMINTOKENENV), "admin token, defaults to $"+common.ADMINTOKENENV)
+	chain := fs.String("chain", "", "network imported into, defaults to the default network of the server")
+	input := fs.String("i", "", "archive file, defaults to stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var body io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *server+"/admin/import"+chainQuery(*chain), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(auth.ADMINTOKENHEADER, *token)
+	return download(req, "", stdout)
+}
+
+// Repair removes the transactions stored more than once for an account, or for all the accounts
+// of a network, with the /admin/repair endpoint of the server and prints the number removed
+func Repair(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	server := fs.String("server", common.SERVERURL, "base url of the server")
+	token := fs.String("token", os.Getenv(common.ADMINTOKENENV), "admin token, defaults to $"+common.ADMINTOKENENV)
+	chain := fs.String("chain", "", "network repaired, defaults to the default network of the server")
+	address := fs.String("address", "", "address of the account, defaults to all the accounts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for name, v := range map[string]string{common.CHAINPARAM: *chain, "address": *address} {
+		if v != "" {
+			query.Set(name, v)
+		}
+	}
+	endpoint := *server + "/admin/repair"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(auth.ADMINTOKENHEADER, *token)
+	return download(req, "", stdout)
+}
+
+// chainQuery returns the query string selecting the network, empty for the default network
+func chainQuery(chain string) string {
+	if chain == "" {
+		return ""
+	}
+	return "?" + url.Values{common.CHAINPARAM: {chain}}.Encode()
+}
+
+// download sends the request and copies the response body to the output file, or to stdout
+func download(req *http.Request, output string, stdout io.Writer) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s failed: %s: %s", req.URL.Path, resp.Status, msg)
+	}
+
+	if output == "" {
+		_, err = io.Copy(stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
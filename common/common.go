@@ -1,6 +1,10 @@
 package common
 
-import "time"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 //
 // Constants
@@ -18,6 +22,33 @@ const (
 	// Get current block number
 	BLOCKNUMBER = `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`
 
+	// Get the chain id served by the endpoint
+	CHAINID = `{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`
+
+	// Get a transaction by its hash
+	GETTRANSACTIONBYHASH = `{"jsonrpc":"2.0","method":"eth_getTransactionByHash","params":["%s"],"id":3}`
+
+	// Get the native balance of an address at a block
+	GETBALANCE = `{"jsonrpc":"2.0","method":"eth_getBalance","params":["%s","%s"],"id":4}`
+
+	// Get the receipt of a transaction by its hash
+	GETTRANSACTIONRECEIPT = `{"jsonrpc":"2.0","method":"eth_getTransactionReceipt","params":["%s"],"id":5}`
+
+	// Get the logs matching a filter object
+	GETLOGS = `{"jsonrpc":"2.0","method":"eth_getLogs","params":[%s],"id":6}`
+
+	// Call a contract with the given data at a block without sending a transaction
+	ETHCALL = `{"jsonrpc":"2.0","method":"eth_call","params":[{"to":"%s","data":"%s"},"%s"],"id":7}`
+
+	// Topic of the ERC-20 Transfer(address,address,uint256) event
+	TRANSFERTOPIC = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+	// Selectors of the ERC-20 functions read by eth_call
+	BALANCEOFSELECTOR = "0x70a08231"
+	DECIMALSSELECTOR  = "0x313ce567"
+	SYMBOLSELECTOR    = "0x95d89b41"
+	NAMESELECTOR      = "0x06fdde03"
+
 	// Number of blocks goes back from most recent chain block number for transaction retrieval
 	LOOKBACKBLOCKS = 1000000
 
@@ -32,6 +63,151 @@ const (
 
 	// Idle period between each round
 	INTERVALINSECONDS = 10 * time.Second
+
+	// Accounts further behind chain head than this number of blocks are in catch-up mode,
+	// scanned continuously without the idle period between rounds
+	CATCHUPTHRESHOLD = 1000
+
+	// Bounds of the number of go routines and blocks per round chosen by the adaptive controller,
+	// which starts from NUMOFROUTINES and BLOCKSPERROUND
+	MINROUTINES       = 1
+	MAXROUTINES       = 32
+	MINBLOCKSPERROUND = 10
+	MAXBLOCKSPERROUND = 2000
+
+	// Maximum number of blocks of an eth_getLogs request, the token transfers of a wider range
+	// are read in several requests as many providers reject wide ranges
+	MAXLOGSRANGE = 1000
+
+	// Blocks per round added by the adaptive controller after a healthy round
+	ADAPTIVEBATCHSTEP = 50
+
+	// Average Json RPC latency and error rate above which the adaptive controller backs off
+	ADAPTIVETARGETLATENCY = 500 * time.Millisecond
+	ADAPTIVEMAXERRORRATE  = 0.05
+
+	// Maximum number of block headers kept in the header cache, the oldest saved are evicted first
+	HEADERCACHESIZE = 10000
+
+	// Default deadline for in-flight requests and scans to finish on shutdown
+	SHUTDOWNTIMEOUT = 30 * time.Second
+
+	// Environment variable overriding the shutdown deadline, as a Go duration such as "1m"
+	SHUTDOWNTIMEOUTENV = "SHUTDOWN_TIMEOUT"
+
+	// Maximum age of the chain head fetched by the background loop for the service to be ready
+	HEADMAXAGE = 2 * time.Minute
+
+	// Listen address of the gRPC server
+	GRPCADDRESS = ":8486"
+
+	// Default number of addresses an API key can subscribe
+	MAXSUBSCRIPTIONSPERKEY = 10
+
+	// Environment variable holding the path of the storage snapshot, snapshots are disabled when empty
+	SNAPSHOTPATHENV = "SNAPSHOT_PATH"
+
+	// Period between the storage snapshots, a snapshot is also written on shutdown
+	SNAPSHOTINTERVAL = 5 * time.Minute
+
+	// Suffix of the snapshot path for the snapshot of the API keys and the accounts they subscribed,
+	// shared by all the networks
+	KEYSSNAPSHOTSUFFIX = ".keys"
+
+	// Name of the network used when the chain parameter is omitted and no network is configured,
+	// its storage snapshot and cold storage file keep the configured paths without suffix
+	DEFAULTNETWORK = "mainnet"
+
+	// Chain ID of the default network
+	DEFAULTCHAINID = 1
+
+	// Environment variable holding the path of the JSON file configuring the networks,
+	// only the default network is served when empty
+	NETWORKSCONFIGENV = "NETWORKS_CONFIG"
+
+	// Query parameter selecting the network of a request
+	CHAINPARAM = "chain"
+
+	// Period between the runs of the retention compactor
+	COMPACTIONINTERVAL = 10 * time.Minute
+
+	// Environment variable holding the path of the cold storage file the pruned transactions
+	// are appended to, pruned transactions are dropped when empty
+	RETENTIONARCHIVEENV = "RETENTION_ARCHIVE_PATH"
+
+	// Period between the balance checkpoints of an account, the balance read from the node is
+	// compared to the balance computed over the transactions stored since the previous checkpoint
+	BALANCEINTERVAL = 10 * time.Minute
+
+	// Receipt status of a transaction executed successfully, any other status is a failure
+	RECEIPTSUCCESS = "0x1"
+
+	// Environment variable holding the path of a file adding function signatures to the selector
+	// database, one per line such as "transfer(address,uint256)", lines starting with # are ignored
+	SELECTORSPATHENV = "SELECTORS_PATH"
+
+	// Maximum size in bytes of a JSON ABI registered for a contract
+	MAXABISIZE = 1 << 20
+
+	// Sources of a decoded transaction input or log, the ABI registered for the contract, the selector
+	// database, or the event ABIs registered by signature and the builtin events
+	DECODEDBYABI      = "abi"
+	DECODEDBYSELECTOR = "selector"
+	DECODEDBYEVENT    = "event"
+
+	// Labels of a transaction relative to an account, given by the classifier
+	LABELINCOMING   = "incoming_transfer"
+	LABELOUTGOING   = "outgoing_transfer"
+	LABELSELF       = "self_transfer"
+	LABELCALL       = "contract_call"
+	LABELDEPLOYMENT = "contract_deployment"
+	LABELAPPROVAL   = "token_approval"
+	LABELSWAP       = "swap"
+	LABELFAILED     = "failed"
+
+	// Query parameter filtering the transactions by label
+	LABELPARAM = "label"
+
+	// Base url of the server used by the command line
+	SERVERURL = "http://localhost:8485"
+
+	// Environment variable holding the API key used by the command line
+	APIKEYENV = "API_KEY"
+
+	// Environment variable holding the admin token for issuing and revoking API keys
+	ADMINTOKENENV = "ADMIN_TOKEN"
+
+	// Environment variable holding the log level: debug, info, warn or error
+	LOGLEVELENV = "LOG_LEVEL"
+
+	// Environment variable selecting the log format, "json" for JSON output otherwise text
+	LOGFORMATENV = "LOG_FORMAT"
+
+	// Requests per second and burst allowed per client on subscribe, which calls the Json RPC endpoint
+	SUBSCRIBERATELIMIT = 0.2
+	SUBSCRIBEBURST     = 3
+
+	// Number of decimals of ether, values are in wei
+	ETHERDECIMALS = 18
+
+	// Directions of a transaction relative to an account
+	DIRECTIONIN   = "in"
+	DIRECTIONOUT  = "out"
+	DIRECTIONSELF = "self"
+
+	// Roles of a subscribed account in a transaction
+	ROLESENDER    = "sender"
+	ROLERECIPIENT = "recipient"
+	ROLEBOTH      = "both"
+
+	// Requests per second and burst allowed per client on the other endpoints
+	DEFAULTRATELIMIT = 10
+	DEFAULTBURST     = 20
+
+	// Requests per second and burst allowed per IP address on the public endpoints before the
+	// API key is checked, above the limits per key as clients may share an address
+	IPRATELIMIT = 50
+	IPBURST     = 100
 )
 
 //
@@ -52,6 +228,9 @@ type Parser interface {
 	// add address to observer
 	Subscribe() error
 
+	// remove address from observer
+	Unsubscribe() error
+
 	// list of inbound or outbound transactions for an address
 	GetTransactions() ([]Transaction, error)
 }
@@ -60,16 +239,122 @@ type Parser interface {
 type Storage interface {
 
 	//Save current block to storage
-	CreateAccount(address string) error
+	CreateAccount(ctx context.Context, address string) error
+
+	//Create the account with the given next block to scan, without reading the chain
+	RestoreAccount(ctx context.Context, address string, block int) error
+
+	//Remove the account and its transactions from storage
+	RemoveAccount(ctx context.Context, address string) error
 
 	//Save transactions retrieved from chain to the storage
-	SaveTransactions(address string, transactions []Transaction) error
+	SaveTransactions(ctx context.Context, address string, transactions []Transaction) error
+
+	//List the addresses of all accounts in the storage
+	GetAccounts(ctx context.Context) ([]string, error)
+
+	//Remove the transactions stored more than once for the given address, returns the number removed
+	DeduplicateTransactions(ctx context.Context, address string) (int, error)
+
+	//Save the next block to scan for the given address
+	SaveCheckpoint(ctx context.Context, address string, block int) error
 
 	//Get most recent block number in the storage for the given address
-	GetCurrentBlock(address string) (int, error)
+	GetCurrentBlock(ctx context.Context, address string) (int, error)
 
 	//Get the transaction history information from the storage
-	GetTransactions(address string) ([]Transaction, error)
+	GetTransactions(ctx context.Context, address string) ([]Transaction, error)
+
+	//Get the transactions of the given address within the inclusive block range, ordered by block number descending
+	GetTransactionsByBlockRange(ctx context.Context, address string, fromBlock, toBlock int) ([]Transaction, error)
+
+	//Get the transactions between the given address and the counterparty address, ordered by block number descending
+	GetTransactionsByCounterparty(ctx context.Context, address string, counterparty string) ([]Transaction, error)
+
+	//Get the transaction of the given address with the given hash
+	GetTransaction(ctx context.Context, address string, hash string) (Transaction, error)
+
+	//Find the stored transaction with the given hash and the accounts it belongs to
+	FindTransaction(ctx context.Context, hash string) (Transaction, []AccountMatch, error)
+
+	//Save the header of a block to the bounded header cache
+	SaveHeader(ctx context.Context, header Header) error
+
+	//Get the cached header of the block with the given number
+	GetHeader(ctx context.Context, number int) (Header, error)
+
+	//Get the cached headers, oldest saved first
+	GetHeaders(ctx context.Context) ([]Header, error)
+
+	//Remove the given transactions of the address, identified by block hash and hash, returns the number removed
+	RemoveTransactions(ctx context.Context, address string, transactions []Transaction) (int, error)
+
+	//Set the retention policy of the address
+	SetRetention(ctx context.Context, address string, policy RetentionPolicy) error
+
+	//Get the retention policy of the address, the zero policy keeps everything
+	GetRetention(ctx context.Context, address string) (RetentionPolicy, error)
+
+	//Save a balance checkpoint of the address, replacing the checkpoint of the same block
+	SaveBalance(ctx context.Context, address string, checkpoint BalanceCheckpoint) error
+
+	//Get the balance checkpoints of the address, ordered by block number ascending
+	GetBalances(ctx context.Context, address string) ([]BalanceCheckpoint, error)
+
+	//Save the token transfers of the address, the transfers already stored are skipped
+	SaveTokenTransfers(ctx context.Context, address string, transfers []TokenTransfer) error
+
+	//Get the token transfers of the address, ordered by block number and log index ascending
+	GetTokenTransfers(ctx context.Context, address string) ([]TokenTransfer, error)
+
+	//Save a token balance checkpoint of the address, replacing the checkpoint of the same token and block
+	SaveTokenCheckpoint(ctx context.Context, address string, checkpoint TokenCheckpoint) error
+
+	//Get the token balance checkpoints of the address, ordered by token then block number ascending
+	GetTokenCheckpoints(ctx context.Context, address string) ([]TokenCheckpoint, error)
+
+	//Save the first block whose token transfers of the address are not retrieved yet
+	SaveTokenBlock(ctx context.Context, address string, block int) error
+
+	//Get the first block whose token transfers of the address are not retrieved yet,
+	//the checkpoint of the account until a token block is saved
+	GetTokenBlock(ctx context.Context, address string) (int, error)
+
+	//Save the metadata of a token to the token cache
+	SaveToken(ctx context.Context, token Token) error
+
+	//Get the cached metadata of the token with the given contract address
+	GetToken(ctx context.Context, address string) (Token, error)
+
+	//Get the cached metadata of all the tokens, ordered by address
+	GetTokens(ctx context.Context) ([]Token, error)
+
+	//Save the ABI of a contract, replacing the ABI registered for the same address
+	SaveABI(ctx context.Context, contract ContractABI) error
+
+	//Get the ABI registered for the contract address
+	GetABI(ctx context.Context, address string) (ContractABI, error)
+
+	//Get the ABIs of all the contracts, ordered by address
+	GetABIs(ctx context.Context) ([]ContractABI, error)
+
+	//Remove the ABI registered for the contract address
+	RemoveABI(ctx context.Context, address string) error
+
+	//Save the ABI of an event, replacing the event ABI registered for the same topic
+	SaveEventABI(ctx context.Context, event EventABI) error
+
+	//Get the event ABI registered for the topic
+	GetEventABI(ctx context.Context, topic string) (EventABI, error)
+
+	//Get all the event ABIs, ordered by topic
+	GetEventABIs(ctx context.Context) ([]EventABI, error)
+
+	//Remove the event ABI registered for the topic
+	RemoveEventABI(ctx context.Context, topic string) error
+
+	//Watch the transactions saved for the address, the returned function stops watching
+	WatchTransactions(ctx context.Context, address string) (<-chan Transaction, func(), error)
 }
 
 //
@@ -114,6 +399,249 @@ type Transaction struct {
 	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas"`
 	ChainID              string        `json:"chainId"`
 	AccessList           []interface{} `json:"accessList"`
+	Timestamp            string        `json:"timestamp"`
+	// Receipt fields of the transactions retrieved by the scanner, empty otherwise
+	Status            string `json:"status,omitempty"`
+	GasUsed           string `json:"gasUsed,omitempty"`
+	EffectiveGasPrice string `json:"effectiveGasPrice,omitempty"`
+	Logs              []Log  `json:"logs,omitempty"`
+	// Input decoded when the transaction is returned by the API, never stored
+	Decoded *DecodedCall `json:"decoded,omitempty"`
+	// Label relative to the account when the transaction is returned by the API, never stored
+	Label string `json:"label,omitempty"`
+}
+
+// Receipt defines the receipt fields of an executed transaction
+type Receipt struct {
+	TransactionHash   string `json:"transactionHash"`
+	Status            string `json:"status"`
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	ContractAddress   string `json:"contractAddress"`
+	Logs              []Log  `json:"logs"`
+}
+
+// Log defines an event log emitted by a transaction
+type Log struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     string   `json:"blockNumber"`
+	BlockHash       string   `json:"blockHash"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+	Removed         bool     `json:"removed,omitempty"`
+	// Event decoded when the transaction of the log is returned by the API, never stored
+	Decoded *DecodedLog `json:"decoded,omitempty"`
+}
+
+// LogFilter defines the filter object of eth_getLogs, a nil topic matches any topic
+// and a list of topics matches any of them
+type LogFilter struct {
+	FromBlock string        `json:"fromBlock"`
+	ToBlock   string        `json:"toBlock"`
+	Address   []string      `json:"address,omitempty"`
+	Topics    []interface{} `json:"topics,omitempty"`
+}
+
+// Header defines the header fields of a block kept in the header cache
+type Header struct {
+	Number        string `json:"number"`
+	Hash          string `json:"hash"`
+	ParentHash    string `json:"parentHash"`
+	Timestamp     string `json:"timestamp"`
+	BaseFeePerGas string `json:"baseFeePerGas"`
+}
+
+// Network defines a chain served by the deployment and how it is scanned
+type Network struct {
+	Name    string `json:"name"`
+	ChainID int64  `json:"chain_id"`
+	// Json RPC endpoints tried in order until one answers
+	RPCEndpoints []string `json:"rpc_endpoints"`
+	// Number of blocks before chain head new subscriptions start from
+	LookbackBlocks int `json:"lookback_blocks"`
+	// Number of blocks behind chain head not scanned yet, as they may be reorganized
+	ConfirmationDepth int `json:"confirmation_depth"`
+	// Idle period between the rounds of the network
+	BlockTime time.Duration `json:"-"`
+}
+
+// RetentionPolicy defines how much history is kept for an account, a zero field is unlimited.
+// Transactions older than Days days, more than Blocks blocks behind the account checkpoint
+// or beyond the Transactions most recent ones are pruned.
+type RetentionPolicy struct {
+	Days         int `json:"days"`
+	Blocks       int `json:"blocks"`
+	Transactions int `json:"transactions"`
+}
+
+// BalanceCheckpoint defines the native balance of an account read from the node at a block.
+// Computed is the balance expected from the previous checkpoint and the transactions stored
+// in between, Drift the difference Balance - Computed, both are empty for the first checkpoint.
+// Amounts are decimal wei.
+type BalanceCheckpoint struct {
+	Block     int       `json:"block"`
+	Balance   string    `json:"balance"`
+	Computed  string    `json:"computed,omitempty"`
+	Drift     string    `json:"drift,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// BalancePoint defines the native balance of an account after a transaction, Delta is the
+// change of balance including the fee paid. FeeUnknown is set for a sent transaction stored
+// without receipt, whose fee is not counted. Amounts are decimal wei.
+type BalancePoint struct {
+	BlockNumber int    `json:"block_number"`
+	Hash        string `json:"hash"`
+	Delta       string `json:"delta"`
+	Fee         string `json:"fee"`
+	Balance     string `json:"balance"`
+	FeeUnknown  bool   `json:"fee_unknown,omitempty"`
+}
+
+// AccountBalance defines the native balance of an account at the last block scanned, computed
+// from its last balance checkpoint and the transactions stored after it. FeesUnknown is the
+// number of those transactions whose fee is not counted.
+type AccountBalance struct {
+	Address      string             `json:"address"`
+	Block        int                `json:"block"`
+	Balance      string             `json:"balance"`
+	BalanceEther string             `json:"balance_ether"`
+	Checkpoint   *BalanceCheckpoint `json:"checkpoint"`
+	FeesUnknown  int                `json:"fees_unknown,omitempty"`
+}
+
+// BalanceHistory defines the balance checkpoints of an account and its balance after
+// each stored transaction, oldest first
+type BalanceHistory struct {
+	Address     string              `json:"address"`
+	Checkpoints []BalanceCheckpoint `json:"checkpoints"`
+	Points      []BalancePoint      `json:"points"`
+}
+
+// TokenTransfer defines an ERC-20 Transfer log sent from or to an account, Value is in the
+// smallest unit of the token as a decimal string
+type TokenTransfer struct {
+	Token           string `json:"token"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Value           string `json:"value"`
+	BlockNumber     int    `json:"block_number"`
+	BlockHash       string `json:"block_hash"`
+	TransactionHash string `json:"transaction_hash"`
+	LogIndex        int    `json:"log_index"`
+}
+
+// Token defines the metadata of an ERC-20 token, Decimals is -1 when the contract does not expose it
+type Token struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Decimals int    `json:"decimals"`
+}
+
+// TokenCheckpoint defines the balance of a token held by an account read with balanceOf at
+// a block, Computed and Drift are relative to the previous checkpoint of the same token
+type TokenCheckpoint struct {
+	Token string `json:"token"`
+	BalanceCheckpoint
+}
+
+// TokenHolding defines the balance of a token held by an account at the last block scanned,
+// computed from its last checkpoint and the transfers stored after it. Balance is in the
+// smallest unit, BalanceFormatted with the token decimals and empty when they are unknown.
+type TokenHolding struct {
+	Token            Token            `json:"token"`
+	Block            int              `json:"block"`
+	Balance          string           `json:"balance"`
+	BalanceFormatted string           `json:"balance_formatted"`
+	Checkpoint       *TokenCheckpoint `json:"checkpoint"`
+}
+
+// TokenBalancePoint defines the balance of a token held by an account after a transfer
+type TokenBalancePoint struct {
+	BlockNumber     int    `json:"block_number"`
+	TransactionHash string `json:"transaction_hash"`
+	LogIndex        int    `json:"log_index"`
+	Delta           string `json:"delta"`
+	Balance         string `json:"balance"`
+}
+
+// TokenHistory defines the balance checkpoints of a token held by an account and its
+// balance after each stored transfer, oldest first
+type TokenHistory struct {
+	Token       Token               `json:"token"`
+	Checkpoints []TokenCheckpoint   `json:"checkpoints"`
+	Points      []TokenBalancePoint `json:"points"`
+}
+
+// DecodedArg defines an argument of a decoded call. Value is a decimal string for integers, a
+// hex string for addresses and bytes, a list for arrays and a list of DecodedArg for tuples.
+type DecodedArg struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DecodedCall defines the function called by a transaction input. Method is empty when the
+// selector is unknown, Error is set when the arguments do not match the known function.
+type DecodedCall struct {
+	Selector  string       `json:"selector"`
+	Method    string       `json:"method,omitempty"`
+	Signature string       `json:"signature,omitempty"`
+	Args      []DecodedArg `json:"args,omitempty"`
+	Source    string       `json:"source,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// DecodedLog defines the event of a decoded log. Event is empty when the topic is unknown, Error
+// is set when the topics and data do not match the known event. An indexed argument of a
+// dynamic, array or tuple type is hashed, its value is the topic.
+type DecodedLog struct {
+	Topic     string       `json:"topic"`
+	Event     string       `json:"event,omitempty"`
+	Signature string       `json:"signature,omitempty"`
+	Args      []DecodedArg `json:"args,omitempty"`
+	Source    string       `json:"source,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// EventABI defines the JSON ABI of a single event registered by its topic, the keccak256
+// hash of its signature, decoding the logs of any contract
+type EventABI struct {
+	Topic        string          `json:"topic"`
+	Signature    string          `json:"signature"`
+	ABI          json.RawMessage `json:"abi"`
+	RegisteredAt time.Time       `json:"registered_at"`
+}
+
+// ContractABI defines the JSON ABI registered for a contract address
+type ContractABI struct {
+	Address      string          `json:"address"`
+	ABI          json.RawMessage `json:"abi"`
+	RegisteredAt time.Time       `json:"registered_at"`
+}
+
+// AccountMatch defines a subscribed account a transaction belongs to, with its role
+// ROLESENDER, ROLERECIPIENT or ROLEBOTH and the label of the transaction relative to it
+type AccountMatch struct {
+	Address string `json:"address"`
+	Role    string `json:"role"`
+	Label   string `json:"label,omitempty"`
+}
+
+// TransactionLookup defines a transaction looked up by hash with its block context
+type TransactionLookup struct {
+	Transaction   Transaction    `json:"transaction"`
+	Accounts      []AccountMatch `json:"accounts"`
+	Stored        bool           `json:"stored"`
+	Pending       bool           `json:"pending"`
+	BlockNumber   int            `json:"block_number"`
+	BlockHash     string         `json:"block_hash"`
+	Timestamp     string         `json:"timestamp"`
+	ChainHead     int            `json:"chain_head"`
+	Confirmations int            `json:"confirmations"`
 }
 
 // Block defines the schema of a block in Ethereum
@@ -0,0 +1,269 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Export formats
+const (
+	CSV     = "csv"
+	JSONL   = "jsonl"
+	PARQUET = "parquet"
+)
+
+// Record is the stable schema of an exported transaction, columns are only ever added at the end.
+// Values are decimal strings so that wei amounts do not lose precision.
+type Record struct {
+	Address          string `json:"address" parquet:"address"`
+	ChainID          string `json:"chain_id" parquet:"chain_id"`
+	Hash             string `json:"hash" parquet:"hash"`
+	BlockNumber      int64  `json:"block_number" parquet:"block_number"`
+	BlockHash        string `json:"block_hash" parquet:"block_hash"`
+	Timestamp        string `json:"timestamp" parquet:"timestamp"`
+	TransactionIndex int64  `json:"transaction_index" parquet:"transaction_index"`
+	Direction        string `json:"direction" parquet:"direction"`
+	From             string `json:"from" parquet:"from"`
+	To               string `json:"to" parquet:"to"`
+	ValueWei         string `json:"value_wei" parquet:"value_wei"`
+	ValueEther       string `json:"value_ether" parquet:"value_ether"`
+	GasLimit         int64  `json:"gas_limit" parquet:"gas_limit"`
+	GasPriceGwei     string `json:"gas_price_gwei" parquet:"gas_price_gwei"`
+	// gas limit times gas price, the fee paid is at most this amount
+	MaxFeeEther string `json:"max_fee_ether" parquet:"max_fee_ether"`
+	Nonce       int64  `json:"nonce" parquet:"nonce"`
+	Type        int64  `json:"type" parquet:"type"`
+	// receipt fields, empty and 0 for transactions stored without receipt
+	Status   string `json:"status" parquet:"status"`
+	GasUsed  int64  `json:"gas_used" parquet:"gas_used"`
+	FeeEther string `json:"fee_ether" parquet:"fee_ether"`
+	// name of the function called, its selector when unknown, empty without input
+	Method string `json:"method" parquet:"method"`
+	// JSON array of the decoded logs of the transaction, empty without decoded log
+	Events string `json:"events" parquet:"events"`
+	// label of the transaction relative to the account, empty unless the transaction was labeled
+	Label string `json:"label" parquet:"label"`
+}
+
+// Event is an element of the events column, Event is the topic of an unknown event and
+// Args are keyed by argument name, or by position when unnamed
+type Event struct {
+	LogIndex int64                  `json:"log_index"`
+	Address  string                 `json:"address"`
+	Event    string                 `json:"event"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+}
+
+// Columns are the CSV header, in the order of the Record fields
+var Columns = []string{
+	"address", "chain_id", "hash", "block_number", "block_hash", "timestamp", "transaction_index", "direction",
+	"from", "to", "value_wei", "value_ether", "gas_limit", "gas_price_gwei", "max_fee_ether", "nonce", "type",
+	"status", "gas_used", "fee_ether", "method", "events", "label",
+}
+
+// Receipt statuses of the exported records
+const (
+	STATUSSUCCESS = "success"
+	STATUSFAILED  = "failed"
+)
+
+// NewRecord converts the transaction of the account to its exported record,
+// the timestamp is RFC 3339 in UTC and empty when unknown, the method and events
+// are empty unless the input and logs of the transaction were decoded, and the label
+// unless it was labeled
+func NewRecord(address string, tr common.Transaction) Record {
+	value := quantity(tr.Value)
+	gas := quantity(tr.Gas)
+	gasPrice := quantity(tr.GasPrice)
+
+	r := Record{
+		Address:          address,
+		ChainID:          quantity(tr.ChainID).String(),
+		Hash:             tr.Hash,
+		BlockNumber:      quantity(tr.BlockNumber).Int64(),
+		BlockHash:        tr.BlockHash,
+		TransactionIndex: quantity(tr.TransactionIndex).Int64(),
+		Direction:        Direction(address, tr),
+		From:             tr.From,
+		To:               tr.To,
+		ValueWei:         value.String(),
+		ValueEther:       util.FormatUnits(value, common.ETHERDECIMALS),
+		GasLimit:         gas.Int64(),
+		GasPriceGwei:     util.FormatUnits(gasPrice, 9),
+		MaxFeeEther:      util.FormatUnits(new(big.Int).Mul(gas, gasPrice), common.ETHERDECIMALS),
+		Nonce:            quantity(tr.Nonce).Int64(),
+		Type:             quantity(tr.Type).Int64(),
+		Label:            tr.Label,
+	}
+	if tr.Timestamp != "" {
+		r.Timestamp = time.Unix(quantity(tr.Timestamp).Int64(), 0).UTC().Format(time.RFC3339)
+	}
+	if tr.Status != "" {
+		r.Status = STATUSFAILED
+		if tr.Status == common.RECEIPTSUCCESS {
+			r.Status = STATUSSUCCESS
+		}
+	}
+	if tr.GasUsed != "" {
+		gasUsed := quantity(tr.GasUsed)
+		price := tr.EffectiveGasPrice
+		if price == "" {
+			price = tr.GasPrice
+		}
+		r.GasUsed = gasUsed.Int64()
+		r.FeeEther = util.FormatUnits(new(big.Int).Mul(gasUsed, quantity(price)), common.ETHERDECIMALS)
+	}
+	if tr.Decoded != nil {
+		r.Method = tr.Decoded.Method
+		if r.Method == "" {
+			r.Method = tr.Decoded.Selector
+		}
+	}
+	r.Events = events(tr.Logs)
+	return r
+}
+
+// events returns the decoded logs as the JSON array of the events column
+func events(logs []common.Log) string {
+	list := make([]Event, 0, len(logs))
+	for _, l := range logs {
+		if l.Decoded == nil {
+			continue
+		}
+		e := Event{LogIndex: quantity(l.LogIndex).Int64(), Address: l.Address, Event: l.Decoded.Event}
+		if e.Event == "" {
+			e.Event = l.Decoded.Topic
+		}
+		if len(l.Decoded.Args) > 0 {
+			e.Args = make(map[string]interface{}, len(l.Decoded.Args))
+			for i, a := range l.Decoded.Args {
+				name := a.Name
+				if name == "" {
+					name = strconv.Itoa(i)
+				}
+				e.Args[name] = a.Value
+			}
+		}
+		list = append(list, e)
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// Direction returns the direction of the transaction relative to the address
+func Direction(address string, tr common.Transaction) string {
+	from := strings.EqualFold(tr.From, address)
+	to := strings.EqualFold(tr.To, address)
+	switch {
+	case from && to:
+		return common.DIRECTIONSELF
+	case from:
+		return common.DIRECTIONOUT
+	}
+	return common.DIRECTIONIN
+}
+
+// quantity decodes the hex quantity, an invalid quantity is 0
+func quantity(v string) *big.Int {
+	n, err := util.DecodeQuantity(v)
+	if err != nil {
+		return new(big.Int)
+	}
+	return n
+}
+
+// Writer streams records in one of the export formats, Close must be called
+// to flush the records written
+type Writer interface {
+	Write(r Record) error
+	Close() error
+}
+
+// NewWriter returns a writer of the format to w
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case CSV:
+		cw := &csvWriter{w: csv.NewWriter(w)}
+		if err := cw.w.Write(Columns); err != nil {
+			return nil, err
+		}
+		return cw, nil
+	case JSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case PARQUET:
+		return &parquetWriter{w: parquet.NewGenericWriter[Record](w, parquet.Compression(&parquet.Snappy))}, nil
+	}
+	return nil, fmt.Errorf("unknown export format [%s], expecting %s, %s or %s", format, CSV, JSONL, PARQUET)
+}
+
+// ContentType returns the media type of the format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case JSONL:
+		return "application/jsonl"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(r Record) error {
+	return c.w.Write([]string{
+		r.Address, r.ChainID, r.Hash, strconv.FormatInt(r.BlockNumber, 10), r.BlockHash, r.Timestamp,
+		strconv.FormatInt(r.TransactionIndex, 10), r.Direction, r.From, r.To, r.ValueWei, r.ValueEther,
+		strconv.FormatInt(r.GasLimit, 10), r.GasPriceGwei, r.MaxFeeEther, strconv.FormatInt(r.Nonce, 10),
+		strconv.FormatInt(r.Type, 10), r.Status, strconv.FormatInt(r.GasUsed, 10), r.FeeEther,
+		r.Method, r.Events, r.Label,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(r Record) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w *parquet.GenericWriter[Record]
+}
+
+func (p *parquetWriter) Write(r Record) error {
+	_, err := p.w.Write([]Record{r})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
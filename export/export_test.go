@@ -0,0 +1,160 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+
+var testTransaction = common.Transaction{
+	Type:              "0x2",
+	BlockHash:         "0xb1",
+	BlockNumber:       "0xe4e1c0",
+	From:              "0x1111111111111111111111111111111111111111",
+	To:                "0x23ca95b9de14a83cbf4a43b11c2c3825e72c7d9b",
+	Gas:               "0x5208",
+	GasPrice:          "0x4a817c800",
+	Hash:              "0x01",
+	Nonce:             "0x7",
+	TransactionIndex:  "0x3",
+	Value:             "0xde0b6b3a7640000",
+	ChainID:           "0x1",
+	Timestamp:         "0x6553d2c0",
+	Status:            "0x1",
+	GasUsed:           "0x5208",
+	EffectiveGasPrice: "0x3b9aca00",
+	Decoded:           &common.DecodedCall{Selector: "0xa9059cbb", Method: "transfer"},
+	Label:             common.LABELINCOMING,
+	Logs: []common.Log{
+		{Address: "0xa0b8", LogIndex: "0x4", Decoded: &common.DecodedLog{Event: "Transfer", Args: []common.DecodedArg{{Name: "value", Value: "1"}}}},
+		{Address: "0xa0b8", LogIndex: "0x5", Decoded: &common.DecodedLog{Topic: "0x8c5b", Args: []common.DecodedArg{{Value: true}}}},
+		{Address: "0xa0b8", LogIndex: "0x6"},
+	},
+}
+
+func TestNewRecord(t *testing.T) {
+	want := Record{
+		Address:          testAddress,
+		ChainID:          "1",
+		Hash:             "0x01",
+		BlockNumber:      15000000,
+		BlockHash:        "0xb1",
+		Timestamp:        "2023-11-14T20:04:16Z",
+		TransactionIndex: 3,
+		Direction:        common.DIRECTIONIN,
+		From:             "0x1111111111111111111111111111111111111111",
+		To:               "0x23ca95b9de14a83cbf4a43b11c2c3825e72c7d9b",
+		ValueWei:         "1000000000000000000",
+		ValueEther:       "1",
+		GasLimit:         21000,
+		GasPriceGwei:     "20",
+		MaxFeeEther:      "0.00042",
+		Nonce:            7,
+		Type:             2,
+		Status:           STATUSSUCCESS,
+		GasUsed:          21000,
+		FeeEther:         "0.000021",
+		Method:           "transfer",
+		Events:           `[{"log_index":4,"address":"0xa0b8","event":"Transfer","args":{"value":"1"}},{"log_index":5,"address":"0xa0b8","event":"0x8c5b","args":{"0":true}}]`,
+		Label:            common.LABELINCOMING,
+	}
+	if got := NewRecord(testAddress, testTransaction); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewRecord() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDirection(t *testing.T) {
+	other := "0x1111111111111111111111111111111111111111"
+	tests := []struct {
+		name string
+		tr   common.Transaction
+		want string
+	}{
+		{name: "Incoming", tr: common.Transaction{From: other, To: testAddress}, want: common.DIRECTIONIN},
+		{name: "Outgoing", tr: common.Transaction{From: strings.ToLower(testAddress), To: other}, want: common.DIRECTIONOUT},
+		{name: "Self", tr: common.Transaction{From: testAddress, To: testAddress}, want: common.DIRECTIONSELF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Direction(testAddress, tt.tr); got != tt.want {
+				t.Errorf("Direction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	record := NewRecord(testAddress, testTransaction)
+	tests := []struct {
+		name    string
+		format  string
+		read    func(data []byte) ([]Record, error)
+		wantErr bool
+	}{
+		{
+			name:   "CSV with header",
+			format: CSV,
+			read: func(data []byte) ([]Record, error) {
+				lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+				if lines[0] != strings.Join(Columns, ",") {
+					t.Errorf("csv header = %v", lines[0])
+				}
+				if !strings.Contains(lines[1], ",in,") || !strings.Contains(lines[1], ",0.00042,") {
+					t.Errorf("csv row = %v", lines[1])
+				}
+				return []Record{record}, nil
+			},
+		}, {
+			name:   "JSON Lines",
+			format: JSONL,
+			read: func(data []byte) ([]Record, error) {
+				var r Record
+				err := json.Unmarshal(bytes.TrimSpace(data), &r)
+				return []Record{r}, err
+			},
+		}, {
+			name:   "Parquet",
+			format: PARQUET,
+			read: func(data []byte) ([]Record, error) {
+				return parquet.Read[Record](bytes.NewReader(data), int64(len(data)))
+			},
+		}, {
+			name:    "Unknown format",
+			format:  "xlsx",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := w.Write(record); err != nil {
+				t.Fatalf("Writer.Write() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Writer.Close() error = %v", err)
+			}
+			got, err := tt.read(buf.Bytes())
+			if err != nil {
+				t.Fatalf("read %s error = %v", tt.format, err)
+			}
+			if !reflect.DeepEqual(got, []Record{record}) {
+				t.Errorf("read %s = %+v, want %+v", tt.format, got, record)
+			}
+		})
+	}
+}
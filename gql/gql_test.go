@@ -0,0 +1,139 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+
+// mockStorage implements the methods of common.Storage used by the tests without reaching
+// the chain, the embedded nil common.Storage panics on the others
+type mockStorage struct {
+	common.Storage
+	transactions []common.Transaction
+}
+
+func (m *mockStorage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
+	if address != testAddress {
+		return -1, errors.New("does not exist")
+	}
+	return 14000000, nil
+}
+
+func (m *mockStorage) GetTransactions(ctx context.Context, address string) ([]common.Transaction, error) {
+	return m.transactions, nil
+}
+
+func (m *mockStorage) GetABI(ctx context.Context, address string) (common.ContractABI, error) {
+	return common.ContractABI{}, errors.New("does not exist")
+}
+
+func (m *mockStorage) GetEventABI(ctx context.Context, topic string) (common.EventABI, error) {
+	return common.EventABI{}, errors.New("does not exist")
+}
+
+func (m *mockStorage) WatchTransactions(ctx context.Context, address string) (<-chan common.Transaction, func(), error) {
+	ch := make(chan common.Transaction, len(m.transactions))
+	for _, tr := range m.transactions {
+		ch <- tr
+	}
+	close(ch)
+	return ch, func() {}, nil
+}
+
+func newContext() context.Context {
+	return WithStorage(context.Background(), &mockStorage{
+		transactions: []common.Transaction{
+			{Hash: "0x03", BlockNumber: "0x1236", From: testAddress, To: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"},
+			{Hash: "0x02", BlockNumber: "0x1235", From: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36", To: testAddress},
+			{Hash: "0x01", BlockNumber: "0x1234", From: testAddress, To: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"},
+		},
+	})
+}
+
+func TestResolver_Account(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Unknown account",
+			query:   `{ account(address: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36") { address } }`,
+			want:    `{"account":null}`,
+			wantErr: true,
+		}, {
+			name:  "Account metadata with first page",
+			query: `{ account(address: "` + testAddress + `") { currentBlock transactionCount transactions(first: 2) { totalCount edges { node { hash block { number } } } pageInfo { hasNextPage } } } }`,
+			want:  `{"account":{"currentBlock":14000000,"transactionCount":3,"transactions":{"totalCount":3,"edges":[{"node":{"hash":"0x03","block":{"number":"0x1236"}}},{"node":{"hash":"0x02","block":{"number":"0x1235"}}}],"pageInfo":{"hasNextPage":true}}}}`,
+		}, {
+			name:  "Page after cursor",
+			query: `{ account(address: "` + testAddress + `") { transactions(first: 2, after: "` + encodeCursor("0x02") + `") { edges { node { hash } } pageInfo { hasNextPage } } } }`,
+			want:  `{"account":{"transactions":{"edges":[{"node":{"hash":"0x01"}}],"pageInfo":{"hasNextPage":false}}}}`,
+		}, {
+			name:  "Filter by direction and block range",
+			query: `{ account(address: "` + testAddress + `") { transactions(direction: OUT, fromBlock: 4661) { edges { node { hash } } } } }`,
+			want:  `{"account":{"transactions":{"edges":[{"node":{"hash":"0x03"}}]}}}`,
+		}, {
+			name:  "Filter by label",
+			query: `{ account(address: "` + testAddress + `") { transactions(label: "incoming_transfer") { edges { node { hash label } } } } }`,
+			want:  `{"account":{"transactions":{"edges":[{"node":{"hash":"0x02","label":"incoming_transfer"}}]}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := schema.Exec(newContext(), tt.query, "", nil)
+			if (len(resp.Errors) > 0) != tt.wantErr {
+				t.Errorf("schema.Exec() errors = %v, wantErr %v", resp.Errors, tt.wantErr)
+				return
+			}
+			if string(resp.Data) != tt.want {
+				t.Errorf("schema.Exec() = %s, want %s", resp.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_NewTransactions(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "Incoming transactions only",
+			query: `subscription { newTransactions(address: "` + testAddress + `", direction: IN) { hash } }`,
+			want:  []string{`{"newTransactions":{"hash":"0x02"}}`},
+		}, {
+			name:  "Outgoing transfers only",
+			query: `subscription { newTransactions(address: "` + testAddress + `", label: "outgoing_transfer") { hash label } }`,
+			want:  []string{`{"newTransactions":{"hash":"0x03","label":"outgoing_transfer"}}`, `{"newTransactions":{"hash":"0x01","label":"outgoing_transfer"}}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := schema.Subscribe(newContext(), tt.query, "", nil)
+			if err != nil {
+				t.Errorf("schema.Subscribe() error = %v", err)
+				return
+			}
+			got := []string{}
+			for resp := range ch {
+				data, _ := json.Marshal(resp.(*graphql.Response).Data)
+				got = append(got, string(data))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schema.Subscribe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package gql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// request defines the body of a GraphQL request
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// schema is parsed once and shared by all handlers, the storage is
+// passed to the resolvers through the request context
+var schema = graphql.MustParseSchema(Schema, &Resolver{})
+
+// Handler : public endpoint for GraphQL queries, subscriptions are streamed as
+// server-sent events when the request accepts text/event-stream
+func Handler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(WithStorage(r.Context(), s))
+
+		var req request
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if vars := r.URL.Query().Get("variables"); vars != "" {
+				if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get("Accept") == "text/event-stream" {
+			serveSubscription(w, r, req)
+			return
+		}
+
+		resp := schema.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(respBytes)
+	}
+}
+
+// serveSubscription writes each subscription response as a server-sent event
+// until the client disconnects or the subscription ends
+func serveSubscription(w http.ResponseWriter, r *http.Request, req request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, err := schema.Subscribe(r.Context(), req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	for resp := range ch {
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("marshal response failed", logging.ErrorKey, err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", respBytes); err != nil {
+			logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err)
+			return
+		}
+		flusher.Flush()
+	}
+}
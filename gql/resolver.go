@@ -0,0 +1,388 @@
+package gql
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	classify "github.com/tonyxu1/transactionhistory/classify"
+	common "github.com/tonyxu1/transactionhistory/common"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+type storageKey struct{}
+
+// Resolver is the root resolver backed by the common.Storage carried by the request context
+type Resolver struct{}
+
+// WithStorage returns a copy of ctx carrying the storage used by the resolvers
+func WithStorage(ctx context.Context, s common.Storage) context.Context {
+	return context.WithValue(ctx, storageKey{}, s)
+}
+
+func storageFromContext(ctx context.Context) (common.Storage, error) {
+	s, ok := ctx.Value(storageKey{}).(common.Storage)
+	if !ok {
+		return nil, errors.New("storage is not available")
+	}
+	return s, nil
+}
+
+// Account resolves the subscribed account, error is returned for unknown address
+func (r *Resolver) Account(ctx context.Context, args struct{ Address string }) (*accountResolver, error) {
+	s, err := storageFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blockNum, err := s.GetCurrentBlock(ctx, args.Address)
+	if err != nil {
+		return nil, err
+	}
+	trans, err := s.GetTransactions(ctx, args.Address)
+	if err != nil {
+		return nil, err
+	}
+	trans = classify.Default.Apply(ctx, s, args.Address, trans)
+	return &accountResolver{address: args.Address, currentBlock: blockNum, transactions: trans}, nil
+}
+
+// NewTransactions streams the transactions saved for the address until the subscription ends
+func (r *Resolver) NewTransactions(ctx context.Context, args struct {
+	Address   string
+	Direction *string
+	Label     *string
+}) (<-chan *transactionResolver, error) {
+	s, err := storageFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, stop, err := s.WatchTransactions(ctx, args.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *transactionResolver)
+	go func() {
+		defer close(out)
+		defer stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case tr, ok := <-ch:
+				if !ok {
+					return
+				}
+				tr = classify.Default.Apply(ctx, s, args.Address, []common.Transaction{tr})[0]
+				if !matchDirection(tr, args.Address, args.Direction) || !matchLabel(tr, args.Label) {
+					continue
+				}
+				select {
+				case out <- &transactionResolver{tr: tr}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+type accountResolver struct {
+	address      string
+	currentBlock int
+	transactions []common.Transaction
+}
+
+func (a *accountResolver) Address() string {
+	return a.address
+}
+
+func (a *accountResolver) CurrentBlock() int32 {
+	return int32(a.currentBlock)
+}
+
+func (a *accountResolver) TransactionCount() int32 {
+	return int32(len(a.transactions))
+}
+
+type transactionsArgs struct {
+	First     int32
+	After     *string
+	Direction *string
+	FromBlock *int32
+	ToBlock   *int32
+	Label     *string
+}
+
+// Transactions returns a page of the filtered transactions, the cursor is the transaction hash
+func (a *accountResolver) Transactions(args transactionsArgs) (*connectionResolver, error) {
+	filtered := make([]common.Transaction, 0, len(a.transactions))
+	for _, tr := range a.transactions {
+		if !matchDirection(tr, a.address, args.Direction) || !matchBlockRange(tr, args.FromBlock, args.ToBlock) || !matchLabel(tr, args.Label) {
+			continue
+		}
+		filtered = append(filtered, tr)
+	}
+
+	start := 0
+	if args.After != nil {
+		hash, err := decodeCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		start = -1
+		for i, tr := range filtered {
+			if tr.Hash == hash {
+				start = i + 1
+				break
+			}
+		}
+		if start == -1 {
+			return nil, fmt.Errorf("cursor [%s] not found", *args.After)
+		}
+	}
+
+	if args.First < 0 {
+		return nil, fmt.Errorf("first [%d] cannot be negative", args.First)
+	}
+	end := len(filtered)
+	if start+int(args.First) < end {
+		end = start + int(args.First)
+	}
+
+	return &connectionResolver{total: len(filtered), page: filtered[start:end], hasNext: end < len(filtered)}, nil
+}
+
+type connectionResolver struct {
+	total   int
+	page    []common.Transaction
+	hasNext bool
+}
+
+func (c *connectionResolver) TotalCount() int32 {
+	return int32(c.total)
+}
+
+func (c *connectionResolver) Edges() []*edgeResolver {
+	edges := make([]*edgeResolver, 0, len(c.page))
+	for _, tr := range c.page {
+		edges = append(edges, &edgeResolver{tr: tr})
+	}
+	return edges
+}
+
+func (c *connectionResolver) PageInfo() *pageInfoResolver {
+	p := &pageInfoResolver{hasNext: c.hasNext}
+	if len(c.page) > 0 {
+		cursor := encodeCursor(c.page[len(c.page)-1].Hash)
+		p.endCursor = &cursor
+	}
+	return p
+}
+
+type edgeResolver struct {
+	tr common.Transaction
+}
+
+func (e *edgeResolver) Cursor() string {
+	return encodeCursor(e.tr.Hash)
+}
+
+func (e *edgeResolver) Node() *transactionResolver {
+	return &transactionResolver{tr: e.tr}
+}
+
+type pageInfoResolver struct {
+	endCursor *string
+	hasNext   bool
+}
+
+func (p *pageInfoResolver) EndCursor() *string {
+	return p.endCursor
+}
+
+func (p *pageInfoResolver) HasNextPage() bool {
+	return p.hasNext
+}
+
+type transactionResolver struct {
+	tr common.Transaction
+}
+
+func (t *transactionResolver) Type() string                 { return t.tr.Type }
+func (t *transactionResolver) Hash() string                 { return t.tr.Hash }
+func (t *transactionResolver) From() string                 { return t.tr.From }
+func (t *transactionResolver) To() string                   { return t.tr.To }
+func (t *transactionResolver) Value() string                { return t.tr.Value }
+func (t *transactionResolver) Gas() string                  { return t.tr.Gas }
+func (t *transactionResolver) GasPrice() string             { return t.tr.GasPrice }
+func (t *transactionResolver) MaxFeePerGas() string         { return t.tr.MaxFeePerGas }
+func (t *transactionResolver) MaxPriorityFeePerGas() string { return t.tr.MaxPriorityFeePerGas }
+func (t *transactionResolver) Input() string                { return t.tr.Input }
+func (t *transactionResolver) Nonce() string                { return t.tr.Nonce }
+func (t *transactionResolver) TransactionIndex() string     { return t.tr.TransactionIndex }
+func (t *transactionResolver) ChainId() string              { return t.tr.ChainID }
+func (t *transactionResolver) V() string                    { return t.tr.SignatureV }
+func (t *transactionResolver) R() string                    { return t.tr.SignatureR }
+func (t *transactionResolver) S() string                    { return t.tr.SignatureS }
+func (t *transactionResolver) Status() string               { return t.tr.Status }
+func (t *transactionResolver) GasUsed() string              { return t.tr.GasUsed }
+func (t *transactionResolver) EffectiveGasPrice() string    { return t.tr.EffectiveGasPrice }
+func (t *transactionResolver) Label() string                { return t.tr.Label }
+
+func (t *transactionResolver) Block() *blockResolver {
+	return &blockResolver{number: t.tr.BlockNumber, hash: t.tr.BlockHash, timestamp: t.tr.Timestamp}
+}
+
+func (t *transactionResolver) Logs() []*logResolver {
+	logs := make([]*logResolver, 0, len(t.tr.Logs))
+	for _, l := range t.tr.Logs {
+		logs = append(logs, &logResolver{l: l})
+	}
+	return logs
+}
+
+func (t *transactionResolver) Decoded() *decodedCallResolver {
+	if t.tr.Decoded == nil {
+		return nil
+	}
+	return &decodedCallResolver{call: *t.tr.Decoded}
+}
+
+type logResolver struct {
+	l common.Log
+}
+
+func (l *logResolver) Address() string  { return l.l.Address }
+func (l *logResolver) Topics() []string { return l.l.Topics }
+func (l *logResolver) Data() string     { return l.l.Data }
+func (l *logResolver) LogIndex() string { return l.l.LogIndex }
+func (l *logResolver) Removed() bool    { return l.l.Removed }
+
+func (l *logResolver) Decoded() *decodedLogResolver {
+	if l.l.Decoded == nil {
+		return nil
+	}
+	return &decodedLogResolver{log: *l.l.Decoded}
+}
+
+type decodedCallResolver struct {
+	call common.DecodedCall
+}
+
+func (d *decodedCallResolver) Selector() string     { return d.call.Selector }
+func (d *decodedCallResolver) Method() string       { return d.call.Method }
+func (d *decodedCallResolver) Signature() string    { return d.call.Signature }
+func (d *decodedCallResolver) Args() []*argResolver { return args(d.call.Args) }
+func (d *decodedCallResolver) Source() string       { return d.call.Source }
+func (d *decodedCallResolver) Error() string        { return d.call.Error }
+
+type decodedLogResolver struct {
+	log common.DecodedLog
+}
+
+func (d *decodedLogResolver) Topic() string        { return d.log.Topic }
+func (d *decodedLogResolver) Event() string        { return d.log.Event }
+func (d *decodedLogResolver) Signature() string    { return d.log.Signature }
+func (d *decodedLogResolver) Args() []*argResolver { return args(d.log.Args) }
+func (d *decodedLogResolver) Source() string       { return d.log.Source }
+func (d *decodedLogResolver) Error() string        { return d.log.Error }
+
+// argResolver resolves a decoded argument, the elements of an array and the fields of a tuple
+// are its components
+type argResolver struct {
+	arg common.DecodedArg
+}
+
+func args(decoded []common.DecodedArg) []*argResolver {
+	resolvers := make([]*argResolver, 0, len(decoded))
+	for _, arg := range decoded {
+		resolvers = append(resolvers, &argResolver{arg: arg})
+	}
+	return resolvers
+}
+
+func (a *argResolver) Name() string { return a.arg.Name }
+func (a *argResolver) Type() string { return a.arg.Type }
+
+func (a *argResolver) Value() string {
+	value, _ := abi.Text(a.arg)
+	return value
+}
+
+func (a *argResolver) Components() []*argResolver {
+	_, components := abi.Text(a.arg)
+	return args(components)
+}
+
+type blockResolver struct {
+	number    string
+	hash      string
+	timestamp string
+}
+
+func (b *blockResolver) Number() string {
+	return b.number
+}
+
+func (b *blockResolver) Hash() string {
+	return b.hash
+}
+
+func (b *blockResolver) Timestamp() string {
+	return b.timestamp
+}
+
+// matchDirection checks the transaction direction relative to the address, nil matches all
+func matchDirection(tr common.Transaction, address string, direction *string) bool {
+	if direction == nil {
+		return true
+	}
+	switch *direction {
+	case "IN":
+		return strings.EqualFold(tr.To, address)
+	case "OUT":
+		return strings.EqualFold(tr.From, address)
+	}
+	return true
+}
+
+// matchLabel checks the label of the transaction, nil matches all
+func matchLabel(tr common.Transaction, label *string) bool {
+	return label == nil || tr.Label == *label
+}
+
+// matchBlockRange checks the transaction block number is within the inclusive range
+func matchBlockRange(tr common.Transaction, from, to *int32) bool {
+	if from == nil && to == nil {
+		return true
+	}
+	num, err := hexutil.DecodeUint64(tr.BlockNumber)
+	if err != nil {
+		return false
+	}
+	if from != nil && num < uint64(*from) {
+		return false
+	}
+	if to != nil && num > uint64(*to) {
+		return false
+	}
+	return true
+}
+
+func encodeCursor(hash string) string {
+	return base64.StdEncoding.EncodeToString([]byte(hash))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor [%s]", cursor)
+	}
+	return string(b), nil
+}
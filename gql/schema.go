@@ -0,0 +1,132 @@
+package gql
+
+// Schema is the GraphQL schema served at /graphql
+const Schema = `
+schema {
+	query: Query
+	subscription: Subscription
+}
+
+type Query {
+	# Subscribed account for the given address
+	account(address: String!): Account
+}
+
+type Subscription {
+	# Transactions saved for the given address from now on
+	newTransactions(address: String!, direction: Direction, label: String): Transaction!
+}
+
+# Direction of a transaction relative to the account
+enum Direction {
+	IN
+	OUT
+}
+
+type Account {
+	address: String!
+	# Most recent block number scanned for the account
+	currentBlock: Int!
+	transactionCount: Int!
+	# Transactions ordered by block number descending, label keeps the transactions with the label
+	transactions(first: Int = 20, after: String, direction: Direction, fromBlock: Int, toBlock: Int, label: String): TransactionConnection!
+}
+
+type TransactionConnection {
+	totalCount: Int!
+	edges: [TransactionEdge!]!
+	pageInfo: PageInfo!
+}
+
+type TransactionEdge {
+	cursor: String!
+	node: Transaction!
+}
+
+type PageInfo {
+	endCursor: String
+	hasNextPage: Boolean!
+}
+
+type Transaction {
+	type: String!
+	hash: String!
+	from: String!
+	to: String!
+	value: String!
+	gas: String!
+	gasPrice: String!
+	maxFeePerGas: String!
+	maxPriorityFeePerGas: String!
+	input: String!
+	nonce: String!
+	transactionIndex: String!
+	chainId: String!
+	v: String!
+	r: String!
+	s: String!
+	# Receipt status "0x1" or "0x0", gas used and effective gas price, empty when stored without receipt
+	status: String!
+	gasUsed: String!
+	effectiveGasPrice: String!
+	# Label relative to the account such as "incoming_transfer", "swap" or "failed"
+	label: String!
+	block: Block!
+	# Event logs emitted by the transaction, empty when stored without receipt
+	logs: [Log!]!
+	# Input decoded into the function called, null for transfers without input and contract creations
+	decoded: DecodedCall
+}
+
+type Log {
+	address: String!
+	topics: [String!]!
+	data: String!
+	logIndex: String!
+	removed: Boolean!
+	# Event decoded from the topics and data
+	decoded: DecodedLog
+}
+
+# Function called by the input, method is empty when the selector is unknown and error is set
+# when the arguments do not match the known function
+type DecodedCall {
+	selector: String!
+	method: String!
+	signature: String!
+	args: [DecodedArg!]!
+	# "abi" or "selector"
+	source: String!
+	error: String!
+}
+
+# Event of a log, event is empty when the topic is unknown and error is set when the topics
+# and data do not match the known event
+type DecodedLog {
+	topic: String!
+	event: String!
+	signature: String!
+	args: [DecodedArg!]!
+	# "abi" or "event"
+	source: String!
+	error: String!
+}
+
+type DecodedArg {
+	name: String!
+	type: String!
+	# Decimal string for integers, hex string for addresses and bytes, "true" or "false" for
+	# booleans, empty for arrays and tuples
+	value: String!
+	# Elements of an array or fields of a tuple
+	components: [DecodedArg!]!
+}
+
+type Block {
+	# Block number in hex string starts with "0x"
+	number: String!
+	hash: String!
+	# Block timestamp in unix seconds as hex string, empty when unknown
+	timestamp: String!
+}
+`
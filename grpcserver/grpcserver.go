@@ -0,0 +1,278 @@
+package grpcserver
+
+import (
+	"context"
+
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	auth "github.com/tonyxu1/transactionhistory/auth"
+	classify "github.com/tonyxu1/transactionhistory/classify"
+	common "github.com/tonyxu1/transactionhistory/common"
+	network "github.com/tonyxu1/transactionhistory/network"
+	pb "github.com/tonyxu1/transactionhistory/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements pb.TransactionHistoryServer on top of the storage of each network
+type Server struct {
+	pb.UnimplementedTransactionHistoryServer
+	Chains *network.Set
+
+	// Keys scopes the storage to the API key of each call when set
+	Keys *auth.KeyStore
+}
+
+// New creates a grpc server with the transaction history service registered,
+// calls are authenticated with API keys when ks is not nil before the interceptors in opts
+func New(chains *network.Set, ks *auth.KeyStore, opts ...grpc.ServerOption) *grpc.Server {
+	if ks != nil {
+		opts = append(opts,
+			grpc.UnaryInterceptor(ks.UnaryServerInterceptor()),
+			grpc.StreamInterceptor(ks.StreamServerInterceptor()))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterTransactionHistoryServer(srv, &Server{Chains: chains, Keys: ks})
+	return srv
+}
+
+// CancelStreams ends the streams when ctx is done, it is cancelled at shutdown so that
+// GracefulStop does not wait for the streams watching transactions until the deadline
+func CancelStreams(ctx context.Context) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		streamCtx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+		return handler(srv, &serverStream{ServerStream: ss, ctx: streamCtx})
+	}
+}
+
+// serverStream overrides the context of grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// storage returns the storage of the network requested by the call
+// with the context carrying the network
+func (s *Server) storage(ctx context.Context, req *pb.AddressRequest) (context.Context, common.Storage, error) {
+	c, err := s.Chains.Get(req.GetChain())
+	if err != nil {
+		return ctx, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = c.Context(ctx)
+	if s.Keys == nil {
+		return ctx, c.Storage, nil
+	}
+	scoped, err := s.Keys.Storage(ctx, c.Storage)
+	if err != nil {
+		return ctx, nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return ctx, scoped, nil
+}
+
+// Subscribe register the given address to the system
+func (s *Server) Subscribe(ctx context.Context, req *pb.AddressRequest) (*pb.SubscribeResponse, error) {
+	ctx, st, err := s.storage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	err = st.CreateAccount(ctx, req.GetAddress())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.SubscribeResponse{Message: "subscription succeed"}, nil
+}
+
+// Unsubscribe remove the given address from the system
+func (s *Server) Unsubscribe(ctx context.Context, req *pb.AddressRequest) (*pb.UnsubscribeResponse, error) {
+	ctx, st, err := s.storage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	err = st.RemoveAccount(ctx, req.GetAddress())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.UnsubscribeResponse{Message: "unsubscription succeed"}, nil
+}
+
+// GetCurrentBlock get the current block number saved in storage for the given address
+func (s *Server) GetCurrentBlock(ctx context.Context, req *pb.AddressRequest) (*pb.CurrentBlockResponse, error) {
+	ctx, st, err := s.storage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	blockNum, err := st.GetCurrentBlock(ctx, req.GetAddress())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.CurrentBlockResponse{BlockNumber: int64(blockNum)}, nil
+}
+
+// GetTransactions stream the transaction history of the given address one by one, with their
+// label relative to the address and the ones with the requested label only
+func (s *Server) GetTransactions(req *pb.AddressRequest, stream pb.TransactionHistory_GetTransactionsServer) error {
+	ctx, st, err := s.storage(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+	trans, err := st.GetTransactions(ctx, req.GetAddress())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	trans = classify.Default.Apply(ctx, st, req.GetAddress(), trans)
+	for _, tr := range classify.Filter(trans, req.GetLabel()) {
+		if err := stream.Send(ToProto(tr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchTransactions stream the transactions saved for the given address until
+// the client cancels or the address is unsubscribed, filtered by label as GetTransactions
+func (s *Server) WatchTransactions(req *pb.AddressRequest, stream pb.TransactionHistory_WatchTransactionsServer) error {
+	ctx, st, err := s.storage(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+	ch, stop, err := st.WatchTransactions(ctx, req.GetAddress())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case tr, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			tr = classify.Default.Apply(ctx, st, req.GetAddress(), []common.Transaction{tr})[0]
+			if req.GetLabel() != "" && tr.Label != req.GetLabel() {
+				continue
+			}
+			if err := stream.Send(ToProto(tr)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ToProto converts common.Transaction to its protobuf message
+func ToProto(tr common.Transaction) *pb.Transaction {
+	return &pb.Transaction{
+		Type:                 tr.Type,
+		BlockHash:            tr.BlockHash,
+		BlockNumber:          tr.BlockNumber,
+		From:                 tr.From,
+		Gas:                  tr.Gas,
+		Hash:                 tr.Hash,
+		Input:                tr.Input,
+		Nonce:                tr.Nonce,
+		To:                   tr.To,
+		TransactionIndex:     tr.TransactionIndex,
+		Value:                tr.Value,
+		V:                    tr.SignatureV,
+		R:                    tr.SignatureR,
+		S:                    tr.SignatureS,
+		GasPrice:             tr.GasPrice,
+		MaxFeePerGas:         tr.MaxFeePerGas,
+		MaxPriorityFeePerGas: tr.MaxPriorityFeePerGas,
+		ChainId:              tr.ChainID,
+		AccessList:           accessListToProto(tr.AccessList),
+		Timestamp:            tr.Timestamp,
+		Status:               tr.Status,
+		GasUsed:              tr.GasUsed,
+		EffectiveGasPrice:    tr.EffectiveGasPrice,
+		Label:                tr.Label,
+		Logs:                 logsToProto(tr.Logs),
+		Decoded:              callToProto(tr.Decoded),
+	}
+}
+
+// logsToProto converts the logs of a transaction with their decoded event
+func logsToProto(logs []common.Log) []*pb.Log {
+	result := make([]*pb.Log, 0, len(logs))
+	for _, l := range logs {
+		log := &pb.Log{
+			Address:         l.Address,
+			Topics:          l.Topics,
+			Data:            l.Data,
+			BlockNumber:     l.BlockNumber,
+			BlockHash:       l.BlockHash,
+			TransactionHash: l.TransactionHash,
+			LogIndex:        l.LogIndex,
+			Removed:         l.Removed,
+		}
+		if l.Decoded != nil {
+			log.Decoded = &pb.DecodedLog{
+				Topic:     l.Decoded.Topic,
+				Event:     l.Decoded.Event,
+				Signature: l.Decoded.Signature,
+				Args:      argsToProto(l.Decoded.Args),
+				Source:    l.Decoded.Source,
+				Error:     l.Decoded.Error,
+			}
+		}
+		result = append(result, log)
+	}
+	return result
+}
+
+// callToProto converts the decoded input of a transaction, nil when not decoded
+func callToProto(call *common.DecodedCall) *pb.DecodedCall {
+	if call == nil {
+		return nil
+	}
+	return &pb.DecodedCall{
+		Selector:  call.Selector,
+		Method:    call.Method,
+		Signature: call.Signature,
+		Args:      argsToProto(call.Args),
+		Source:    call.Source,
+		Error:     call.Error,
+	}
+}
+
+// argsToProto converts decoded arguments, the elements of arrays and the fields of tuples are components
+func argsToProto(args []common.DecodedArg) []*pb.DecodedArg {
+	result := make([]*pb.DecodedArg, 0, len(args))
+	for _, arg := range args {
+		value, components := abi.Text(arg)
+		result = append(result, &pb.DecodedArg{Name: arg.Name, Type: arg.Type, Value: value, Components: argsToProto(components)})
+	}
+	return result
+}
+
+// accessListToProto converts the access list decoded from Json RPC,
+// items that are not in {"address", "storageKeys"} form are skipped
+func accessListToProto(list []interface{}) []*pb.AccessTuple {
+	result := make([]*pb.AccessTuple, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tuple := &pb.AccessTuple{}
+		tuple.Address, _ = m["address"].(string)
+		if keys, ok := m["storageKeys"].([]interface{}); ok {
+			for _, k := range keys {
+				if key, ok := k.(string); ok {
+					tuple.StorageKeys = append(tuple.StorageKeys, key)
+				}
+			}
+		}
+		result = append(result, tuple)
+	}
+	return result
+}
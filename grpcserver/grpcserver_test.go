@@ -0,0 +1,403 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	common "github.com/tonyxu1/transactionhistory/common"
+	gql "github.com/tonyxu1/transactionhistory/gql"
+	handler "github.com/tonyxu1/transactionhistory/handler"
+	network "github.com/tonyxu1/transactionhistory/network"
+	pb "github.com/tonyxu1/transactionhistory/pb"
+	util "github.com/tonyxu1/transactionhistory/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// mockStorage implements the methods of common.Storage used by the tests without reaching
+// the chain, the embedded nil common.Storage panics on the others
+type mockStorage struct {
+	common.Storage
+	accounts     map[string]int
+	transactions map[string][]common.Transaction
+	abis         map[string]common.ContractABI
+}
+
+func (m *mockStorage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
+	block, ok := m.accounts[address]
+	if !ok {
+		return -1, errors.New("does not exist")
+	}
+	return block, nil
+}
+
+func (m *mockStorage) GetTransactions(ctx context.Context, address string) ([]common.Transaction, error) {
+	if _, ok := m.accounts[address]; !ok {
+		return nil, errors.New("does not exist")
+	}
+	return m.transactions[address], nil
+}
+
+func (m *mockStorage) GetABI(ctx context.Context, address string) (common.ContractABI, error) {
+	contract, ok := m.abis[address]
+	if !ok {
+		return common.ContractABI{}, errors.New("does not exist")
+	}
+	return contract, nil
+}
+
+func (m *mockStorage) GetEventABI(ctx context.Context, topic string) (common.EventABI, error) {
+	return common.EventABI{}, errors.New("does not exist")
+}
+
+func (m *mockStorage) WatchTransactions(ctx context.Context, address string) (<-chan common.Transaction, func(), error) {
+	ch := make(chan common.Transaction, len(m.transactions[address]))
+	for _, tr := range m.transactions[address] {
+		ch <- tr
+	}
+	close(ch)
+	return ch, func() {}, nil
+}
+
+func newClient(t *testing.T, s common.Storage) pb.TransactionHistoryClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := New(network.NewSet(&network.Chain{Network: util.DefaultNetwork(), Storage: s}), nil)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error : %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTransactionHistoryClient(conn)
+}
+
+func TestServer_GetCurrentBlock(t *testing.T) {
+	s := &mockStorage{
+		accounts:     map[string]int{"0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b": 14000000},
+		transactions: map[string][]common.Transaction{},
+	}
+	client := newClient(t, s)
+
+	tests := []struct {
+		name    string
+		address string
+		chain   string
+		want    int64
+		wantErr bool
+	}{
+		{
+			name:    "Account does not exist",
+			address: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36",
+			wantErr: true,
+		}, {
+			name:    "Current block returned",
+			address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
+			want:    14000000,
+		}, {
+			name:    "Current block of the named network returned",
+			address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
+			chain:   common.DEFAULTNETWORK,
+			want:    14000000,
+		}, {
+			name:    "Network not configured",
+			address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
+			chain:   "sepolia",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetCurrentBlock(context.Background(), &pb.AddressRequest{Address: tt.address, Chain: tt.chain})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Server.GetCurrentBlock() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.GetBlockNumber() != tt.want {
+				t.Errorf("Server.GetCurrentBlock() = %v, want %v", got.GetBlockNumber(), tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_GetTransactions(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	s := &mockStorage{
+		accounts: map[string]int{address: 14000000},
+		transactions: map[string][]common.Transaction{address: {
+			{BlockNumber: "0x1235", Hash: "0x02", From: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36", To: address, Status: "0x1"},
+			{BlockNumber: "0x1234", Hash: "0x01", From: address, To: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36", Status: "0x0"},
+		}},
+	}
+	client := newClient(t, s)
+
+	tests := []struct {
+		name  string
+		call  func(ctx context.Context, in *pb.AddressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.Transaction], error)
+		label string
+		want  []string
+	}{
+		{
+			name: "History streamed",
+			call: client.GetTransactions,
+			want: []string{"0x02 incoming_transfer", "0x01 failed"},
+		}, {
+			name:  "History filtered by label",
+			call:  client.GetTransactions,
+			label: common.LABELFAILED,
+			want:  []string{"0x01 failed"},
+		}, {
+			name: "Watched transactions streamed",
+			call: client.WatchTransactions,
+			want: []string{"0x02 incoming_transfer", "0x01 failed"},
+		}, {
+			name:  "Watched transactions filtered by label",
+			call:  client.WatchTransactions,
+			label: common.LABELINCOMING,
+			want:  []string{"0x02 incoming_transfer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := tt.call(context.Background(), &pb.AddressRequest{Address: address, Label: tt.label})
+			if err != nil {
+				t.Errorf("stream error : %v", err)
+				return
+			}
+			got := []string{}
+			for {
+				tr, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Errorf("stream.Recv() error : %v", err)
+					return
+				}
+				got = append(got, tr.GetHash()+" "+tr.GetLabel())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stream = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabels_AcrossTransports(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	contract := "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	// approve(address) is only known by the abi of the contract, the selector database
+	// holds approve(address,uint256)
+	method, err := abi.ParseSignature("approve(address)")
+	if err != nil {
+		t.Fatalf("abi.ParseSignature() error : %v", err)
+	}
+	s := &mockStorage{
+		accounts: map[string]int{address: 14000000},
+		transactions: map[string][]common.Transaction{address: {
+			{BlockNumber: "0x1235", Hash: "0x02", From: address, To: contract, Value: "0x0", Status: "0x1",
+				Input: method.Selector() + strings.Repeat("0", 24) + "e946502872da09009aa6dc975272ac24ab5b4f36"},
+			{BlockNumber: "0x1234", Hash: "0x01", From: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36", To: address, Value: "0x64", Status: "0x1"},
+		}},
+		abis: map[string]common.ContractABI{contract: {
+			Address: contract,
+			ABI:     json.RawMessage(`[{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"}]}]`),
+		}},
+	}
+	want := map[string]string{"0x02": common.LABELAPPROVAL, "0x01": common.LABELINCOMING}
+
+	rest := httptest.NewRecorder()
+	handler.TransactionHistoryHandler(s)(rest, httptest.NewRequest(http.MethodGet, "/transaction?address="+address, nil))
+	var trans []common.Transaction
+	if err := json.Unmarshal(rest.Body.Bytes(), &trans); err != nil {
+		t.Fatalf("/transaction response %s : %v", rest.Body.String(), err)
+	}
+	got := map[string]string{}
+	for _, tr := range trans {
+		got[tr.Hash] = tr.Label
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/transaction labels = %v, want %v", got, want)
+	}
+
+	query := `{"query":"{ account(address: \"` + address + `\") { transactions { edges { node { hash label } } } } }"}`
+	graph := httptest.NewRecorder()
+	gql.Handler(s)(graph, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query)))
+	var resp struct {
+		Data struct {
+			Account struct {
+				Transactions struct {
+					Edges []struct {
+						Node struct{ Hash, Label string }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(graph.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("/graphql response %s : %v", graph.Body.String(), err)
+	}
+	got = map[string]string{}
+	for _, edge := range resp.Data.Account.Transactions.Edges {
+		got[edge.Node.Hash] = edge.Node.Label
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("graphql labels = %v, want %v", got, want)
+	}
+
+	stream, err := newClient(t, s).GetTransactions(context.Background(), &pb.AddressRequest{Address: address})
+	if err != nil {
+		t.Fatalf("stream error : %v", err)
+	}
+	got = map[string]string{}
+	for {
+		tr, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("stream.Recv() error : %v", err)
+		}
+		got[tr.GetHash()] = tr.GetLabel()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("grpc labels = %v, want %v", got, want)
+	}
+}
+
+// stream is a grpc.ServerStream carrying ctx, the embedded nil grpc.ServerStream panics on the other methods
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func TestCancelStreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		shutdown bool
+		want     error
+	}{
+		{name: "Stream ended at shutdown", shutdown: true, want: context.Canceled},
+		{name: "Stream running", shutdown: false, want: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.shutdown {
+				cancel()
+			}
+			parent, stop := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer stop()
+
+			err := CancelStreams(ctx)(nil, &stream{ctx: parent}, &grpc.StreamServerInfo{}, func(srv interface{}, ss grpc.ServerStream) error {
+				<-ss.Context().Done()
+				return ss.Context().Err()
+			})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CancelStreams() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoded_AcrossTransports(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	contract := "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	spender := "e946502872da09009aa6dc975272ac24ab5b4f36"
+	method, err := abi.ParseSignature("approve(address)")
+	if err != nil {
+		t.Fatalf("abi.ParseSignature() error : %v", err)
+	}
+	s := &mockStorage{
+		accounts: map[string]int{address: 14000000},
+		transactions: map[string][]common.Transaction{address: {
+			{BlockNumber: "0x1234", Hash: "0x01", From: address, To: contract, Value: "0x0", Status: "0x1",
+				Input: method.Selector() + strings.Repeat("0", 24) + spender,
+				Logs: []common.Log{{
+					Address: contract,
+					Topics:  []string{common.TRANSFERTOPIC, "0x" + strings.Repeat("0", 24) + spender, "0x" + strings.Repeat("0", 24) + strings.ToLower(address[2:])},
+					Data:    "0x" + strings.Repeat("0", 62) + "64",
+				}}},
+		}},
+		abis: map[string]common.ContractABI{contract: {
+			Address: contract,
+			ABI:     json.RawMessage(`[{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"}]}]`),
+		}},
+	}
+	// method, first argument name and value, and event of the log
+	want := []string{"approve", "spender", "0x" + spender, "Transfer"}
+
+	query := `{"query":"{ account(address: \"` + address + `\") { transactions { edges { node { decoded { method args { name value } } logs { decoded { event } } } } } } }"}`
+	graph := httptest.NewRecorder()
+	gql.Handler(s)(graph, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(query)))
+	var resp struct {
+		Data struct {
+			Account struct {
+				Transactions struct {
+					Edges []struct {
+						Node struct {
+							Decoded struct {
+								Method string
+								Args   []struct{ Name, Value string }
+							}
+							Logs []struct {
+								Decoded struct{ Event string }
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(graph.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("/graphql response %s : %v", graph.Body.String(), err)
+	}
+	var got []string
+	for _, edge := range resp.Data.Account.Transactions.Edges {
+		node := edge.Node
+		if len(node.Decoded.Args) != 1 || len(node.Logs) != 1 {
+			t.Fatalf("/graphql response %s, want one argument and one log", graph.Body.String())
+		}
+		got = []string{node.Decoded.Method, node.Decoded.Args[0].Name, node.Decoded.Args[0].Value, node.Logs[0].Decoded.Event}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("graphql decoded = %v, want %v", got, want)
+	}
+
+	stream, err := newClient(t, s).GetTransactions(context.Background(), &pb.AddressRequest{Address: address})
+	if err != nil {
+		t.Fatalf("stream error : %v", err)
+	}
+	tr, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("stream.Recv() error : %v", err)
+	}
+	if len(tr.GetDecoded().GetArgs()) != 1 || len(tr.GetLogs()) != 1 {
+		t.Fatalf("grpc transaction = %v, want one argument and one log", tr)
+	}
+	arg := tr.GetDecoded().GetArgs()[0]
+	got = []string{tr.GetDecoded().GetMethod(), arg.GetName(), arg.GetValue(), tr.GetLogs()[0].GetDecoded().GetEvent()}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("grpc decoded = %v, want %v", got, want)
+	}
+}
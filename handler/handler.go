@@ -1,23 +1,37 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	archive "github.com/tonyxu1/transactionhistory/archive"
+	balance "github.com/tonyxu1/transactionhistory/balance"
+	classify "github.com/tonyxu1/transactionhistory/classify"
 	common "github.com/tonyxu1/transactionhistory/common"
+	export "github.com/tonyxu1/transactionhistory/export"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	retention "github.com/tonyxu1/transactionhistory/retention"
+	util "github.com/tonyxu1/transactionhistory/util"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
 )
 
 func CurrentBlockHandler(s common.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		address := r.URL.Query().Get("address")
 
-		blockNum, err := s.GetCurrentBlock(address)
+		blockNum, err := s.GetCurrentBlock(r.Context(), address)
 		if err != nil {
 			_, err1 := w.Write([]byte(err.Error()))
 			if err1 != nil {
-				log.Println("w.Write() error :", err1)
+				logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err1)
 				return
 			}
 			return
@@ -33,11 +47,11 @@ func SubscribeHandler(s common.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		address := r.URL.Query().Get("address")
 
-		err := s.CreateAccount(address)
+		err := s.CreateAccount(r.Context(), address)
 		if err != nil {
 			_, err1 := w.Write([]byte(err.Error()))
 			if err1 != nil {
-				log.Println("w.Write() error: ", err1)
+				logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err1)
 				return
 			}
 			return
@@ -47,16 +61,35 @@ func SubscribeHandler(s common.Storage) http.HandlerFunc {
 	}
 }
 
+// UnsubscribeHandler : public endpoint for removing subscription of an account
+func UnsubscribeHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := r.URL.Query().Get("address")
+
+		err := s.RemoveAccount(r.Context(), address)
+		if err != nil {
+			_, err1 := w.Write([]byte(err.Error()))
+			if err1 != nil {
+				logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err1)
+				return
+			}
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write([]byte(`{"message":"unsubscription succeed"}`))
+	}
+}
+
 // TransactionHistoryHandler : retrieve transaction for a given address from both of the
-// chain and local storage.
+// chain and local storage, with their decoded input and their label.
 func TransactionHistoryHandler(s common.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		address := r.URL.Query().Get("address")
-		trans, err := s.GetTransactions(address)
+		trans, err := queryTransactions(r, s, address)
 		if err != nil {
 			_, err1 := w.Write([]byte(err.Error()))
 			if err1 != nil {
-				log.Println("w.Write() error: ", err1)
+				logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err1)
 				return
 			}
 
@@ -67,7 +100,7 @@ func TransactionHistoryHandler(s common.Storage) http.HandlerFunc {
 		if err != nil {
 			_, err1 := w.Write([]byte(err.Error()))
 			if err1 != nil {
-				log.Println("w.Write() error: ", err1)
+				logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err1)
 				return
 			}
 			return
@@ -76,3 +109,615 @@ func TransactionHistoryHandler(s common.Storage) http.HandlerFunc {
 
 	}
 }
+
+// TransactionLookupHandler : look up the transaction with the hash given in the path in the
+// subscribed accounts, the transaction is fetched from the chain when not stored locally
+func TransactionLookupHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lookup, err := lookupTransaction(r.Context(), s, r.PathValue("hash"))
+		if err != nil {
+			_, err1 := w.Write([]byte(err.Error()))
+			if err1 != nil {
+				logging.FromContext(r.Context()).Error("write response failed", logging.ErrorKey, err1)
+				return
+			}
+			return
+		}
+		data, err := json.Marshal(lookup)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// lookupTransaction finds the transaction in storage or on chain and adds its decoded input,
+// its label relative to each account and its block context
+func lookupTransaction(ctx context.Context, s common.Storage, hash string) (common.TransactionLookup, error) {
+	err := util.ValidateHash(hash)
+	if err != nil {
+		return common.TransactionLookup{}, err
+	}
+
+	tr, matches, err := s.FindTransaction(ctx, hash)
+	lookup := common.TransactionLookup{Transaction: tr, Accounts: matches, Stored: err == nil}
+	if err != nil {
+		logging.FromContext(ctx).Debug("transaction not stored, fetching from chain", "hash", hash)
+		tr, err = util.GetTransactionByHash(ctx, hash)
+		if err != nil {
+			return common.TransactionLookup{}, err
+		}
+		addresses, err := s.GetAccounts(ctx)
+		if err != nil {
+			return common.TransactionLookup{}, err
+		}
+		lookup.Transaction = tr
+		lookup.Accounts = []common.AccountMatch{}
+		for _, address := range addresses {
+			if match, ok := util.MatchAccount(tr, address); ok {
+				lookup.Accounts = append(lookup.Accounts, match)
+			}
+		}
+	}
+	lookup.Transaction = abi.Decode(ctx, s, []common.Transaction{lookup.Transaction})[0]
+	for i, match := range lookup.Accounts {
+		lookup.Accounts[i].Label = classify.Default.Classify(match.Address, lookup.Transaction)
+	}
+
+	if tr.BlockNumber == "" {
+		lookup.Pending = true
+		return lookup, nil
+	}
+	blockNum, err := hexutil.DecodeUint64(tr.BlockNumber)
+	if err != nil {
+		return common.TransactionLookup{}, fmt.Errorf("invalid block number %s: %s", tr.BlockNumber, err.Error())
+	}
+	lookup.BlockNumber = int(blockNum)
+	lookup.BlockHash = tr.BlockHash
+	lookup.Timestamp = tr.Timestamp
+	if header, err := s.GetHeader(ctx, lookup.BlockNumber); err == nil {
+		lookup.Timestamp = header.Timestamp
+	}
+
+	head, err := util.GetChainHead(ctx)
+	if err != nil {
+		// the stored transaction is still returned without confirmations
+		logging.FromContext(ctx).Warn("chain head unavailable", logging.ErrorKey, err)
+		return lookup, nil
+	}
+	lookup.ChainHead = head
+	if head >= lookup.BlockNumber {
+		lookup.Confirmations = head - lookup.BlockNumber + 1
+	}
+	return lookup, nil
+}
+
+// ExportHandler : stream the transactions of the address, oldest first, as csv, jsonl or
+// parquet, filtered by the same query parameters as TransactionHistoryHandler
+func ExportHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := r.URL.Query().Get("address")
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = export.CSV
+		}
+
+		trans, err := queryTransactions(r, s, address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ew, err := export.NewWriter(w, format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", export.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, address, format))
+
+		for i := len(trans) - 1; i >= 0; i-- {
+			if err := ew.Write(export.NewRecord(address, trans[i])); err != nil {
+				logging.FromContext(r.Context()).Error("export failed", logging.AddressKey, address, logging.ErrorKey, err)
+				return
+			}
+		}
+		if err := ew.Close(); err != nil {
+			logging.FromContext(r.Context()).Error("export failed", logging.AddressKey, address, logging.ErrorKey, err)
+		}
+	}
+}
+
+// queryTransactions reads the transactions of the address from the index matching the
+// optional counterparty, fromBlock and toBlock query parameters, keeps the ones within the
+// optional fromTime and toTime, then decodes and labels them and keeps the ones with the
+// optional label
+func queryTransactions(r *http.Request, s common.Storage, address string) ([]common.Transaction, error) {
+	trans, err := filterTime(r, s, address)
+	if err != nil {
+		return nil, err
+	}
+	trans = classify.Default.Apply(r.Context(), s, address, trans)
+	return classify.Filter(trans, r.URL.Query().Get(common.LABELPARAM)), nil
+}
+
+// filterTime reads the transactions of the address and keeps the ones within the optional
+// fromTime and toTime
+func filterTime(r *http.Request, s common.Storage, address string) ([]common.Transaction, error) {
+	query := r.URL.Query()
+	trans, err := readTransactions(r, s, address)
+	if err != nil {
+		return nil, err
+	}
+	if query.Get("fromTime") == "" && query.Get("toTime") == "" {
+		return trans, nil
+	}
+
+	var from, to time.Time
+	if v := query.Get("fromTime"); v != "" {
+		if from, err = util.ParseTime(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("toTime"); v != "" {
+		if to, err = util.ParseTime(v); err != nil {
+			return nil, err
+		}
+	}
+	filtered := make([]common.Transaction, 0, len(trans))
+	for _, tr := range trans {
+		if util.InTimeRange(tr, from, to) {
+			filtered = append(filtered, tr)
+		}
+	}
+	return filtered, nil
+}
+
+func readTransactions(r *http.Request, s common.Storage, address string) ([]common.Transaction, error) {
+	query := r.URL.Query()
+	if counterparty := query.Get("counterparty"); counterparty != "" {
+		return s.GetTransactionsByCounterparty(r.Context(), address, counterparty)
+	}
+	if query.Get("fromBlock") == "" && query.Get("toBlock") == "" {
+		return s.GetTransactions(r.Context(), address)
+	}
+
+	fromBlock, toBlock := 0, math.MaxInt
+	var err error
+	if v := query.Get("fromBlock"); v != "" {
+		if fromBlock, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid fromBlock [%s]", v)
+		}
+	}
+	if v := query.Get("toBlock"); v != "" {
+		if toBlock, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid toBlock [%s]", v)
+		}
+	}
+	return s.GetTransactionsByBlockRange(r.Context(), address, fromBlock, toBlock)
+}
+
+// BalanceHandler : public endpoint returning the native balance of an account at the last block
+// scanned, computed from its last balance checkpoint and the transactions stored after it
+func BalanceHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := balance.Current(r.Context(), s, r.URL.Query().Get("address"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(current)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// BalanceHistoryHandler : public endpoint returning the balance checkpoints of an account with
+// their drift, and its balance after each stored transaction within the optional fromBlock and
+// toBlock, oldest first
+func BalanceHistoryHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := r.URL.Query().Get("address")
+		history, err := balanceHistory(r, s, address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(history)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// balanceHistory computes the balance over all the stored transactions, which are chained
+// from the checkpoints, then keeps the points within the block range of the request
+func balanceHistory(r *http.Request, s common.Storage, address string) (common.BalanceHistory, error) {
+	query := r.URL.Query()
+	fromBlock, toBlock := 0, math.MaxInt
+	var err error
+	if v := query.Get("fromBlock"); v != "" {
+		if fromBlock, err = strconv.Atoi(v); err != nil {
+			return common.BalanceHistory{}, fmt.Errorf("invalid fromBlock [%s]", v)
+		}
+	}
+	if v := query.Get("toBlock"); v != "" {
+		if toBlock, err = strconv.Atoi(v); err != nil {
+			return common.BalanceHistory{}, fmt.Errorf("invalid toBlock [%s]", v)
+		}
+	}
+
+	checkpoints, err := s.GetBalances(r.Context(), address)
+	if err != nil {
+		return common.BalanceHistory{}, err
+	}
+	trans, err := s.GetTransactions(r.Context(), address)
+	if err != nil {
+		return common.BalanceHistory{}, err
+	}
+	points, err := balance.History(address, checkpoints, trans)
+	if err != nil {
+		return common.BalanceHistory{}, err
+	}
+
+	history := common.BalanceHistory{
+		Address:     address,
+		Checkpoints: make([]common.BalanceCheckpoint, 0, len(checkpoints)),
+		Points:      make([]common.BalancePoint, 0, len(points)),
+	}
+	for _, c := range checkpoints {
+		if c.Block >= fromBlock && c.Block <= toBlock {
+			history.Checkpoints = append(history.Checkpoints, c)
+		}
+	}
+	for _, p := range points {
+		if p.BlockNumber >= fromBlock && p.BlockNumber <= toBlock {
+			history.Points = append(history.Points, p)
+		}
+	}
+	return history, nil
+}
+
+// TokenHoldingsHandler : public endpoint returning the balance of each token held by an account at
+// the last block scanned, with the symbol, name and decimals of the token when known
+func TokenHoldingsHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		holdings, err := balance.Holdings(r.Context(), s, r.URL.Query().Get("address"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(holdings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// TokenHistoryHandler : public endpoint returning the balance checkpoints of each token held by an
+// account, or of the token parameter only, and its balance after each stored transfer, oldest first
+func TokenHistoryHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		histories, err := balance.TokenHistories(r.Context(), s, query.Get("address"), query.Get("token"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(histories)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// RetentionHandler : endpoint returning the retention policy of an account, or replacing it by
+// POST with the days, blocks and transactions parameters, an omitted limit is unlimited. Served
+// publicly the POST is rejected by the scoped storage, policies are replaced by the admin api.
+func RetentionHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := r.URL.Query().Get("address")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			policy, err := parseRetention(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.SetRetention(r.Context(), address, policy); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		policy, err := s.GetRetention(r.Context(), address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(policy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+func parseRetention(r *http.Request) (common.RetentionPolicy, error) {
+	query := r.URL.Query()
+	policy := common.RetentionPolicy{}
+	for name, limit := range map[string]*int{"days": &policy.Days, "blocks": &policy.Blocks, "transactions": &policy.Transactions} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s [%s]", name, v)
+		}
+		*limit = n
+	}
+	return policy, util.ValidateRetention(policy)
+}
+
+// CompactHandler : admin endpoint pruning the expired transactions of all the accounts now
+// instead of waiting for the next compaction
+func CompactHandler(c *retention.Compactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pruned, err := c.Compact(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s, pruned before the error: %v", err.Error(), pruned), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(map[string]interface{}{"pruned": pruned})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// RepairHandler : admin endpoint removing the duplicate transactions of the given address,
+// or of all the accounts when no address is given
+func RepairHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		addresses := []string{r.URL.Query().Get("address")}
+		if addresses[0] == "" {
+			var err error
+			addresses, err = s.GetAccounts(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		removed := make(map[string]int, len(addresses))
+		for _, address := range addresses {
+			n, err := s.DeduplicateTransactions(r.Context(), address)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			removed[address] = n
+		}
+
+		data, err := json.Marshal(map[string]interface{}{"removed": removed})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// ArchiveHandler : admin endpoint streaming all the accounts with their checkpoints and
+// transactions as a JSON Lines archive
+func ArchiveHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", export.ContentType(export.JSONL))
+		w.Header().Set("Content-Disposition", `attachment; filename="archive.jsonl"`)
+		if err := archive.Write(r.Context(), w, s); err != nil {
+			logging.FromContext(r.Context()).Error("archive failed", logging.ErrorKey, err)
+		}
+	}
+}
+
+// ABIHandler : admin endpoint returning the ABI registered for the contract of the address parameter,
+// or the contracts having one when omitted, registering the JSON ABI of the request body by POST and
+// removing it by DELETE. The registered ABI decodes the inputs of the transactions to the contract
+// and the logs it emits.
+func ABIHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := r.URL.Query().Get("address")
+		var result interface{}
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			if address == "" {
+				result, err = s.GetABIs(r.Context())
+			} else {
+				result, err = s.GetABI(r.Context(), address)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		case http.MethodPost:
+			data, err := io.ReadAll(io.LimitReader(r.Body, common.MAXABISIZE+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(data) > common.MAXABISIZE {
+				http.Error(w, "abi too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			parsed, err := abi.Parse(data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			contract := common.ContractABI{Address: address, ABI: data, RegisteredAt: time.Now().UTC()}
+			if err := s.SaveABI(r.Context(), contract); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logging.FromContext(r.Context()).Info("abi registered", "contract", address, "functions", len(parsed.Methods), "events", len(parsed.Events))
+			result = map[string]interface{}{"address": address, "functions": len(parsed.Methods), "events": len(parsed.Events)}
+		case http.MethodDelete:
+			if err := s.RemoveABI(r.Context(), address); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			result = map[string]interface{}{"removed": address}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// EventABIHandler : admin endpoint registering each event of the JSON ABI of the request body by
+// its topic by POST, returning the event ABI registered for the topic parameter, or all of them when
+// omitted, and removing it by DELETE. A registered event decodes the logs of any contract.
+func EventABIHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		var result interface{}
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			if topic == "" {
+				result, err = s.GetEventABIs(r.Context())
+			} else {
+				result, err = s.GetEventABI(r.Context(), topic)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		case http.MethodPost:
+			data, err := io.ReadAll(io.LimitReader(r.Body, common.MAXABISIZE+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(data) > common.MAXABISIZE {
+				http.Error(w, "abi too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			parsed, err := abi.Parse(data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(parsed.Events) == 0 {
+				http.Error(w, "no event in the abi", http.StatusBadRequest)
+				return
+			}
+			registered := make(map[string]string, len(parsed.Events))
+			for topic, ev := range parsed.Events {
+				fragment, err := ev.JSON()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				event := common.EventABI{Topic: topic, Signature: ev.Signature(), ABI: fragment, RegisteredAt: time.Now().UTC()}
+				if err := s.SaveEventABI(r.Context(), event); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				registered[topic] = event.Signature
+			}
+			logging.FromContext(r.Context()).Info("event abis registered", "events", len(registered))
+			result = map[string]interface{}{"registered": registered}
+		case http.MethodDelete:
+			if err := s.RemoveEventABI(r.Context(), topic); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			result = map[string]interface{}{"removed": topic}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// ImportHandler : admin endpoint loading the JSON Lines archive of the request body
+func ImportHandler(s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stats, err := archive.Import(r.Context(), r.Body, s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s, imported before the error: %+v", err.Error(), stats), http.StatusBadRequest)
+			return
+		}
+		logging.FromContext(r.Context()).Info("archive imported", "accounts", stats.Accounts, "transactions", stats.Transactions,
+			"duplicates", stats.Duplicates, "invalid", stats.Invalid)
+
+		data, err := json.Marshal(stats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
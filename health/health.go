@@ -0,0 +1,169 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// Tracker records the outcome of the rounds of the background loop
+type Tracker struct {
+	mu                  sync.RWMutex
+	chainHead           int
+	headFetchedAt       time.Time
+	lastSuccessfulRound time.Time
+	lastError           string
+	lastErrorAt         time.Time
+	modes               map[string]string
+}
+
+// NewTracker initiate a tracker without any round recorded
+func NewTracker() *Tracker {
+	return &Tracker{chainHead: -1}
+}
+
+// RecordRound records a finished round with the chain head fetched for it,
+// head is ignored when negative
+func (t *Tracker) RecordRound(head int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	if head >= 0 {
+		t.chainHead = head
+		t.headFetchedAt = now
+	}
+	if err != nil {
+		t.lastError = err.Error()
+		t.lastErrorAt = now
+		return
+	}
+	t.lastSuccessfulRound = now
+}
+
+// RecordModes records the scanning mode of each account
+func (t *Tracker) RecordModes(modes map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.modes = modes
+}
+
+// ChainHead returns the last chain head fetched and when it was fetched
+func (t *Tracker) ChainHead() (int, time.Time) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.chainHead, t.headFetchedAt
+}
+
+// AccountStatus reports the progress of an account
+type AccountStatus struct {
+	Address      string `json:"address"`
+	CurrentBlock int    `json:"current_block"`
+	Lag          int    `json:"lag"`
+	Mode         string `json:"mode,omitempty"`
+}
+
+// Status reports the progress of the background loop of a network
+type Status struct {
+	Chain               string          `json:"chain"`
+	ChainID             int64           `json:"chain_id"`
+	ChainHead           int             `json:"chain_head"`
+	HeadFetchedAt       *time.Time      `json:"head_fetched_at"`
+	LastSuccessfulRound *time.Time      `json:"last_successful_round"`
+	LastError           string          `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time      `json:"last_error_at,omitempty"`
+	Accounts            []AccountStatus `json:"accounts"`
+}
+
+// LivenessHandler : endpoint reporting the process is alive
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// ReadinessHandler : endpoint reporting the storage is reachable and
+// the chain head has been fetched recently
+func ReadinessHandler(t *Tracker, s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{"storage": "ok", "chain_head": "ok"}
+		status := http.StatusOK
+
+		if _, err := s.GetAccounts(r.Context()); err != nil {
+			checks["storage"] = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+		_, fetchedAt := t.ChainHead()
+		if fetchedAt.IsZero() {
+			checks["chain_head"] = "not fetched yet"
+			status = http.StatusServiceUnavailable
+		} else if age := time.Since(fetchedAt); age > common.HEADMAXAGE {
+			checks["chain_head"] = "last fetched " + age.Round(time.Second).String() + " ago"
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, checks)
+	}
+}
+
+// StatusHandler : endpoint reporting the network of the request, its chain head, lag and scanning
+// mode of each account, last successful round and last error of the background loop
+func StatusHandler(t *Tracker, s common.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addresses, err := s.GetAccounts(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+
+		n := util.NetworkFromContext(r.Context())
+		t.mu.RLock()
+		status := Status{
+			Chain:               n.Name,
+			ChainID:             n.ChainID,
+			ChainHead:           t.chainHead,
+			HeadFetchedAt:       timeOrNil(t.headFetchedAt),
+			LastSuccessfulRound: timeOrNil(t.lastSuccessfulRound),
+			LastError:           t.lastError,
+			LastErrorAt:         timeOrNil(t.lastErrorAt),
+			Accounts:            make([]AccountStatus, 0, len(addresses)),
+		}
+		modes := t.modes
+		t.mu.RUnlock()
+
+		for _, address := range addresses {
+			blockNum, err := s.GetCurrentBlock(r.Context(), address)
+			if err != nil {
+				// unsubscribed while listing
+				continue
+			}
+			account := AccountStatus{Address: address, CurrentBlock: blockNum, Lag: -1, Mode: modes[address]}
+			if status.ChainHead >= 0 {
+				account.Lag = status.ChainHead - blockNum
+			}
+			status.Accounts = append(status.Accounts, account)
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+)
+
+// mockStorage implements the methods of common.Storage used by the tests without reaching
+// the chain, the embedded nil common.Storage panics on the others
+type mockStorage struct {
+	common.Storage
+	accounts map[string]int
+	err      error
+}
+
+func (m *mockStorage) GetAccounts(ctx context.Context) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	addresses := []string{}
+	for address := range m.accounts {
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func (m *mockStorage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
+	return m.accounts[address], nil
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		head       int
+		storageErr error
+		want       int
+	}{
+		{
+			name: "Chain head not fetched yet",
+			head: -1,
+			want: http.StatusServiceUnavailable,
+		}, {
+			name:       "Storage unreachable",
+			head:       15000000,
+			storageErr: errors.New("connection refused"),
+			want:       http.StatusServiceUnavailable,
+		}, {
+			name: "Ready",
+			head: 15000000,
+			want: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker()
+			tracker.RecordRound(tt.head, nil)
+			w := httptest.NewRecorder()
+			ReadinessHandler(tracker, &mockStorage{err: tt.storageErr}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.want {
+				t.Errorf("ReadinessHandler() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	tracker := NewTracker()
+	tracker.RecordRound(15000000, nil)
+	tracker.RecordRound(-1, errors.New("rpc timeout"))
+	tracker.RecordModes(map[string]string{"0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b": "catch-up"})
+
+	w := httptest.NewRecorder()
+	s := &mockStorage{accounts: map[string]int{"0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b": 14000000}}
+	StatusHandler(tracker, s).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var got Status
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Errorf("json.Unmarshal() error : %v", err)
+		return
+	}
+	if got.ChainHead != 15000000 || got.LastError != "rpc timeout" || got.LastSuccessfulRound == nil {
+		t.Errorf("StatusHandler() = %+v", got)
+	}
+	want := []AccountStatus{{Address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b", CurrentBlock: 14000000, Lag: 1000000, Mode: "catch-up"}}
+	if !reflect.DeepEqual(got.Accounts, want) {
+		t.Errorf("StatusHandler() accounts = %v, want %v", got.Accounts, want)
+	}
+}
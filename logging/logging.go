@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Consistent field names used across the log records
+const (
+	AddressKey   = "address"
+	ChainKey     = "chain"
+	BlockKey     = "block"
+	RPCMethodKey = "rpc_method"
+	RequestIDKey = "request_id"
+	ErrorKey     = "error"
+)
+
+// HTTP header carrying the request ID
+const REQUESTIDHEADER = "X-Request-ID"
+
+type contextKey struct{}
+
+// New creates a logger writing records at or above level, as JSON when json is true
+func New(w io.Writer, level slog.Level, json bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// ParseLevel converts debug, info, warn or error to slog.Level, info is used for unknown values
+func ParseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// WithLogger returns a copy of ctx carrying the logger
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware assigns a request ID to each request, taken from the X-Request-ID header
+// when present, and carries a logger with the request ID in the request context
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(REQUESTIDHEADER)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = NewRequestID()
+		}
+		w.Header().Set(REQUESTIDHEADER, requestID)
+
+		l := FromContext(r.Context()).With(RequestIDKey, requestID)
+		start := time.Now()
+		next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), l)))
+		l.Debug("http request", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start))
+	})
+}
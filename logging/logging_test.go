@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level string
+		want  slog.Level
+	}{
+		{name: "Debug level", level: "debug", want: slog.LevelDebug},
+		{name: "Error level", level: "ERROR", want: slog.LevelError},
+		{name: "Unknown level", level: "verbose", want: slog.LevelInfo},
+		{name: "Empty level", level: "", want: slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseLevel(tt.level); got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{name: "Request ID from header", requestID: "abc123"},
+		{name: "Request ID generated", requestID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			base := New(&buf, slog.LevelInfo, true)
+
+			h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				FromContext(r.Context()).Info("storage call", AddressKey, "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/transaction", nil)
+			r = r.WithContext(WithLogger(r.Context(), base))
+			if tt.requestID != "" {
+				r.Header.Set(REQUESTIDHEADER, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			got := w.Header().Get(REQUESTIDHEADER)
+			if got == "" || (tt.requestID != "" && got != tt.requestID) {
+				t.Errorf("Middleware() request ID = %q, want %q", got, tt.requestID)
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Errorf("json.Unmarshal() error : %v", err)
+				return
+			}
+			if record[RequestIDKey] != got {
+				t.Errorf("Middleware() logged request ID = %v, want %v", record[RequestIDKey], got)
+			}
+		})
+	}
+}
@@ -1,32 +1,366 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	abi "github.com/tonyxu1/transactionhistory/abi"
+	auth "github.com/tonyxu1/transactionhistory/auth"
+	balance "github.com/tonyxu1/transactionhistory/balance"
+	cli "github.com/tonyxu1/transactionhistory/cli"
 	common "github.com/tonyxu1/transactionhistory/common"
+	gql "github.com/tonyxu1/transactionhistory/gql"
+	grpcserver "github.com/tonyxu1/transactionhistory/grpcserver"
 	handler "github.com/tonyxu1/transactionhistory/handler"
-	storage "github.com/tonyxu1/transactionhistory/storage"
+	health "github.com/tonyxu1/transactionhistory/health"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	network "github.com/tonyxu1/transactionhistory/network"
+	ratelimit "github.com/tonyxu1/transactionhistory/ratelimit"
+	retention "github.com/tonyxu1/transactionhistory/retention"
+	scanner "github.com/tonyxu1/transactionhistory/scanner"
+	snapshot "github.com/tonyxu1/transactionhistory/snapshot"
+
+	"google.golang.org/grpc"
 )
 
 func main() {
-	storage := storage.New()
+	logger := logging.New(os.Stdout, logging.ParseLevel(os.Getenv(common.LOGLEVELENV)), os.Getenv(common.LOGFORMATENV) == "json")
+	slog.SetDefault(logger)
+
+	// ctx is cancelled on SIGINT or SIGTERM to start the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// with arguments the app is the command line of a running server
+	if len(os.Args) > 1 {
+		if err := cli.Run(ctx, os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the deadline of the shutdown, long enough for the snapshots to be written
+	shutdownTimeout := common.SHUTDOWNTIMEOUT
+	if value := os.Getenv(common.SHUTDOWNTIMEOUTENV); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			logger.Error("shutdown timeout invalid, a positive duration such as 1m is expected", "value", value)
+			os.Exit(1)
+		}
+		shutdownTimeout = timeout
+	}
+
+	// each network has its own storage, subscriptions are keyed by network and address
+	networks, err := network.Load(os.Getenv(common.NETWORKSCONFIGENV))
+	if err != nil {
+		logger.Error("networks config invalid", logging.ErrorKey, err)
+		os.Exit(1)
+	}
+	chains := network.New(networks)
+	// an endpoint of another chain would mix its transactions in the storage of the network
+	for _, c := range chains.Chains() {
+		if err := c.VerifyChainID(ctx); err != nil {
+			logger.Error("chain id mismatch", logging.ChainKey, c.Name, logging.ErrorKey, err)
+			os.Exit(1)
+		}
+	}
+
+	// the selector database decodes the inputs of the contracts without registered abi
+	if path := os.Getenv(common.SELECTORSPATHENV); path != "" {
+		n, err := abi.Selectors.LoadFile(path)
+		if err != nil {
+			logger.Error("selector database invalid", "path", path, logging.ErrorKey, err)
+			os.Exit(1)
+		}
+		logger.Info("selector database loaded", "path", path, "signatures", n)
+	}
+
+	// the storage of each network is restored from its last snapshot, saved between rounds and on shutdown
+	snapshotPath := os.Getenv(common.SNAPSHOTPATHENV)
+	keys := auth.NewKeyStore()
+	keysPath := ""
+	if snapshotPath != "" {
+		keysPath = snapshotPath + common.KEYSSNAPSHOTSUFFIX
+	}
+	for _, c := range chains.Chains() {
+		path := c.Path(snapshotPath)
+		if path == "" {
+			continue
+		}
+		stats, found, err := snapshot.Load(c.Context(ctx), path, c.Storage)
+		if err != nil {
+			// starting empty would overwrite the snapshot with the next one
+			logger.Error("snapshot load failed", logging.ChainKey, c.Name, "path", path, logging.ErrorKey, err)
+			os.Exit(1)
+		}
+		if found {
+			logger.Info("snapshot loaded", logging.ChainKey, c.Name, "path", path, "accounts", stats.Accounts, "transactions", stats.Transactions, "headers", stats.Headers)
+		}
+	}
+	// the API keys own the restored accounts, without them every key and account would be lost
+	if keysPath != "" {
+		n, found, err := snapshot.LoadKeys(keysPath, keys)
+		if err != nil {
+			logger.Error("keys snapshot load failed", "path", keysPath, logging.ErrorKey, err)
+			os.Exit(1)
+		}
+		if found {
+			logger.Info("keys snapshot loaded", "path", keysPath, "keys", n)
+		}
+	}
+	// the rounds of the background loops hold rounds for reading, the snapshots wait for the rounds
+	// in progress and for the subscription changes, so that the keys own exactly the saved accounts
+	// and the storages of all the networks are saved between rounds
+	var rounds sync.RWMutex
+	saveSnapshot := func(ctx context.Context) {
+		if snapshotPath == "" {
+			return
+		}
+		defer keys.View()()
+		rounds.Lock()
+		defer rounds.Unlock()
+
+		start := time.Now()
+		files := make([]snapshot.File, 0, len(chains.Chains())+1)
+		for _, c := range chains.Chains() {
+			files = append(files, snapshot.Storage(c.Context(ctx), c.Path(snapshotPath), c.Storage))
+		}
+		files = append(files, snapshot.Keys(keysPath, keys))
+		if err := snapshot.SaveAll(files...); err != nil {
+			logger.Error("snapshot failed", "path", snapshotPath, logging.ErrorKey, err)
+			return
+		}
+		logger.Info("snapshot saved", "path", snapshotPath, "files", len(files), "duration", time.Since(start))
+	}
+
+	// expired transactions are pruned between rounds, archived to the cold storage file of the network when set
+	coldPath := os.Getenv(common.RETENTIONARCHIVEENV)
+	compactor := func(c *network.Chain) *retention.Compactor {
+		compactor := retention.New(c.Storage, c.Path(coldPath))
+		// the transactions pruned after the last balance checkpoint would raise a false drift
+		compactor.Pruned = balance.New(c.Storage).Reanchor
+		return compactor
+	}
+
 	mux := http.NewServeMux()
 
-	mux.Handle("/currentblock", handler.CurrentBlockHandler(&storage))
-	mux.Handle("/subscribe", handler.SubscribeHandler(&storage))
-	mux.Handle("/transaction", handler.TransactionHistoryHandler(&storage))
+	adminToken := os.Getenv(common.ADMINTOKENENV)
+	if adminToken == "" {
+		logger.Warn("admin api is disabled, set " + common.ADMINTOKENENV + " to issue api keys")
+	}
+
+	limiter := ratelimit.New(
+		ratelimit.Limit{Rate: common.DEFAULTRATELIMIT, Burst: common.DEFAULTBURST},
+		map[string]ratelimit.Limit{
+			"/subscribe": {Rate: common.SUBSCRIBERATELIMIT, Burst: common.SUBSCRIBEBURST},
+			"/transactionhistory.TransactionHistory/Subscribe": {Rate: common.SUBSCRIBERATELIMIT, Burst: common.SUBSCRIBEBURST},
+		})
+	ipLimiter := ratelimit.New(ratelimit.Limit{Rate: common.IPRATELIMIT, Burst: common.IPBURST}, nil)
+
+	// the storage of the network selected by the chain parameter, or of the default network
+	perChain := func(build func(common.Storage) http.HandlerFunc) http.Handler {
+		return chains.Handler(func(c *network.Chain) http.Handler { return build(c.Storage) })
+	}
+	// public routes are limited by IP address before the API key is checked, so that guessing keys is
+	// throttled, then authenticated so that the rate limit of the route is keyed by API key
+	publicChain := func(route string, build func(c *network.Chain, s common.Storage) http.HandlerFunc) {
+		mux.Handle(route, metrics.Middleware(route, logging.Middleware(ipLimiter.IPMiddleware(route, keys.Authenticate(limiter.Middleware(route,
+			chains.Handler(func(c *network.Chain) http.Handler {
+				return keys.Scoped(c.Storage, func(s common.Storage) http.HandlerFunc { return build(c, s) })
+			})))))))
+	}
+	public := func(route string, build func(common.Storage) http.HandlerFunc) {
+		publicChain(route, func(c *network.Chain, s common.Storage) http.HandlerFunc { return build(s) })
+	}
+	public("/currentblock", handler.CurrentBlockHandler)
+	public("/subscribe", handler.SubscribeHandler)
+	public("/unsubscribe", handler.UnsubscribeHandler)
+	public("/transaction", handler.TransactionHistoryHandler)
+	public("/transaction/{hash}", handler.TransactionLookupHandler)
+	public("/export", handler.ExportHandler)
+	public("/retention", handler.RetentionHandler)
+	public("/balance", handler.BalanceHandler)
+	public("/balance/history", handler.BalanceHistoryHandler)
+	public("/tokens", handler.TokenHoldingsHandler)
+	public("/tokens/history", handler.TokenHistoryHandler)
+	public("/graphql", gql.Handler)
+	// admin routes check the admin token, the rate limit is keyed by IP
+	admin := func(route string, h http.Handler) {
+		mux.Handle(route, metrics.Middleware(route, logging.Middleware(limiter.Middleware(route, h))))
+	}
+	admin("/admin/keys", auth.AdminHandler(keys, chains, adminToken))
+	admin("/admin/repair", auth.AdminOnly(adminToken, perChain(handler.RepairHandler)))
+	admin("/admin/archive", auth.AdminOnly(adminToken, perChain(handler.ArchiveHandler)))
+	admin("/admin/import", auth.AdminOnly(adminToken, perChain(handler.ImportHandler)))
+	admin("/admin/retention", auth.AdminOnly(adminToken, perChain(handler.RetentionHandler)))
+	admin("/admin/abi", auth.AdminOnly(adminToken, perChain(handler.ABIHandler)))
+	admin("/admin/abi/events", auth.AdminOnly(adminToken, perChain(handler.EventABIHandler)))
+	admin("/admin/compact", auth.AdminOnly(adminToken, chains.Handler(func(c *network.Chain) http.Handler {
+		return handler.CompactHandler(compactor(c))
+	})))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", chains.Handler(func(c *network.Chain) http.Handler { return health.ReadinessHandler(c.Tracker, c.Storage) }))
+	// the status lists the accounts of the API key only
+	publicChain("/status", func(c *network.Chain, s common.Storage) http.HandlerFunc { return health.StatusHandler(c.Tracker, s) })
 
 	//TODO: Not found handler
 
+	// a background loop per network stops between blocks when ctx is cancelled,
+	// saving the transactions found and the checkpoint of the round
+	var loop sync.WaitGroup
+	for _, c := range chains.Chains() {
+		sc := scanner.New(c.Name, c.Storage)
+		sc.Confirmations = c.ConfirmationDepth
+		compactor := compactor(c)
+		reconciler := balance.New(c.Storage)
+		loop.Add(1)
+		go func() {
+			defer loop.Done()
+			ctx := c.Context(logging.WithLogger(ctx, logger.With("component", "scanner")))
+			lastCompaction := time.Now()
+			for {
+				rounds.RLock()
+				start := time.Now()
+				head, err := sc.ScanAll(ctx)
+				c.Tracker.RecordRound(head, err)
+				metrics.RoundDuration.WithLabelValues(c.Name).Observe(time.Since(start).Seconds())
+
+				modes := make(map[string]string)
+				for address, mode := range sc.Modes() {
+					modes[address] = string(mode)
+				}
+				c.Tracker.RecordModes(modes)
+
+				// new accounts are anchored at the block the round scanned up to,
+				// the others reconciled once the scanner passed their last checkpoint
+				if ctx.Err() == nil && head >= 0 {
+					reconciler.Reconcile(ctx, head-sc.Confirmations)
+				}
+
+				if ctx.Err() == nil && time.Since(lastCompaction) >= common.COMPACTIONINTERVAL {
+					compactor.Compact(ctx)
+					lastCompaction = time.Now()
+				}
+				// no transactions nor checkpoints are saved between rounds, the snapshots are saved then
+				rounds.RUnlock()
+
+				// accounts in catch-up mode are scanned continuously unless the round failed
+				idle := c.BlockTime
+				if err == nil && sc.CatchingUp() {
+					idle = 0
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(idle):
+				}
+			}
+		}()
+	}
+
+	// the snapshots of all the networks and the keys are saved together
+	if snapshotPath != "" {
+		loop.Add(1)
+		go func() {
+			defer loop.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(common.SNAPSHOTINTERVAL):
+					saveSnapshot(ctx)
+				}
+			}
+		}()
+	}
+
+	// request contexts and gRPC streams are cancelled at shutdown so that streaming responses end
+	streamCtx, cancelStreams := context.WithCancel(context.Background())
+	grpcServer := grpcserver.New(chains, keys, grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(limiter.StreamServerInterceptor(), grpcserver.CancelStreams(streamCtx)))
+	lis, err := net.Listen("tcp", common.GRPCADDRESS)
+	if err != nil {
+		logger.Error("gRPC listen failed", logging.ErrorKey, err)
+		os.Exit(1)
+	}
 	go func() {
-		for {
-			storage.UpdateAllAccount()
-			time.Sleep(common.INTERVALINSECONDS)
+		logger.Info("gRPC server started", "address", common.GRPCADDRESS)
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("gRPC server stopped", logging.ErrorKey, err)
+			stop()
 		}
+	}()
 
+	httpServer := &http.Server{
+		Addr:        ":8485",
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return streamCtx },
+	}
+	httpServer.RegisterOnShutdown(cancelStreams)
+	go func() {
+		logger.Info("Http server started", "address", httpServer.Addr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Http server stopped", logging.ErrorKey, err)
+			stop()
+		}
 	}()
-	log.Println("Http server started at port 8485")
-	log.Fatalln(http.ListenAndServe(":8485", mux))
+
+	<-ctx.Done()
+	logger.Info("shutting down", "deadline", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Http server shutdown failed", logging.ErrorKey, err)
+		httpServer.Close()
+	}
+
+	cancelStreams()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
+
+	loopStopped := make(chan struct{})
+	go func() {
+		loop.Wait()
+		close(loopStopped)
+	}()
+	select {
+	case <-loopStopped:
+	case <-shutdownCtx.Done():
+		// the loop may stop as the deadline expires, both cases are then ready
+		select {
+		case <-loopStopped:
+		default:
+			logger.Error("shutdown deadline exceeded before the background loop stopped")
+			os.Exit(1)
+		}
+	}
+
+	// the servers and the background loop are stopped, nothing writes to the storage, the snapshots
+	// have their own deadline as the shutdown one may be spent
+	snapshotCtx, cancelSnapshot := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelSnapshot()
+	saveSnapshot(snapshotCtx)
+	logger.Info("shutdown completed")
 }
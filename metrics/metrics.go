@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "transactionhistory"
+
+var (
+	// ThrottledRequests counts the requests rejected by the rate limiter
+	ThrottledRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "throttled_requests_total",
+		Help:      "Number of requests rejected by the rate limiter.",
+	}, []string{"route"})
+
+	// RPCDuration observes the latency of Json RPC requests
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "Latency of Json RPC requests by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// RPCErrors counts the failed Json RPC requests
+	RPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed Json RPC requests by method.",
+	}, []string{"method"})
+
+	// BlocksScanned counts the blocks scanned from chain by network, its rate gives blocks scanned per second
+	BlocksScanned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_scanned_total",
+		Help:      "Number of blocks scanned from chain.",
+	}, []string{"chain"})
+
+	// AccountLag reports the number of blocks the account furthest behind chain head is behind it,
+	// by network. The addresses are not labels, /metrics is public and the accounts of an API key
+	// are only seen with that key.
+	AccountLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "account_lag_max_blocks",
+		Help:      "Largest number of blocks between chain head and the current block of an account.",
+	}, []string{"chain"})
+
+	// Subscriptions reports the number of subscribed accounts
+	Subscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "subscriptions",
+		Help:      "Number of subscribed accounts.",
+	})
+
+	// StoredTransactions reports the number of transactions in storage
+	StoredTransactions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stored_transactions",
+		Help:      "Number of transactions in storage.",
+	})
+
+	// PrunedTransactions counts the transactions removed by the retention compactor
+	PrunedTransactions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pruned_transactions_total",
+		Help:      "Number of transactions removed by the retention compactor.",
+	})
+
+	// BalanceDrifts counts the balance checkpoints whose balance read from the node differs
+	// from the balance computed over the stored transactions, by kind native or token
+	BalanceDrifts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "balance_drifts_total",
+		Help:      "Number of balance checkpoints drifting from the balance computed over the stored transactions or token transfers.",
+	}, []string{"chain", "kind"})
+
+	// ScannerWorkers reports the number of workers chosen by the adaptive controller of each network
+	ScannerWorkers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scanner_workers",
+		Help:      "Number of workers scanning a round, chosen by the adaptive controller.",
+	}, []string{"chain"})
+
+	// ScannerBatchSize reports the blocks per round chosen by the adaptive controller of each network
+	ScannerBatchSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scanner_blocks_per_round",
+		Help:      "Number of blocks scanned per account in a round, chosen by the adaptive controller.",
+	}, []string{"chain"})
+
+	// ScannerBackoffs counts the decreases of the adaptive controller of each network
+	ScannerBackoffs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scanner_backoffs_total",
+		Help:      "Number of times the adaptive controller backed off by reason.",
+	}, []string{"chain", "reason"})
+
+	// HTTPDuration observes the latency of HTTP requests
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "status"})
+
+	// RoundDuration observes the duration of each round of the background loop of each network
+	RoundDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "round_duration_seconds",
+		Help:      "Duration of each round of the background loop updating all accounts.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"chain"})
+)
+
+// Handler : public endpoint exposing the metrics in Prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware observes the latency and status of the requests to the route
+func Middleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		HTTPDuration.WithLabelValues(route, strconv.Itoa(sw.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter records the status code written by the handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the writer
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		status int
+		want   string
+	}{
+		{
+			name:   "Successful request observed",
+			route:  "/transaction",
+			status: http.StatusOK,
+			want:   `route="/transaction",status="200"`,
+		}, {
+			name:   "Rejected request observed with its status",
+			route:  "/subscribe",
+			status: http.StatusTooManyRequests,
+			want:   `route="/subscribe",status="429"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Middleware(tt.route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.route, nil))
+
+			w := httptest.NewRecorder()
+			Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Middleware() series with %s not found", tt.want)
+			}
+		})
+	}
+	if got := testutil.CollectAndCount(HTTPDuration); got != len(tests) {
+		t.Errorf("HTTPDuration series = %d, want %d", got, len(tests))
+	}
+}
@@ -0,0 +1,194 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	health "github.com/tonyxu1/transactionhistory/health"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	storage "github.com/tonyxu1/transactionhistory/storage"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// names are used in query parameters and file names
+var namePattern = regexp.MustCompile("^[a-z0-9][a-z0-9-]*$")
+
+// config is the JSON file configuring the networks, block times are durations such as "12s"
+type config struct {
+	Networks []struct {
+		common.Network
+		BlockTime string `json:"block_time"`
+	} `json:"networks"`
+}
+
+// Load reads the networks configured in the JSON file at path, the first one is the default
+// network. Only the default network of util.DefaultNetwork is served when path is empty.
+func Load(path string) ([]common.Network, error) {
+	if path == "" {
+		return []common.Network{util.DefaultNetwork()}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid networks config: %s", err.Error())
+	}
+	if len(c.Networks) == 0 {
+		return nil, fmt.Errorf("no network configured in [%s]", path)
+	}
+
+	networks := make([]common.Network, 0, len(c.Networks))
+	names := make(map[string]struct{}, len(c.Networks))
+	for _, entry := range c.Networks {
+		n := entry.Network
+		n.BlockTime = common.INTERVALINSECONDS
+		if entry.BlockTime != "" {
+			if n.BlockTime, err = time.ParseDuration(entry.BlockTime); err != nil {
+				return nil, fmt.Errorf("network [%s]: invalid block time [%s]", n.Name, entry.BlockTime)
+			}
+		}
+		if err := Validate(n); err != nil {
+			return nil, err
+		}
+		if _, ok := names[n.Name]; ok {
+			return nil, fmt.Errorf("network [%s] configured twice", n.Name)
+		}
+		names[n.Name] = struct{}{}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// Validate checks the name, chain id, endpoints and limits of the network
+func Validate(n common.Network) error {
+	if !namePattern.MatchString(n.Name) {
+		return fmt.Errorf("network name [%s] is invalid, expecting lowercase letters, digits and dashes", n.Name)
+	}
+	if len(n.RPCEndpoints) == 0 {
+		return fmt.Errorf("network [%s]: no json rpc endpoint", n.Name)
+	}
+	for _, endpoint := range n.RPCEndpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("network [%s]: invalid json rpc endpoint [%s]", n.Name, endpoint)
+		}
+	}
+	if n.LookbackBlocks < 0 || n.ConfirmationDepth < 0 {
+		return fmt.Errorf("network [%s]: lookback blocks and confirmation depth must not be negative", n.Name)
+	}
+	if n.BlockTime <= 0 {
+		return fmt.Errorf("network [%s]: block time must be positive", n.Name)
+	}
+	if n.ChainID <= 0 {
+		return fmt.Errorf("network [%s]: chain id must be positive", n.Name)
+	}
+	return nil
+}
+
+// Chain is a network served by the deployment with the storage of its subscriptions
+// and the progress of its background loop
+type Chain struct {
+	common.Network
+	Storage common.Storage
+	Tracker *health.Tracker
+}
+
+// Context returns a copy of ctx carrying the network, for its Json RPC endpoints,
+// and a logger with the network name
+func (c *Chain) Context(ctx context.Context) context.Context {
+	ctx = util.WithNetwork(ctx, c.Network)
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With(logging.ChainKey, c.Name))
+}
+
+// VerifyChainID checks each Json RPC endpoint of the network serves the configured chain id, so
+// that a misconfigured endpoint does not mix the transactions of another chain in the storage.
+// An endpoint failing to answer is only logged, it is checked by the readiness of the network.
+func (c *Chain) VerifyChainID(ctx context.Context) error {
+	ctx = c.Context(ctx)
+	for _, endpoint := range c.RPCEndpoints {
+		n := c.Network
+		n.RPCEndpoints = []string{endpoint}
+		id, err := util.GetChainID(util.WithNetwork(ctx, n))
+		if err != nil {
+			logging.FromContext(ctx).Warn("chain id not verified", "endpoint", endpoint, logging.ErrorKey, err)
+			continue
+		}
+		if id != c.ChainID {
+			return fmt.Errorf("network [%s]: json rpc endpoint [%s] serves chain id [%d], expecting [%d]", c.Name, endpoint, id, c.ChainID)
+		}
+	}
+	return nil
+}
+
+// Path returns the file of the network derived from base, the default network name
+// keeps base so that single network deployments keep their files
+func (c *Chain) Path(base string) string {
+	if base == "" || c.Name == common.DEFAULTNETWORK {
+		return base
+	}
+	return base + "." + c.Name
+}
+
+// Set holds the chains of the deployment, the first one is the default
+type Set struct {
+	chains []*Chain
+	byName map[string]*Chain
+}
+
+// NewSet returns the set of the chains, the first one is the default
+func NewSet(chains ...*Chain) *Set {
+	s := &Set{chains: chains, byName: make(map[string]*Chain, len(chains))}
+	for _, c := range chains {
+		s.byName[c.Name] = c
+	}
+	return s
+}
+
+// New returns the set of the networks, each with its own empty storage
+func New(networks []common.Network) *Set {
+	chains := make([]*Chain, 0, len(networks))
+	for _, n := range networks {
+		s := storage.New()
+		chains = append(chains, &Chain{Network: n, Storage: &s, Tracker: health.NewTracker()})
+	}
+	return NewSet(chains...)
+}
+
+// Chains returns the chains in configuration order
+func (s *Set) Chains() []*Chain {
+	return s.chains
+}
+
+// Get returns the chain of the network name, the default chain when name is empty
+func (s *Set) Get(name string) (*Chain, error) {
+	if name == "" {
+		return s.chains[0], nil
+	}
+	c, ok := s.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("network [%s] is not configured", name)
+	}
+	return c, nil
+}
+
+// Handler builds the handler of the network selected by the chain query parameter,
+// the request context carries the network for the next handlers
+func (s *Set) Handler(build func(c *Chain) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.Get(r.URL.Query().Get(common.CHAINPARAM))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		build(c).ServeHTTP(w, r.WithContext(c.Context(r.Context())))
+	})
+}
@@ -0,0 +1,197 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []common.Network
+		wantErr bool
+	}{
+		{
+			name:   "Networks loaded",
+			config: `{"networks":[{"name":"mainnet","chain_id":1,"rpc_endpoints":["https://a.example","https://b.example"],"lookback_blocks":100,"confirmation_depth":12,"block_time":"12s"},{"name":"base","chain_id":8453,"rpc_endpoints":["https://base.example"]}]}`,
+			want: []common.Network{
+				{Name: "mainnet", ChainID: 1, RPCEndpoints: []string{"https://a.example", "https://b.example"}, LookbackBlocks: 100, ConfirmationDepth: 12, BlockTime: 12 * time.Second},
+				{Name: "base", ChainID: 8453, RPCEndpoints: []string{"https://base.example"}, BlockTime: common.INTERVALINSECONDS},
+			},
+		}, {
+			name:    "Network configured twice",
+			config:  `{"networks":[{"name":"base","chain_id":8453,"rpc_endpoints":["https://a.example"]},{"name":"base","chain_id":8453,"rpc_endpoints":["https://b.example"]}]}`,
+			wantErr: true,
+		}, {
+			name:    "Invalid name",
+			config:  `{"networks":[{"name":"Base Mainnet","chain_id":8453,"rpc_endpoints":["https://a.example"]}]}`,
+			wantErr: true,
+		}, {
+			name:    "Missing endpoint",
+			config:  `{"networks":[{"name":"base","chain_id":8453}]}`,
+			wantErr: true,
+		}, {
+			name:    "Invalid endpoint",
+			config:  `{"networks":[{"name":"base","chain_id":8453,"rpc_endpoints":["base.example"]}]}`,
+			wantErr: true,
+		}, {
+			name:    "Negative confirmation depth",
+			config:  `{"networks":[{"name":"base","chain_id":8453,"rpc_endpoints":["https://a.example"],"confirmation_depth":-1}]}`,
+			wantErr: true,
+		}, {
+			name:    "Invalid block time",
+			config:  `{"networks":[{"name":"base","chain_id":8453,"rpc_endpoints":["https://a.example"],"block_time":"2"}]}`,
+			wantErr: true,
+		}, {
+			name:    "Missing chain id",
+			config:  `{"networks":[{"name":"base","rpc_endpoints":["https://a.example"]}]}`,
+			wantErr: true,
+		}, {
+			name:    "No network",
+			config:  `{"networks":[]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "networks.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Default(t *testing.T) {
+	got, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := []common.Network{util.DefaultNetwork()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+}
+
+func TestSet_Handler(t *testing.T) {
+	base := util.DefaultNetwork()
+	base.Name = "base"
+	set := New([]common.Network{util.DefaultNetwork(), base})
+
+	tests := []struct {
+		name      string
+		query     string
+		want      int
+		wantChain string
+	}{
+		{
+			name:      "Default network when omitted",
+			query:     "",
+			want:      http.StatusOK,
+			wantChain: common.DEFAULTNETWORK,
+		}, {
+			name:      "Network selected",
+			query:     "?chain=base",
+			want:      http.StatusOK,
+			wantChain: "base",
+		}, {
+			name:  "Network not configured",
+			query: "?chain=sepolia",
+			want:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := set.Handler(func(c *Chain) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if util.NetworkFromContext(r.Context()).Name != c.Name {
+						t.Errorf("NetworkFromContext() = %s, want %s", util.NetworkFromContext(r.Context()).Name, c.Name)
+					}
+					got = c.Name
+				})
+			})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/currentblock"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Errorf("Set.Handler() status = %d, want %d", w.Code, tt.want)
+			}
+			if got != tt.wantChain {
+				t.Errorf("Set.Handler() chain = %s, want %s", got, tt.wantChain)
+			}
+		})
+	}
+}
+
+func TestChain_Path(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain string
+		base  string
+		want  string
+	}{
+		{name: "Default network keeps the path", chain: common.DEFAULTNETWORK, base: "/data/snapshot.gz", want: "/data/snapshot.gz"},
+		{name: "Other network suffixed", chain: "base", base: "/data/snapshot.gz", want: "/data/snapshot.gz.base"},
+		{name: "Disabled stays disabled", chain: "base", base: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Chain{Network: common.Network{Name: tt.chain}}
+			if got := c.Path(tt.base); got != tt.want {
+				t.Errorf("Chain.Path() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChain_VerifyChainID(t *testing.T) {
+	rpc := func(status int, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+	}
+	mainnet := rpc(http.StatusOK, `{"jsonrpc":"2.0","result":"0x1","id":1}`)
+	defer mainnet.Close()
+	base := rpc(http.StatusOK, `{"jsonrpc":"2.0","result":"0x2105","id":1}`)
+	defer base.Close()
+	down := rpc(http.StatusBadGateway, "")
+	defer down.Close()
+
+	tests := []struct {
+		name      string
+		endpoints []string
+		wantErr   bool
+	}{
+		{name: "Endpoints serve the chain", endpoints: []string{mainnet.URL, mainnet.URL}},
+		{name: "Endpoint of another chain", endpoints: []string{mainnet.URL, base.URL}, wantErr: true},
+		{name: "Endpoint failing to answer is skipped", endpoints: []string{down.URL, mainnet.URL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := util.DefaultNetwork()
+			n.RPCEndpoints = tt.endpoints
+			c := New([]common.Network{n}).Chains()[0]
+			if err := c.VerifyChainID(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Chain.VerifyChainID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
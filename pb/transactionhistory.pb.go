@@ -0,0 +1,1037 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v4.25.0
+// source: transactionhistory.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AddressRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Address string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	// Name of the configured network, the default network when empty
+	Chain string `protobuf:"bytes,2,opt,name=chain,proto3" json:"chain,omitempty"`
+	// Keeps the transactions with the label relative to the address, all of them when empty
+	Label         string `protobuf:"bytes,3,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddressRequest) Reset() {
+	*x = AddressRequest{}
+	mi := &file_transactionhistory_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddressRequest) ProtoMessage() {}
+
+func (x *AddressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddressRequest.ProtoReflect.Descriptor instead.
+func (*AddressRequest) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AddressRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *AddressRequest) GetChain() string {
+	if x != nil {
+		return x.Chain
+	}
+	return ""
+}
+
+func (x *AddressRequest) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+type SubscribeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeResponse) Reset() {
+	*x = SubscribeResponse{}
+	mi := &file_transactionhistory_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeResponse) ProtoMessage() {}
+
+func (x *SubscribeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeResponse.ProtoReflect.Descriptor instead.
+func (*SubscribeResponse) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubscribeResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type UnsubscribeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnsubscribeResponse) Reset() {
+	*x = UnsubscribeResponse{}
+	mi := &file_transactionhistory_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnsubscribeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnsubscribeResponse) ProtoMessage() {}
+
+func (x *UnsubscribeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnsubscribeResponse.ProtoReflect.Descriptor instead.
+func (*UnsubscribeResponse) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UnsubscribeResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type CurrentBlockResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BlockNumber   int64                  `protobuf:"varint,1,opt,name=block_number,json=blockNumber,proto3" json:"block_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CurrentBlockResponse) Reset() {
+	*x = CurrentBlockResponse{}
+	mi := &file_transactionhistory_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CurrentBlockResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CurrentBlockResponse) ProtoMessage() {}
+
+func (x *CurrentBlockResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CurrentBlockResponse.ProtoReflect.Descriptor instead.
+func (*CurrentBlockResponse) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CurrentBlockResponse) GetBlockNumber() int64 {
+	if x != nil {
+		return x.BlockNumber
+	}
+	return 0
+}
+
+type AccessTuple struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	StorageKeys   []string               `protobuf:"bytes,2,rep,name=storage_keys,json=storageKeys,proto3" json:"storage_keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AccessTuple) Reset() {
+	*x = AccessTuple{}
+	mi := &file_transactionhistory_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AccessTuple) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AccessTuple) ProtoMessage() {}
+
+func (x *AccessTuple) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AccessTuple.ProtoReflect.Descriptor instead.
+func (*AccessTuple) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AccessTuple) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *AccessTuple) GetStorageKeys() []string {
+	if x != nil {
+		return x.StorageKeys
+	}
+	return nil
+}
+
+// Transaction defines schema of a transaction, values are hex strings as returned by Json RPC
+type Transaction struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Type                 string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	BlockHash            string                 `protobuf:"bytes,2,opt,name=block_hash,json=blockHash,proto3" json:"block_hash,omitempty"`
+	BlockNumber          string                 `protobuf:"bytes,3,opt,name=block_number,json=blockNumber,proto3" json:"block_number,omitempty"`
+	From                 string                 `protobuf:"bytes,4,opt,name=from,proto3" json:"from,omitempty"`
+	Gas                  string                 `protobuf:"bytes,5,opt,name=gas,proto3" json:"gas,omitempty"`
+	Hash                 string                 `protobuf:"bytes,6,opt,name=hash,proto3" json:"hash,omitempty"`
+	Input                string                 `protobuf:"bytes,7,opt,name=input,proto3" json:"input,omitempty"`
+	Nonce                string                 `protobuf:"bytes,8,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	To                   string                 `protobuf:"bytes,9,opt,name=to,proto3" json:"to,omitempty"`
+	TransactionIndex     string                 `protobuf:"bytes,10,opt,name=transaction_index,json=transactionIndex,proto3" json:"transaction_index,omitempty"`
+	Value                string                 `protobuf:"bytes,11,opt,name=value,proto3" json:"value,omitempty"`
+	V                    string                 `protobuf:"bytes,12,opt,name=v,proto3" json:"v,omitempty"`
+	R                    string                 `protobuf:"bytes,13,opt,name=r,proto3" json:"r,omitempty"`
+	S                    string                 `protobuf:"bytes,14,opt,name=s,proto3" json:"s,omitempty"`
+	GasPrice             string                 `protobuf:"bytes,15,opt,name=gas_price,json=gasPrice,proto3" json:"gas_price,omitempty"`
+	MaxFeePerGas         string                 `protobuf:"bytes,16,opt,name=max_fee_per_gas,json=maxFeePerGas,proto3" json:"max_fee_per_gas,omitempty"`
+	MaxPriorityFeePerGas string                 `protobuf:"bytes,17,opt,name=max_priority_fee_per_gas,json=maxPriorityFeePerGas,proto3" json:"max_priority_fee_per_gas,omitempty"`
+	ChainId              string                 `protobuf:"bytes,18,opt,name=chain_id,json=chainId,proto3" json:"chain_id,omitempty"`
+	AccessList           []*AccessTuple         `protobuf:"bytes,19,rep,name=access_list,json=accessList,proto3" json:"access_list,omitempty"`
+	// block timestamp in unix seconds as hex string
+	Timestamp string `protobuf:"bytes,20,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// receipt fields, empty for transactions stored without receipt
+	Status            string `protobuf:"bytes,21,opt,name=status,proto3" json:"status,omitempty"`
+	GasUsed           string `protobuf:"bytes,22,opt,name=gas_used,json=gasUsed,proto3" json:"gas_used,omitempty"`
+	EffectiveGasPrice string `protobuf:"bytes,23,opt,name=effective_gas_price,json=effectiveGasPrice,proto3" json:"effective_gas_price,omitempty"`
+	// label relative to the requested address such as "incoming_transfer", "swap" or "failed"
+	Label string `protobuf:"bytes,24,opt,name=label,proto3" json:"label,omitempty"`
+	// event logs emitted by the transaction, empty for transactions stored without receipt
+	Logs []*Log `protobuf:"bytes,25,rep,name=logs,proto3" json:"logs,omitempty"`
+	// input decoded into the function called, unset for transfers without input and contract creations
+	Decoded       *DecodedCall `protobuf:"bytes,26,opt,name=decoded,proto3" json:"decoded,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Transaction) Reset() {
+	*x = Transaction{}
+	mi := &file_transactionhistory_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Transaction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Transaction) ProtoMessage() {}
+
+func (x *Transaction) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Transaction.ProtoReflect.Descriptor instead.
+func (*Transaction) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Transaction) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Transaction) GetBlockHash() string {
+	if x != nil {
+		return x.BlockHash
+	}
+	return ""
+}
+
+func (x *Transaction) GetBlockNumber() string {
+	if x != nil {
+		return x.BlockNumber
+	}
+	return ""
+}
+
+func (x *Transaction) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Transaction) GetGas() string {
+	if x != nil {
+		return x.Gas
+	}
+	return ""
+}
+
+func (x *Transaction) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Transaction) GetInput() string {
+	if x != nil {
+		return x.Input
+	}
+	return ""
+}
+
+func (x *Transaction) GetNonce() string {
+	if x != nil {
+		return x.Nonce
+	}
+	return ""
+}
+
+func (x *Transaction) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *Transaction) GetTransactionIndex() string {
+	if x != nil {
+		return x.TransactionIndex
+	}
+	return ""
+}
+
+func (x *Transaction) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *Transaction) GetV() string {
+	if x != nil {
+		return x.V
+	}
+	return ""
+}
+
+func (x *Transaction) GetR() string {
+	if x != nil {
+		return x.R
+	}
+	return ""
+}
+
+func (x *Transaction) GetS() string {
+	if x != nil {
+		return x.S
+	}
+	return ""
+}
+
+func (x *Transaction) GetGasPrice() string {
+	if x != nil {
+		return x.GasPrice
+	}
+	return ""
+}
+
+func (x *Transaction) GetMaxFeePerGas() string {
+	if x != nil {
+		return x.MaxFeePerGas
+	}
+	return ""
+}
+
+func (x *Transaction) GetMaxPriorityFeePerGas() string {
+	if x != nil {
+		return x.MaxPriorityFeePerGas
+	}
+	return ""
+}
+
+func (x *Transaction) GetChainId() string {
+	if x != nil {
+		return x.ChainId
+	}
+	return ""
+}
+
+func (x *Transaction) GetAccessList() []*AccessTuple {
+	if x != nil {
+		return x.AccessList
+	}
+	return nil
+}
+
+func (x *Transaction) GetTimestamp() string {
+	if x != nil {
+		return x.Timestamp
+	}
+	return ""
+}
+
+func (x *Transaction) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Transaction) GetGasUsed() string {
+	if x != nil {
+		return x.GasUsed
+	}
+	return ""
+}
+
+func (x *Transaction) GetEffectiveGasPrice() string {
+	if x != nil {
+		return x.EffectiveGasPrice
+	}
+	return ""
+}
+
+func (x *Transaction) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Transaction) GetLogs() []*Log {
+	if x != nil {
+		return x.Logs
+	}
+	return nil
+}
+
+func (x *Transaction) GetDecoded() *DecodedCall {
+	if x != nil {
+		return x.Decoded
+	}
+	return nil
+}
+
+// Log defines an event log emitted by a transaction
+type Log struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Address         string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Topics          []string               `protobuf:"bytes,2,rep,name=topics,proto3" json:"topics,omitempty"`
+	Data            string                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	BlockNumber     string                 `protobuf:"bytes,4,opt,name=block_number,json=blockNumber,proto3" json:"block_number,omitempty"`
+	BlockHash       string                 `protobuf:"bytes,5,opt,name=block_hash,json=blockHash,proto3" json:"block_hash,omitempty"`
+	TransactionHash string                 `protobuf:"bytes,6,opt,name=transaction_hash,json=transactionHash,proto3" json:"transaction_hash,omitempty"`
+	LogIndex        string                 `protobuf:"bytes,7,opt,name=log_index,json=logIndex,proto3" json:"log_index,omitempty"`
+	Removed         bool                   `protobuf:"varint,8,opt,name=removed,proto3" json:"removed,omitempty"`
+	// event decoded from the topics and data
+	Decoded       *DecodedLog `protobuf:"bytes,9,opt,name=decoded,proto3" json:"decoded,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Log) Reset() {
+	*x = Log{}
+	mi := &file_transactionhistory_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Log) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Log) ProtoMessage() {}
+
+func (x *Log) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Log.ProtoReflect.Descriptor instead.
+func (*Log) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Log) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Log) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+func (x *Log) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *Log) GetBlockNumber() string {
+	if x != nil {
+		return x.BlockNumber
+	}
+	return ""
+}
+
+func (x *Log) GetBlockHash() string {
+	if x != nil {
+		return x.BlockHash
+	}
+	return ""
+}
+
+func (x *Log) GetTransactionHash() string {
+	if x != nil {
+		return x.TransactionHash
+	}
+	return ""
+}
+
+func (x *Log) GetLogIndex() string {
+	if x != nil {
+		return x.LogIndex
+	}
+	return ""
+}
+
+func (x *Log) GetRemoved() bool {
+	if x != nil {
+		return x.Removed
+	}
+	return false
+}
+
+func (x *Log) GetDecoded() *DecodedLog {
+	if x != nil {
+		return x.Decoded
+	}
+	return nil
+}
+
+// DecodedArg defines an argument of a decoded call or event
+type DecodedArg struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type  string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	// decimal string for integers, hex string for addresses and bytes, "true" or "false" for booleans,
+	// empty for arrays and tuples
+	Value string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	// elements of an array or fields of a tuple
+	Components    []*DecodedArg `protobuf:"bytes,4,rep,name=components,proto3" json:"components,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodedArg) Reset() {
+	*x = DecodedArg{}
+	mi := &file_transactionhistory_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodedArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodedArg) ProtoMessage() {}
+
+func (x *DecodedArg) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodedArg.ProtoReflect.Descriptor instead.
+func (*DecodedArg) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DecodedArg) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DecodedArg) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *DecodedArg) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *DecodedArg) GetComponents() []*DecodedArg {
+	if x != nil {
+		return x.Components
+	}
+	return nil
+}
+
+// DecodedCall defines the function called by a transaction input, method is empty when the
+// selector is unknown and error is set when the arguments do not match the known function
+type DecodedCall struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Selector  string                 `protobuf:"bytes,1,opt,name=selector,proto3" json:"selector,omitempty"`
+	Method    string                 `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Signature string                 `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	Args      []*DecodedArg          `protobuf:"bytes,4,rep,name=args,proto3" json:"args,omitempty"`
+	// "abi" or "selector"
+	Source        string `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"`
+	Error         string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodedCall) Reset() {
+	*x = DecodedCall{}
+	mi := &file_transactionhistory_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodedCall) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodedCall) ProtoMessage() {}
+
+func (x *DecodedCall) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodedCall.ProtoReflect.Descriptor instead.
+func (*DecodedCall) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DecodedCall) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *DecodedCall) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *DecodedCall) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *DecodedCall) GetArgs() []*DecodedArg {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *DecodedCall) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *DecodedCall) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// DecodedLog defines the event of a decoded log, event is empty when the topic is unknown and
+// error is set when the topics and data do not match the known event
+type DecodedLog struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Topic     string                 `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
+	Event     string                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	Signature string                 `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	Args      []*DecodedArg          `protobuf:"bytes,4,rep,name=args,proto3" json:"args,omitempty"`
+	// "abi" or "event"
+	Source        string `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"`
+	Error         string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodedLog) Reset() {
+	*x = DecodedLog{}
+	mi := &file_transactionhistory_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodedLog) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodedLog) ProtoMessage() {}
+
+func (x *DecodedLog) ProtoReflect() protoreflect.Message {
+	mi := &file_transactionhistory_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodedLog.ProtoReflect.Descriptor instead.
+func (*DecodedLog) Descriptor() ([]byte, []int) {
+	return file_transactionhistory_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DecodedLog) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *DecodedLog) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *DecodedLog) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *DecodedLog) GetArgs() []*DecodedArg {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *DecodedLog) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *DecodedLog) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_transactionhistory_proto protoreflect.FileDescriptor
+
+const file_transactionhistory_proto_rawDesc = "" +
+	"\n" +
+	"\x18transactionhistory.proto\x12\x12transactionhistory\"V\n" +
+	"\x0eAddressRequest\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x14\n" +
+	"\x05chain\x18\x02 \x01(\tR\x05chain\x12\x14\n" +
+	"\x05label\x18\x03 \x01(\tR\x05label\"-\n" +
+	"\x11SubscribeResponse\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\"/\n" +
+	"\x13UnsubscribeResponse\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\"9\n" +
+	"\x14CurrentBlockResponse\x12!\n" +
+	"\fblock_number\x18\x01 \x01(\x03R\vblockNumber\"J\n" +
+	"\vAccessTuple\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12!\n" +
+	"\fstorage_keys\x18\x02 \x03(\tR\vstorageKeys\"\x9e\x06\n" +
+	"\vTransaction\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x1d\n" +
+	"\n" +
+	"block_hash\x18\x02 \x01(\tR\tblockHash\x12!\n" +
+	"\fblock_number\x18\x03 \x01(\tR\vblockNumber\x12\x12\n" +
+	"\x04from\x18\x04 \x01(\tR\x04from\x12\x10\n" +
+	"\x03gas\x18\x05 \x01(\tR\x03gas\x12\x12\n" +
+	"\x04hash\x18\x06 \x01(\tR\x04hash\x12\x14\n" +
+	"\x05input\x18\a \x01(\tR\x05input\x12\x14\n" +
+	"\x05nonce\x18\b \x01(\tR\x05nonce\x12\x0e\n" +
+	"\x02to\x18\t \x01(\tR\x02to\x12+\n" +
+	"\x11transaction_index\x18\n" +
+	" \x01(\tR\x10transactionIndex\x12\x14\n" +
+	"\x05value\x18\v \x01(\tR\x05value\x12\f\n" +
+	"\x01v\x18\f \x01(\tR\x01v\x12\f\n" +
+	"\x01r\x18\r \x01(\tR\x01r\x12\f\n" +
+	"\x01s\x18\x0e \x01(\tR\x01s\x12\x1b\n" +
+	"\tgas_price\x18\x0f \x01(\tR\bgasPrice\x12%\n" +
+	"\x0fmax_fee_per_gas\x18\x10 \x01(\tR\fmaxFeePerGas\x126\n" +
+	"\x18max_priority_fee_per_gas\x18\x11 \x01(\tR\x14maxPriorityFeePerGas\x12\x19\n" +
+	"\bchain_id\x18\x12 \x01(\tR\achainId\x12@\n" +
+	"\vaccess_list\x18\x13 \x03(\v2\x1f.transactionhistory.AccessTupleR\n" +
+	"accessList\x12\x1c\n" +
+	"\ttimestamp\x18\x14 \x01(\tR\ttimestamp\x12\x16\n" +
+	"\x06status\x18\x15 \x01(\tR\x06status\x12\x19\n" +
+	"\bgas_used\x18\x16 \x01(\tR\agasUsed\x12.\n" +
+	"\x13effective_gas_price\x18\x17 \x01(\tR\x11effectiveGasPrice\x12\x14\n" +
+	"\x05label\x18\x18 \x01(\tR\x05label\x12+\n" +
+	"\x04logs\x18\x19 \x03(\v2\x17.transactionhistory.LogR\x04logs\x129\n" +
+	"\adecoded\x18\x1a \x01(\v2\x1f.transactionhistory.DecodedCallR\adecoded\"\xa9\x02\n" +
+	"\x03Log\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x16\n" +
+	"\x06topics\x18\x02 \x03(\tR\x06topics\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\tR\x04data\x12!\n" +
+	"\fblock_number\x18\x04 \x01(\tR\vblockNumber\x12\x1d\n" +
+	"\n" +
+	"block_hash\x18\x05 \x01(\tR\tblockHash\x12)\n" +
+	"\x10transaction_hash\x18\x06 \x01(\tR\x0ftransactionHash\x12\x1b\n" +
+	"\tlog_index\x18\a \x01(\tR\blogIndex\x12\x18\n" +
+	"\aremoved\x18\b \x01(\bR\aremoved\x128\n" +
+	"\adecoded\x18\t \x01(\v2\x1e.transactionhistory.DecodedLogR\adecoded\"\x8a\x01\n" +
+	"\n" +
+	"DecodedArg\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\tR\x05value\x12>\n" +
+	"\n" +
+	"components\x18\x04 \x03(\v2\x1e.transactionhistory.DecodedArgR\n" +
+	"components\"\xc1\x01\n" +
+	"\vDecodedCall\x12\x1a\n" +
+	"\bselector\x18\x01 \x01(\tR\bselector\x12\x16\n" +
+	"\x06method\x18\x02 \x01(\tR\x06method\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\tR\tsignature\x122\n" +
+	"\x04args\x18\x04 \x03(\v2\x1e.transactionhistory.DecodedArgR\x04args\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\"\xb8\x01\n" +
+	"\n" +
+	"DecodedLog\x12\x14\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\tR\tsignature\x122\n" +
+	"\x04args\x18\x04 \x03(\v2\x1e.transactionhistory.DecodedArgR\x04args\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error2\xdf\x03\n" +
+	"\x12TransactionHistory\x12V\n" +
+	"\tSubscribe\x12\".transactionhistory.AddressRequest\x1a%.transactionhistory.SubscribeResponse\x12Z\n" +
+	"\vUnsubscribe\x12\".transactionhistory.AddressRequest\x1a'.transactionhistory.UnsubscribeResponse\x12_\n" +
+	"\x0fGetCurrentBlock\x12\".transactionhistory.AddressRequest\x1a(.transactionhistory.CurrentBlockResponse\x12X\n" +
+	"\x0fGetTransactions\x12\".transactionhistory.AddressRequest\x1a\x1f.transactionhistory.Transaction0\x01\x12Z\n" +
+	"\x11WatchTransactions\x12\".transactionhistory.AddressRequest\x1a\x1f.transactionhistory.Transaction0\x01B*Z(github.com/tonyxu1/transactionhistory/pbb\x06proto3"
+
+var (
+	file_transactionhistory_proto_rawDescOnce sync.Once
+	file_transactionhistory_proto_rawDescData []byte
+)
+
+func file_transactionhistory_proto_rawDescGZIP() []byte {
+	file_transactionhistory_proto_rawDescOnce.Do(func() {
+		file_transactionhistory_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_transactionhistory_proto_rawDesc), len(file_transactionhistory_proto_rawDesc)))
+	})
+	return file_transactionhistory_proto_rawDescData
+}
+
+var file_transactionhistory_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_transactionhistory_proto_goTypes = []any{
+	(*AddressRequest)(nil),       // 0: transactionhistory.AddressRequest
+	(*SubscribeResponse)(nil),    // 1: transactionhistory.SubscribeResponse
+	(*UnsubscribeResponse)(nil),  // 2: transactionhistory.UnsubscribeResponse
+	(*CurrentBlockResponse)(nil), // 3: transactionhistory.CurrentBlockResponse
+	(*AccessTuple)(nil),          // 4: transactionhistory.AccessTuple
+	(*Transaction)(nil),          // 5: transactionhistory.Transaction
+	(*Log)(nil),                  // 6: transactionhistory.Log
+	(*DecodedArg)(nil),           // 7: transactionhistory.DecodedArg
+	(*DecodedCall)(nil),          // 8: transactionhistory.DecodedCall
+	(*DecodedLog)(nil),           // 9: transactionhistory.DecodedLog
+}
+var file_transactionhistory_proto_depIdxs = []int32{
+	4,  // 0: transactionhistory.Transaction.access_list:type_name -> transactionhistory.AccessTuple
+	6,  // 1: transactionhistory.Transaction.logs:type_name -> transactionhistory.Log
+	8,  // 2: transactionhistory.Transaction.decoded:type_name -> transactionhistory.DecodedCall
+	9,  // 3: transactionhistory.Log.decoded:type_name -> transactionhistory.DecodedLog
+	7,  // 4: transactionhistory.DecodedArg.components:type_name -> transactionhistory.DecodedArg
+	7,  // 5: transactionhistory.DecodedCall.args:type_name -> transactionhistory.DecodedArg
+	7,  // 6: transactionhistory.DecodedLog.args:type_name -> transactionhistory.DecodedArg
+	0,  // 7: transactionhistory.TransactionHistory.Subscribe:input_type -> transactionhistory.AddressRequest
+	0,  // 8: transactionhistory.TransactionHistory.Unsubscribe:input_type -> transactionhistory.AddressRequest
+	0,  // 9: transactionhistory.TransactionHistory.GetCurrentBlock:input_type -> transactionhistory.AddressRequest
+	0,  // 10: transactionhistory.TransactionHistory.GetTransactions:input_type -> transactionhistory.AddressRequest
+	0,  // 11: transactionhistory.TransactionHistory.WatchTransactions:input_type -> transactionhistory.AddressRequest
+	1,  // 12: transactionhistory.TransactionHistory.Subscribe:output_type -> transactionhistory.SubscribeResponse
+	2,  // 13: transactionhistory.TransactionHistory.Unsubscribe:output_type -> transactionhistory.UnsubscribeResponse
+	3,  // 14: transactionhistory.TransactionHistory.GetCurrentBlock:output_type -> transactionhistory.CurrentBlockResponse
+	5,  // 15: transactionhistory.TransactionHistory.GetTransactions:output_type -> transactionhistory.Transaction
+	5,  // 16: transactionhistory.TransactionHistory.WatchTransactions:output_type -> transactionhistory.Transaction
+	12, // [12:17] is the sub-list for method output_type
+	7,  // [7:12] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_transactionhistory_proto_init() }
+func file_transactionhistory_proto_init() {
+	if File_transactionhistory_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_transactionhistory_proto_rawDesc), len(file_transactionhistory_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_transactionhistory_proto_goTypes,
+		DependencyIndexes: file_transactionhistory_proto_depIdxs,
+		MessageInfos:      file_transactionhistory_proto_msgTypes,
+	}.Build()
+	File_transactionhistory_proto = out.File
+	file_transactionhistory_proto_goTypes = nil
+	file_transactionhistory_proto_depIdxs = nil
+}
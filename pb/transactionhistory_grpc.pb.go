@@ -0,0 +1,294 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v4.25.0
+// source: transactionhistory.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TransactionHistory_Subscribe_FullMethodName         = "/transactionhistory.TransactionHistory/Subscribe"
+	TransactionHistory_Unsubscribe_FullMethodName       = "/transactionhistory.TransactionHistory/Unsubscribe"
+	TransactionHistory_GetCurrentBlock_FullMethodName   = "/transactionhistory.TransactionHistory/GetCurrentBlock"
+	TransactionHistory_GetTransactions_FullMethodName   = "/transactionhistory.TransactionHistory/GetTransactions"
+	TransactionHistory_WatchTransactions_FullMethodName = "/transactionhistory.TransactionHistory/WatchTransactions"
+)
+
+// TransactionHistoryClient is the client API for TransactionHistory service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TransactionHistory mirrors the public HTTP endpoints
+type TransactionHistoryClient interface {
+	// Register the given address to the system
+	Subscribe(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
+	// Remove the given address and its transactions from the system
+	Unsubscribe(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*UnsubscribeResponse, error)
+	// Get the current block number saved in storage for the given address
+	GetCurrentBlock(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*CurrentBlockResponse, error)
+	// Stream the transaction history of the given address, ordered by block number descending
+	GetTransactions(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Transaction], error)
+	// Stream the transactions saved for the given address from now on
+	WatchTransactions(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Transaction], error)
+}
+
+type transactionHistoryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTransactionHistoryClient(cc grpc.ClientConnInterface) TransactionHistoryClient {
+	return &transactionHistoryClient{cc}
+}
+
+func (c *transactionHistoryClient) Subscribe(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*SubscribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubscribeResponse)
+	err := c.cc.Invoke(ctx, TransactionHistory_Subscribe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transactionHistoryClient) Unsubscribe(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*UnsubscribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnsubscribeResponse)
+	err := c.cc.Invoke(ctx, TransactionHistory_Unsubscribe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transactionHistoryClient) GetCurrentBlock(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (*CurrentBlockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CurrentBlockResponse)
+	err := c.cc.Invoke(ctx, TransactionHistory_GetCurrentBlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transactionHistoryClient) GetTransactions(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Transaction], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TransactionHistory_ServiceDesc.Streams[0], TransactionHistory_GetTransactions_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AddressRequest, Transaction]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransactionHistory_GetTransactionsClient = grpc.ServerStreamingClient[Transaction]
+
+func (c *transactionHistoryClient) WatchTransactions(ctx context.Context, in *AddressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Transaction], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TransactionHistory_ServiceDesc.Streams[1], TransactionHistory_WatchTransactions_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AddressRequest, Transaction]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransactionHistory_WatchTransactionsClient = grpc.ServerStreamingClient[Transaction]
+
+// TransactionHistoryServer is the server API for TransactionHistory service.
+// All implementations must embed UnimplementedTransactionHistoryServer
+// for forward compatibility.
+//
+// TransactionHistory mirrors the public HTTP endpoints
+type TransactionHistoryServer interface {
+	// Register the given address to the system
+	Subscribe(context.Context, *AddressRequest) (*SubscribeResponse, error)
+	// Remove the given address and its transactions from the system
+	Unsubscribe(context.Context, *AddressRequest) (*UnsubscribeResponse, error)
+	// Get the current block number saved in storage for the given address
+	GetCurrentBlock(context.Context, *AddressRequest) (*CurrentBlockResponse, error)
+	// Stream the transaction history of the given address, ordered by block number descending
+	GetTransactions(*AddressRequest, grpc.ServerStreamingServer[Transaction]) error
+	// Stream the transactions saved for the given address from now on
+	WatchTransactions(*AddressRequest, grpc.ServerStreamingServer[Transaction]) error
+	mustEmbedUnimplementedTransactionHistoryServer()
+}
+
+// UnimplementedTransactionHistoryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTransactionHistoryServer struct{}
+
+func (UnimplementedTransactionHistoryServer) Subscribe(context.Context, *AddressRequest) (*SubscribeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedTransactionHistoryServer) Unsubscribe(context.Context, *AddressRequest) (*UnsubscribeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Unsubscribe not implemented")
+}
+func (UnimplementedTransactionHistoryServer) GetCurrentBlock(context.Context, *AddressRequest) (*CurrentBlockResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetCurrentBlock not implemented")
+}
+func (UnimplementedTransactionHistoryServer) GetTransactions(*AddressRequest, grpc.ServerStreamingServer[Transaction]) error {
+	return status.Error(codes.Unimplemented, "method GetTransactions not implemented")
+}
+func (UnimplementedTransactionHistoryServer) WatchTransactions(*AddressRequest, grpc.ServerStreamingServer[Transaction]) error {
+	return status.Error(codes.Unimplemented, "method WatchTransactions not implemented")
+}
+func (UnimplementedTransactionHistoryServer) mustEmbedUnimplementedTransactionHistoryServer() {}
+func (UnimplementedTransactionHistoryServer) testEmbeddedByValue()                            {}
+
+// UnsafeTransactionHistoryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TransactionHistoryServer will
+// result in compilation errors.
+type UnsafeTransactionHistoryServer interface {
+	mustEmbedUnimplementedTransactionHistoryServer()
+}
+
+func RegisterTransactionHistoryServer(s grpc.ServiceRegistrar, srv TransactionHistoryServer) {
+	// If the following call panics, it indicates UnimplementedTransactionHistoryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TransactionHistory_ServiceDesc, srv)
+}
+
+func _TransactionHistory_Subscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransactionHistoryServer).Subscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransactionHistory_Subscribe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransactionHistoryServer).Subscribe(ctx, req.(*AddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransactionHistory_Unsubscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransactionHistoryServer).Unsubscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransactionHistory_Unsubscribe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransactionHistoryServer).Unsubscribe(ctx, req.(*AddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransactionHistory_GetCurrentBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransactionHistoryServer).GetCurrentBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransactionHistory_GetCurrentBlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransactionHistoryServer).GetCurrentBlock(ctx, req.(*AddressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransactionHistory_GetTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AddressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TransactionHistoryServer).GetTransactions(m, &grpc.GenericServerStream[AddressRequest, Transaction]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransactionHistory_GetTransactionsServer = grpc.ServerStreamingServer[Transaction]
+
+func _TransactionHistory_WatchTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AddressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TransactionHistoryServer).WatchTransactions(m, &grpc.GenericServerStream[AddressRequest, Transaction]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransactionHistory_WatchTransactionsServer = grpc.ServerStreamingServer[Transaction]
+
+// TransactionHistory_ServiceDesc is the grpc.ServiceDesc for TransactionHistory service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TransactionHistory_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "transactionhistory.TransactionHistory",
+	HandlerType: (*TransactionHistoryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Subscribe",
+			Handler:    _TransactionHistory_Subscribe_Handler,
+		},
+		{
+			MethodName: "Unsubscribe",
+			Handler:    _TransactionHistory_Unsubscribe_Handler,
+		},
+		{
+			MethodName: "GetCurrentBlock",
+			Handler:    _TransactionHistory_GetCurrentBlock_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetTransactions",
+			Handler:       _TransactionHistory_GetTransactions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchTransactions",
+			Handler:       _TransactionHistory_WatchTransactions_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "transactionhistory.proto",
+}
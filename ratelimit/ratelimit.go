@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	auth "github.com/tonyxu1/transactionhistory/auth"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Idle buckets are evicted after this period
+const idleTimeout = 10 * time.Minute
+
+// Limit defines a token bucket refilled with Rate tokens per second holding up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter keeps a token bucket per client and route, clients are identified
+// by their API key or by their IP address when no key is present
+type Limiter struct {
+	mu        sync.Mutex
+	def       Limit
+	routes    map[string]Limit
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter applying def to the routes without their own limit
+func New(def Limit, routes map[string]Limit) *Limiter {
+	return &Limiter{
+		def:       def,
+		routes:    routes,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve takes a token for the client on the route, the returned duration
+// is zero when allowed, otherwise the time to wait before retrying
+func (l *Limiter) Reserve(route, client string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > idleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	k := route + "|" + client
+	b, ok := l.buckets[k]
+	if !ok {
+		limit, ok := l.routes[route]
+		if !ok {
+			limit = l.def
+		}
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[k] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return idleTimeout
+	}
+	delay := r.DelayFrom(now)
+	if delay > 0 {
+		// the token is not consumed by a rejected request
+		r.CancelAt(now)
+	}
+	return delay
+}
+
+// Middleware rejects the requests over the limit of the route with 429 and Retry-After
+func (l *Limiter) Middleware(route string, next http.Handler) http.Handler {
+	return l.middleware(route, next, func(r *http.Request) string { return clientID(r.Context(), r.RemoteAddr) })
+}
+
+// IPMiddleware rejects the requests over the limit of the route by IP address, whatever their
+// API key, with 429 and Retry-After. It runs before the authentication so that the requests
+// with an invalid key are limited too.
+func (l *Limiter) IPMiddleware(route string, next http.Handler) http.Handler {
+	return l.middleware(route, next, func(r *http.Request) string { return ipID(r.RemoteAddr) })
+}
+
+func (l *Limiter) middleware(route string, next http.Handler, client func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := l.Reserve(route, client(r))
+		if delay > 0 {
+			metrics.ThrottledRequests.WithLabelValues(route).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryServerInterceptor rejects the gRPC calls over the limit of the method with ResourceExhausted,
+// it must run after the authentication interceptor to key the bucket by API key
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.reserveGRPC(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the gRPC streams opened over the limit of the method with
+// ResourceExhausted, it must run after the authentication interceptor as UnaryServerInterceptor
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.reserveGRPC(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// reserveGRPC takes a token for the client of the call on the method, ResourceExhausted is returned
+// when the call is over the limit
+func (l *Limiter) reserveGRPC(ctx context.Context, method string) error {
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	delay := l.Reserve(method, clientID(ctx, addr))
+	if delay > 0 {
+		metrics.ThrottledRequests.WithLabelValues(method).Inc()
+		return status.Errorf(codes.ResourceExhausted, "too many requests, retry after %s", delay.Round(time.Second))
+	}
+	return nil
+}
+
+// clientID returns the API key carried by ctx or the IP address of the client
+func clientID(ctx context.Context, remoteAddr string) string {
+	if token, ok := auth.KeyFromContext(ctx); ok {
+		return "key:" + token
+	}
+	return ipID(remoteAddr)
+}
+
+// ipID returns the IP address of the client
+func ipID(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
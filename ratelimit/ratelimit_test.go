@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	auth "github.com/tonyxu1/transactionhistory/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiter_Middleware(t *testing.T) {
+	type request struct {
+		route      string
+		apiKey     string
+		remoteAddr string
+	}
+	tests := []struct {
+		name     string
+		byIP     bool
+		requests []request
+		want     []int
+	}{
+		{
+			name: "Requests over burst are throttled",
+			requests: []request{
+				{route: "/subscribe", remoteAddr: "10.0.0.1:1234"},
+				{route: "/subscribe", remoteAddr: "10.0.0.1:1235"},
+			},
+			want: []int{http.StatusOK, http.StatusTooManyRequests},
+		}, {
+			name: "Routes have separate limits",
+			requests: []request{
+				{route: "/subscribe", remoteAddr: "10.0.0.1:1234"},
+				{route: "/transaction", remoteAddr: "10.0.0.1:1234"},
+				{route: "/transaction", remoteAddr: "10.0.0.1:1234"},
+			},
+			want: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		}, {
+			name: "Clients are keyed by api key before ip",
+			requests: []request{
+				{route: "/subscribe", apiKey: "key1", remoteAddr: "10.0.0.1:1234"},
+				{route: "/subscribe", apiKey: "key2", remoteAddr: "10.0.0.1:1234"},
+				{route: "/subscribe", apiKey: "key1", remoteAddr: "10.0.0.2:1234"},
+			},
+			want: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		}, {
+			name: "Clients are keyed by ip whatever the api key before authentication",
+			byIP: true,
+			requests: []request{
+				{route: "/subscribe", apiKey: "key1", remoteAddr: "10.0.0.1:1234"},
+				{route: "/subscribe", apiKey: "key2", remoteAddr: "10.0.0.1:1235"},
+				{route: "/subscribe", remoteAddr: "10.0.0.2:1234"},
+			},
+			want: []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(Limit{Rate: 10, Burst: 2}, map[string]Limit{"/subscribe": {Rate: 0.01, Burst: 1}})
+			for i, req := range tt.requests {
+				next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+				h := l.Middleware(req.route, next)
+				if tt.byIP {
+					h = l.IPMiddleware(req.route, next)
+				}
+				r := httptest.NewRequest(http.MethodGet, req.route, nil)
+				r.RemoteAddr = req.remoteAddr
+				if req.apiKey != "" {
+					r = r.WithContext(auth.WithKey(r.Context(), req.apiKey))
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.want[i] {
+					t.Errorf("request %d status = %d, want %d", i, w.Code, tt.want[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d Retry-After header is missing", i)
+				}
+			}
+		})
+	}
+}
+
+// serverStream is a grpc.ServerStream carrying the context of the call
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestLimiter_StreamServerInterceptor(t *testing.T) {
+	const method = "/transactionhistory.TransactionHistory/WatchTransactions"
+	tests := []struct {
+		name   string
+		apiKey string
+		want   codes.Code
+	}{
+		{name: "Stream within burst", apiKey: "key1", want: codes.OK},
+		{name: "Stream over burst", apiKey: "key1", want: codes.ResourceExhausted},
+		{name: "Other api key", apiKey: "key2", want: codes.OK},
+	}
+	l := New(Limit{Rate: 0.01, Burst: 1}, nil)
+	interceptor := l.StreamServerInterceptor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(auth.WithKey(context.Background(), tt.apiKey), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+			called := false
+			err := interceptor(nil, &serverStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: method}, func(srv interface{}, ss grpc.ServerStream) error {
+				called = true
+				return nil
+			})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("StreamServerInterceptor() code = %v, want %v", got, tt.want)
+			}
+			if called != (tt.want == codes.OK) {
+				t.Errorf("StreamServerInterceptor() called handler = %v", called)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	archive "github.com/tonyxu1/transactionhistory/archive"
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+// Compactor prunes the transactions expired by the retention policy of each account
+type Compactor struct {
+	Storage common.Storage
+	// ArchivePath is the cold storage file the pruned transactions are appended to
+	// before being removed, they are dropped when empty
+	ArchivePath string
+	// Pruned is called with the highest block of the transactions pruned from an account, when set,
+	// to reanchor its balance
+	Pruned func(ctx context.Context, address string, block int) error
+	Now    func() time.Time
+}
+
+// New returns a compactor of the storage archiving to archivePath when not empty
+func New(s common.Storage, archivePath string) *Compactor {
+	return &Compactor{Storage: s, ArchivePath: archivePath, Now: time.Now}
+}
+
+// Expired returns the transactions pruned by the policy, trans are ordered by block number
+// descending as stored and checkpoint is the first block not scanned for the account.
+// Transactions without timestamp never expire by age. Transactions without hash cannot be
+// removed from the storage, they never expire so that they are not archived on every run.
+func Expired(policy common.RetentionPolicy, trans []common.Transaction, checkpoint int, now time.Time) []common.Transaction {
+	if policy == (common.RetentionPolicy{}) {
+		return nil
+	}
+	cutoff := now.Add(-time.Duration(policy.Days) * 24 * time.Hour).Unix()
+
+	expired := []common.Transaction{}
+	for i, tr := range trans {
+		switch {
+		case tr.Hash == "":
+			continue
+		case policy.Transactions > 0 && i >= policy.Transactions:
+		case policy.Blocks > 0 && blockOf(tr) < int64(checkpoint-policy.Blocks):
+		case policy.Days > 0 && timestampOf(tr) >= 0 && timestampOf(tr) < cutoff:
+		default:
+			continue
+		}
+		expired = append(expired, tr)
+	}
+	return expired
+}
+
+// blockOf returns the block number of the transaction, -1 when invalid
+func blockOf(tr common.Transaction) int64 {
+	n, err := hexutil.DecodeUint64(tr.BlockNumber)
+	if err != nil {
+		return -1
+	}
+	return int64(n)
+}
+
+// timestampOf returns the block timestamp of the transaction, -1 when unknown
+func timestampOf(tr common.Transaction) int64 {
+	n, err := hexutil.DecodeUint64(tr.Timestamp)
+	if err != nil {
+		return -1
+	}
+	return int64(n)
+}
+
+// Compact prunes the expired transactions of all the accounts and returns the number
+// removed per address. The transactions of an account are only removed once archived,
+// an account failing is skipped and reported in the joined error.
+func (c *Compactor) Compact(ctx context.Context) (map[string]int, error) {
+	logger := logging.FromContext(ctx)
+	addresses, err := c.Storage.GetAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := make(map[string]int)
+	var errs []error
+	for _, address := range addresses {
+		if ctx.Err() != nil {
+			return pruned, ctx.Err()
+		}
+		removed, err := c.compactAccount(ctx, address)
+		if err != nil {
+			logger.Warn("compaction failed", logging.AddressKey, address, logging.ErrorKey, err)
+			errs = append(errs, fmt.Errorf("%s: %w", address, err))
+			continue
+		}
+		if removed > 0 {
+			pruned[address] = removed
+			metrics.PrunedTransactions.Add(float64(removed))
+			logger.Info("expired transactions pruned", logging.AddressKey, address, "count", removed)
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
+
+func (c *Compactor) compactAccount(ctx context.Context, address string) (int, error) {
+	policy, err := c.Storage.GetRetention(ctx, address)
+	if err != nil || policy == (common.RetentionPolicy{}) {
+		// the account was removed meanwhile or keeps everything
+		return 0, nil
+	}
+	checkpoint, err := c.Storage.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return 0, nil
+	}
+	trans, err := c.Storage.GetTransactions(ctx, address)
+	if err != nil {
+		return 0, nil
+	}
+
+	expired := Expired(policy, trans, checkpoint, c.Now())
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if c.ArchivePath != "" {
+		account := archive.Entry{Type: archive.ACCOUNT, Address: address, Checkpoint: checkpoint}
+		if err := c.archiveExpired(account, expired); err != nil {
+			return 0, err
+		}
+	}
+	removed, err := c.Storage.RemoveTransactions(ctx, address, expired)
+	if err != nil || removed == 0 || c.Pruned == nil {
+		return removed, err
+	}
+	highest := int64(-1)
+	for _, tr := range expired {
+		if block := blockOf(tr); block > highest {
+			highest = block
+		}
+	}
+	if err := c.Pruned(ctx, address, int(highest)); err != nil {
+		logging.FromContext(ctx).Warn("balance reanchoring failed", logging.AddressKey, address, logging.ErrorKey, err)
+	}
+	return removed, nil
+}
+
+// archiveExpired appends the account and its expired transactions to the cold storage file,
+// which can be loaded back with archive.Import
+func (c *Compactor) archiveExpired(account archive.Entry, expired []common.Transaction) error {
+	f, err := os.OpenFile(c.ArchivePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := archive.WriteAccount(f, account, expired); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,210 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	archive "github.com/tonyxu1/transactionhistory/archive"
+	common "github.com/tonyxu1/transactionhistory/common"
+	storage "github.com/tonyxu1/transactionhistory/storage"
+)
+
+const testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+
+// 2023-11-15T00:00:00Z
+var now = time.Unix(0x65540a00, 0)
+
+// history returns transactions of blocks 0x64 down to 0x60, one per day back from now,
+// the oldest one has no timestamp
+func history() []common.Transaction {
+	return []common.Transaction{
+		{BlockHash: "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139404", BlockNumber: "0x64", Hash: "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139404", To: testAddress, Timestamp: "0x65540a00"},
+		{BlockHash: "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139403", BlockNumber: "0x63", Hash: "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139403", To: testAddress, Timestamp: "0x6552b880"},
+		{BlockHash: "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139402", BlockNumber: "0x62", Hash: "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139402", To: testAddress, Timestamp: "0x65516700"},
+		{BlockHash: "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139401", BlockNumber: "0x61", Hash: "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139401", To: testAddress, Timestamp: "0x65501580"},
+		{BlockHash: "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139400", BlockNumber: "0x60", Hash: "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a7139400", To: testAddress},
+	}
+}
+
+func blocksOf(trans []common.Transaction) []string {
+	blocks := make([]string, 0, len(trans))
+	for _, tr := range trans {
+		blocks = append(blocks, tr.BlockNumber)
+	}
+	return blocks
+}
+
+func TestExpired(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy common.RetentionPolicy
+		want   []string
+	}{
+		{
+			name:   "Zero policy keeps everything",
+			policy: common.RetentionPolicy{},
+			want:   []string{},
+		}, {
+			name:   "Last transactions kept",
+			policy: common.RetentionPolicy{Transactions: 2},
+			want:   []string{"0x62", "0x61", "0x60"},
+		}, {
+			name:   "Last blocks behind the checkpoint kept",
+			policy: common.RetentionPolicy{Blocks: 4},
+			want:   []string{"0x61", "0x60"},
+		}, {
+			name:   "Last days kept, unknown timestamp never expires by age",
+			policy: common.RetentionPolicy{Days: 2},
+			want:   []string{"0x61"},
+		}, {
+			name:   "Limits combined",
+			policy: common.RetentionPolicy{Days: 2, Transactions: 4},
+			want:   []string{"0x61", "0x60"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Expired(tt.policy, history(), 0x66, now)
+			if !reflect.DeepEqual(blocksOf(got), tt.want) {
+				t.Errorf("Expired() = %v, want %v", blocksOf(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactor_Compact(t *testing.T) {
+	tests := []struct {
+		name        string
+		archive     bool
+		want        map[string]int
+		wantBlocks  []string
+		wantPruned  map[string]int
+		wantArchive archive.Stats
+	}{
+		{
+			name:       "Expired transactions dropped",
+			want:       map[string]int{testAddress: 3},
+			wantBlocks: []string{"0x64", "0x63"},
+			wantPruned: map[string]int{testAddress: 0x62},
+		}, {
+			name:        "Expired transactions archived before removal",
+			archive:     true,
+			want:        map[string]int{testAddress: 3},
+			wantBlocks:  []string{"0x64", "0x63"},
+			wantPruned:  map[string]int{testAddress: 0x62},
+			wantArchive: archive.Stats{Accounts: 1, Transactions: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := &storage.Storage{}
+			if err := s.RestoreAccount(ctx, testAddress, 0x66); err != nil {
+				t.Fatalf("Storage.RestoreAccount() error = %v", err)
+			}
+			if err := s.SaveTransactions(ctx, testAddress, history()); err != nil {
+				t.Fatalf("Storage.SaveTransactions() error = %v", err)
+			}
+			if err := s.SetRetention(ctx, testAddress, common.RetentionPolicy{Transactions: 2}); err != nil {
+				t.Fatalf("Storage.SetRetention() error = %v", err)
+			}
+
+			path := ""
+			if tt.archive {
+				path = filepath.Join(t.TempDir(), "cold.jsonl")
+			}
+			c := New(s, path)
+			c.Now = func() time.Time { return now }
+			pruned := make(map[string]int)
+			c.Pruned = func(ctx context.Context, address string, block int) error {
+				pruned[address] = block
+				return nil
+			}
+			got, err := c.Compact(ctx)
+			if err != nil {
+				t.Fatalf("Compactor.Compact() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compactor.Compact() = %v, want %v", got, tt.want)
+			}
+			trans, _ := s.GetTransactions(ctx, testAddress)
+			if !reflect.DeepEqual(blocksOf(trans), tt.wantBlocks) {
+				t.Errorf("Storage.GetTransactions() = %v, want %v", blocksOf(trans), tt.wantBlocks)
+			}
+			if !reflect.DeepEqual(pruned, tt.wantPruned) {
+				t.Errorf("Compactor.Pruned() blocks = %v, want %v", pruned, tt.wantPruned)
+			}
+			if !tt.archive {
+				return
+			}
+
+			// the cold storage file loads back into a fresh storage
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read archive error = %v", err)
+			}
+			stats, err := archive.Import(ctx, bytes.NewReader(data), &storage.Storage{})
+			if err != nil {
+				t.Fatalf("archive.Import() error = %v", err)
+			}
+			if stats != tt.wantArchive {
+				t.Errorf("archive.Import() = %+v, want %+v", stats, tt.wantArchive)
+			}
+		})
+	}
+}
+
+func TestCompactor_Compact_Twice(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.Storage{}
+	if err := s.RestoreAccount(ctx, testAddress, 0x66); err != nil {
+		t.Fatalf("Storage.RestoreAccount() error = %v", err)
+	}
+	// the oldest transaction has no hash and cannot be removed
+	trans := append(history(), common.Transaction{BlockHash: "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a71393ff", BlockNumber: "0x5f", To: testAddress})
+	if err := s.SaveTransactions(ctx, testAddress, trans); err != nil {
+		t.Fatalf("Storage.SaveTransactions() error = %v", err)
+	}
+	if err := s.SetRetention(ctx, testAddress, common.RetentionPolicy{Transactions: 2}); err != nil {
+		t.Fatalf("Storage.SetRetention() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cold.jsonl")
+	c := New(s, path)
+	c.Now = func() time.Time { return now }
+	wants := []map[string]int{{testAddress: 3}, {}}
+	for run, want := range wants {
+		got, err := c.Compact(ctx)
+		if err != nil {
+			t.Fatalf("Compactor.Compact() run %d error = %v", run+1, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Compactor.Compact() run %d = %v, want %v", run+1, got, want)
+		}
+	}
+	stored, _ := s.GetTransactions(ctx, testAddress)
+	if want := []string{"0x64", "0x63", "0x5f"}; !reflect.DeepEqual(blocksOf(stored), want) {
+		t.Errorf("Storage.GetTransactions() = %v, want %v", blocksOf(stored), want)
+	}
+
+	// each pruned transaction is archived once
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read archive error = %v", err)
+	}
+	stats, err := archive.Import(ctx, bytes.NewReader(data), &storage.Storage{})
+	if err != nil {
+		t.Fatalf("archive.Import() error = %v", err)
+	}
+	if want := (archive.Stats{Accounts: 1, Transactions: 3}); stats != want {
+		t.Errorf("archive.Import() = %+v, want %+v", stats, want)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 4 {
+		t.Errorf("archive lines = %d, want 4", n)
+	}
+}
@@ -0,0 +1,122 @@
+package scanner
+
+import (
+	"sync"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// Bounds defines the range of the worker count and batch size chosen by the controller
+type Bounds struct {
+	MinWorkers int
+	MaxWorkers int
+	MinBatch   int
+	MaxBatch   int
+}
+
+// Controller chooses the worker count and batch size of the rounds in AIMD style:
+// both grow additively after a healthy round and are halved after a round with
+// throttled or timed out requests, or a high error rate
+type Controller struct {
+	mu      sync.Mutex
+	chain   string
+	bounds  Bounds
+	workers int
+	batch   int
+
+	// statistics of the requests since the last adjustment
+	requests  int
+	failures  int
+	throttled int
+	timeouts  int
+	latency   time.Duration
+}
+
+// NewController creates a controller of the network starting from the given worker count and batch size
+func NewController(chain string, bounds Bounds, workers, batch int) *Controller {
+	c := &Controller{chain: chain, bounds: bounds}
+	c.workers = clamp(workers, bounds.MinWorkers, bounds.MaxWorkers)
+	c.batch = clamp(batch, bounds.MinBatch, bounds.MaxBatch)
+	c.report()
+	return c
+}
+
+// Limits returns the worker count and batch size for the next round
+func (c *Controller) Limits() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.workers, c.batch
+}
+
+// Record records the outcome of a request to the Json RPC endpoint
+func (c *Controller) Record(latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests++
+	c.latency += latency
+	if err == nil {
+		return
+	}
+	c.failures++
+	if util.IsThrottled(err) {
+		c.throttled++
+	} else if util.IsTimeout(err) {
+		c.timeouts++
+	}
+}
+
+// Adjust updates the worker count and batch size from the requests recorded since
+// the last adjustment, nothing changes when no request was recorded
+func (c *Controller) Adjust() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.requests == 0 {
+		return
+	}
+	errorRate := float64(c.failures) / float64(c.requests)
+	avgLatency := c.latency / time.Duration(c.requests)
+
+	switch {
+	case c.throttled > 0:
+		c.decrease("throttled")
+	case c.timeouts > 0:
+		c.decrease("timeout")
+	case errorRate > common.ADAPTIVEMAXERRORRATE:
+		c.decrease("errors")
+	case avgLatency > common.ADAPTIVETARGETLATENCY:
+		c.decrease("latency")
+	default:
+		c.workers = clamp(c.workers+1, c.bounds.MinWorkers, c.bounds.MaxWorkers)
+		c.batch = clamp(c.batch+common.ADAPTIVEBATCHSTEP, c.bounds.MinBatch, c.bounds.MaxBatch)
+	}
+
+	c.requests, c.failures, c.throttled, c.timeouts, c.latency = 0, 0, 0, 0, 0
+	c.report()
+}
+
+// decrease halves the worker count and batch size
+func (c *Controller) decrease(reason string) {
+	c.workers = clamp(c.workers/2, c.bounds.MinWorkers, c.bounds.MaxWorkers)
+	c.batch = clamp(c.batch/2, c.bounds.MinBatch, c.bounds.MaxBatch)
+	metrics.ScannerBackoffs.WithLabelValues(c.chain, reason).Inc()
+}
+
+func (c *Controller) report() {
+	metrics.ScannerWorkers.WithLabelValues(c.chain).Set(float64(c.workers))
+	metrics.ScannerBatchSize.WithLabelValues(c.chain).Set(float64(c.batch))
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,431 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	token "github.com/tonyxu1/transactionhistory/token"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// Chain defines the chain data used by the scanner
+type Chain interface {
+	// most recent block number of the chain
+	GetChainHead(ctx context.Context) (int, error)
+
+	// block with its transactions
+	GetBlockByNumber(ctx context.Context, number int) (common.Block, error)
+
+	// receipt of an executed transaction
+	GetTransactionReceipt(ctx context.Context, hash string) (common.Receipt, error)
+
+	// logs matching the filter
+	GetLogs(ctx context.Context, filter common.LogFilter) ([]common.Log, error)
+}
+
+// rpcChain reads the chain through the Json RPC endpoint
+type rpcChain struct{}
+
+func (rpcChain) GetChainHead(ctx context.Context) (int, error) {
+	return util.GetChainHead(ctx)
+}
+
+func (rpcChain) GetBlockByNumber(ctx context.Context, number int) (common.Block, error) {
+	return util.GetBlockByNumber(ctx, number)
+}
+
+func (rpcChain) GetTransactionReceipt(ctx context.Context, hash string) (common.Receipt, error) {
+	return util.GetTransactionReceipt(ctx, hash)
+}
+
+func (rpcChain) GetLogs(ctx context.Context, filter common.LogFilter) ([]common.Log, error) {
+	return util.GetLogs(ctx, filter)
+}
+
+// Mode is the scanning mode of an account
+type Mode string
+
+const (
+	// CatchUp is the mode of an account far behind chain head, scanned without idle period
+	CatchUp Mode = "catch-up"
+
+	// Following is the mode of an account within CATCHUPTHRESHOLD blocks of chain head
+	Following Mode = "following"
+)
+
+// Scanner retrieves the transactions of the subscribed accounts from chain
+// and advances their checkpoints in storage
+type Scanner struct {
+	Storage common.Storage
+	Chain   Chain
+
+	// number of workers scanning a round concurrently
+	Workers int
+
+	// number of blocks scanned per account in a round
+	BlocksPerRound int
+
+	// Controller chooses Workers and BlocksPerRound of each round when set
+	Controller *Controller
+
+	// number of blocks behind chain head left for the next rounds, as they may be reorganized
+	Confirmations int
+
+	// maximum number of blocks of an eth_getLogs request, the token transfers of a round are
+	// read in a single request when 0
+	LogsRange int
+
+	mu    sync.Mutex
+	modes map[string]Mode
+}
+
+// New creates a scanner of the network reading the chain through the Json RPC endpoint,
+// its concurrency is chosen by an adaptive controller within the configured bounds
+func New(chain string, s common.Storage) *Scanner {
+	return &Scanner{
+		Storage:        s,
+		Chain:          rpcChain{},
+		Workers:        common.NUMOFROUTINES,
+		BlocksPerRound: common.BLOCKSPERROUND,
+		LogsRange:      common.MAXLOGSRANGE,
+		Controller: NewController(chain, Bounds{
+			MinWorkers: common.MINROUTINES,
+			MaxWorkers: common.MAXROUTINES,
+			MinBatch:   common.MINBLOCKSPERROUND,
+			MaxBatch:   common.MAXBLOCKSPERROUND,
+		}, common.NUMOFROUTINES, common.BLOCKSPERROUND),
+	}
+}
+
+// limits returns the worker count and batch size of the next round
+func (sc *Scanner) limits() (int, int) {
+	if sc.Controller != nil {
+		return sc.Controller.Limits()
+	}
+	return sc.Workers, sc.BlocksPerRound
+}
+
+// blockResult holds the outcome of scanning a block
+type blockResult struct {
+	done         bool
+	err          error
+	transactions []common.Transaction
+	header       common.Header
+}
+
+// ScanAll runs a round for all accounts up to Confirmations blocks behind chain head, it returns
+// the chain head fetched for the round, -1 when it cannot be fetched, and the errors of the round
+func (sc *Scanner) ScanAll(ctx context.Context) (int, error) {
+	l := logging.FromContext(ctx)
+	head, err := sc.Chain.GetChainHead(ctx)
+	if err != nil {
+		l.Error("get chain head failed", logging.RPCMethodKey, "eth_blockNumber", logging.ErrorKey, err)
+		return -1, err
+	}
+	chain := util.NetworkFromContext(ctx).Name
+
+	addresses, err := sc.Storage.GetAccounts(ctx)
+	if err != nil {
+		return head, err
+	}
+
+	errs := make([]error, 0)
+	maxLag := 0
+	for _, address := range addresses {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		err := sc.ScanAccount(ctx, address, head-sc.Confirmations)
+		if sc.Controller != nil {
+			sc.Controller.Adjust()
+		}
+		if err != nil {
+			l.Error("update account failed", logging.AddressKey, address, logging.ErrorKey, err)
+			errs = append(errs, fmt.Errorf("address [%s]: %w", address, err))
+		}
+		if blockNum, err := sc.Storage.GetCurrentBlock(ctx, address); err == nil {
+			maxLag = max(maxLag, head-blockNum)
+			sc.setMode(ctx, address, head-blockNum)
+		}
+	}
+	metrics.AccountLag.WithLabelValues(chain).Set(float64(maxLag))
+	sc.pruneModes(addresses)
+	return head, errors.Join(errs...)
+}
+
+// setMode switches the account to catch-up mode when it is further than
+// CATCHUPTHRESHOLD blocks behind chain head and to following mode otherwise
+func (sc *Scanner) setMode(ctx context.Context, address string, lag int) {
+	mode := Following
+	if lag > common.CATCHUPTHRESHOLD {
+		mode = CatchUp
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.modes == nil {
+		sc.modes = make(map[string]Mode)
+	}
+	if old, ok := sc.modes[address]; !ok || old != mode {
+		logging.FromContext(ctx).Info("scanning mode changed", logging.AddressKey, address, "mode", mode, "lag", lag)
+	}
+	sc.modes[address] = mode
+}
+
+// pruneModes forgets the modes of the unsubscribed accounts
+func (sc *Scanner) pruneModes(addresses []string) {
+	keep := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		keep[address] = struct{}{}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for address := range sc.modes {
+		if _, ok := keep[address]; !ok {
+			delete(sc.modes, address)
+		}
+	}
+}
+
+// Modes returns the scanning mode of each account as of the last round
+func (sc *Scanner) Modes() map[string]Mode {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	modes := make(map[string]Mode, len(sc.modes))
+	for address, mode := range sc.modes {
+		modes[address] = mode
+	}
+	return modes
+}
+
+// CatchingUp checks any account is in catch-up mode
+func (sc *Scanner) CatchingUp() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, mode := range sc.modes {
+		if mode == CatchUp {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanAccount scans a round of blocks from the checkpoint of the account up to head.
+// The round is partitioned into disjoint contiguous ranges, one per worker, and the
+// checkpoint advances only past the blocks processed without a gap from the checkpoint,
+// the blocks after a failed block are scanned again in the next round. The ERC-20 transfers
+// sent from or to the account are then retrieved up to the checkpoint with their own token
+// block, so that the checkpoint does not wait for eth_getLogs.
+func (sc *Scanner) ScanAccount(ctx context.Context, address string, head int) error {
+	start, err := sc.Storage.GetCurrentBlock(ctx, address)
+	if err != nil {
+		return err
+	}
+	// read before the checkpoint advances, it is the checkpoint until a token block is saved
+	tokenStart, err := sc.Storage.GetTokenBlock(ctx, address)
+	if err != nil {
+		return err
+	}
+	workers, batch := sc.limits()
+	end := start + batch
+	if end > head+1 {
+		end = head + 1
+	}
+	if end <= start {
+		return sc.scanTokens(ctx, address, tokenStart, start)
+	}
+
+	logging.FromContext(ctx).Debug("scan account", logging.AddressKey, address, logging.BlockKey, start, "to", end-1)
+
+	chain := util.NetworkFromContext(ctx).Name
+	results := make([]blockResult, end-start)
+	var wg sync.WaitGroup
+	for _, r := range partition(start, end, workers) {
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			for num := from; num < to; num++ {
+				if ctx.Err() != nil {
+					return
+				}
+				trans, header, err := sc.scanBlock(ctx, address, num)
+				if ctx.Err() != nil {
+					// the block is not processed, keep the checkpoint on it
+					return
+				}
+				// each worker writes its own range only
+				results[num-start] = blockResult{done: true, err: err, transactions: trans, header: header}
+				if err != nil {
+					// the following blocks of the range are beyond the gap
+					return
+				}
+				metrics.BlocksScanned.WithLabelValues(chain).Inc()
+			}
+		}(r[0], r[1])
+	}
+	wg.Wait()
+
+	// collect the results in block order up to the first gap
+	found := make([]common.Transaction, 0)
+	next := start
+	for _, r := range results {
+		if !r.done || r.err != nil {
+			break
+		}
+		found = append(found, r.transactions...)
+		next++
+		// the headers of the blocks with transactions give their context without RPC calls
+		if len(r.transactions) > 0 {
+			if err := sc.Storage.SaveHeader(ctx, r.header); err != nil {
+				logging.FromContext(ctx).Warn("save header failed", logging.BlockKey, r.header.Number, logging.ErrorKey, err)
+			}
+		}
+	}
+
+	if len(found) > 0 {
+		if err := sc.Storage.SaveTransactions(ctx, address, found); err != nil {
+			return err
+		}
+	}
+	if next > start {
+		if err := sc.Storage.SaveCheckpoint(ctx, address, next); err != nil {
+			return err
+		}
+	}
+
+	errs := make([]error, 0)
+	if err := sc.scanTokens(ctx, address, tokenStart, next); err != nil {
+		errs = append(errs, err)
+	}
+	for i, r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("block [%d]: %w", start+i, r.err))
+		}
+	}
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return errors.Join(errs...)
+}
+
+// scanBlock returns the transactions of the block sent from or to the address with the
+// block timestamp and their receipt status, gas used, effective gas price and logs, and the block header
+func (sc *Scanner) scanBlock(ctx context.Context, address string, num int) ([]common.Transaction, common.Header, error) {
+	started := time.Now()
+	block, err := sc.Chain.GetBlockByNumber(ctx, num)
+	sc.record(ctx, started, err)
+	if err != nil {
+		return nil, common.Header{}, err
+	}
+	trans := make([]common.Transaction, 0)
+	for _, tr := range block.Result.Transactions {
+		if strings.EqualFold(tr.From, address) || strings.EqualFold(tr.To, address) {
+			tr.Timestamp = block.Result.Timestamp
+			started := time.Now()
+			receipt, err := sc.Chain.GetTransactionReceipt(ctx, tr.Hash)
+			sc.record(ctx, started, err)
+			if err != nil {
+				return nil, common.Header{}, fmt.Errorf("receipt of transaction [%s]: %w", tr.Hash, err)
+			}
+			tr.Status = receipt.Status
+			tr.GasUsed = receipt.GasUsed
+			tr.EffectiveGasPrice = receipt.EffectiveGasPrice
+			tr.Logs = receipt.Logs
+			trans = append(trans, tr)
+		}
+	}
+	return trans, util.HeaderOf(block), nil
+}
+
+// record records the latency and outcome of a Json RPC request started at started with the
+// adaptive controller, the requests interrupted by the cancellation of ctx are not recorded
+func (sc *Scanner) record(ctx context.Context, started time.Time, err error) {
+	if sc.Controller != nil && ctx.Err() == nil {
+		sc.Controller.Record(time.Since(started), err)
+	}
+}
+
+// scanTokens saves the ERC-20 transfers sent from or to the account from its token block up
+// to the block before next, in ranges of at most LogsRange blocks. The token block advances
+// past each range saved, the range that cannot be retrieved is retried in the next round.
+func (sc *Scanner) scanTokens(ctx context.Context, address string, from, next int) error {
+	for from < next {
+		to := next
+		if sc.LogsRange > 0 && to-from > sc.LogsRange {
+			to = from + sc.LogsRange
+		}
+		transfers, err := sc.tokenTransfers(ctx, address, from, to-1)
+		if err != nil {
+			return fmt.Errorf("token transfers of blocks [%d, %d]: %w", from, to-1, err)
+		}
+		if len(transfers) > 0 {
+			if err := sc.Storage.SaveTokenTransfers(ctx, address, transfers); err != nil {
+				return err
+			}
+		}
+		if err := sc.Storage.SaveTokenBlock(ctx, address, to); err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
+
+// tokenTransfers returns the ERC-20 transfers sent from or to the address within the
+// inclusive block range, a transfer to self is returned once
+func (sc *Scanner) tokenTransfers(ctx context.Context, address string, fromBlock, toBlock int) ([]common.TokenTransfer, error) {
+	transfers := make([]common.TokenTransfer, 0)
+	seen := make(map[string]struct{})
+	for _, filter := range token.TransferFilters(address, fromBlock, toBlock) {
+		started := time.Now()
+		logs, err := sc.Chain.GetLogs(ctx, filter)
+		sc.record(ctx, started, err)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			transfer, ok := token.DecodeTransfer(l)
+			if !ok {
+				continue
+			}
+			key := fmt.Sprintf("%s|%d", transfer.TransactionHash, transfer.LogIndex)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers, nil
+}
+
+// partition splits [start, end) into at most n disjoint contiguous ranges of nearly equal size
+func partition(start, end, n int) [][2]int {
+	total := end - start
+	if n > total {
+		n = total
+	}
+	if n < 1 {
+		n = 1
+	}
+	ranges := make([][2]int, 0, n)
+	size, extra := total/n, total%n
+	from := start
+	for i := 0; i < n; i++ {
+		to := from + size
+		if i < extra {
+			to++
+		}
+		ranges = append(ranges, [2]int{from, to})
+		from = to
+	}
+	return ranges
+}
@@ -0,0 +1,486 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	token "github.com/tonyxu1/transactionhistory/token"
+	util "github.com/tonyxu1/transactionhistory/util"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+
+// mockChain has a transaction to the test address in every block, failing the blocks in fail
+// and the receipts of the transactions of the blocks in failReceipt. Its transaction of each
+// block also transfers a token to the test address, the logs cannot be retrieved when failLogs
+// nor for ranges wider than logsRange blocks when set. The receipts and the logs are rate limited
+// when throttleReceipts and throttleLogs are set.
+type mockChain struct {
+	head             int
+	fail             map[int]bool
+	failReceipt      map[int]bool
+	failLogs         bool
+	logsRange        int
+	throttleReceipts bool
+	throttleLogs     bool
+}
+
+func (m *mockChain) GetChainHead(ctx context.Context) (int, error) {
+	return m.head, nil
+}
+
+func (m *mockChain) GetBlockByNumber(ctx context.Context, number int) (common.Block, error) {
+	if m.fail[number] {
+		return common.Block{}, errors.New("rpc timeout")
+	}
+	var b common.Block
+	b.Result.Number = fmt.Sprintf("0x%x", number)
+	b.Result.Timestamp = fmt.Sprintf("0x%x", 1700000000+12*number)
+	b.Result.Transactions = []common.Transaction{
+		{BlockNumber: b.Result.Number, Hash: b.Result.Number, To: "0x23ca95b9de14a83cbf4a43b11c2c3825e72c7d9b"},
+		{BlockNumber: b.Result.Number, To: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"},
+	}
+	return b, nil
+}
+
+func (m *mockChain) GetTransactionReceipt(ctx context.Context, hash string) (common.Receipt, error) {
+	number, err := strconv.ParseInt(hash, 0, 64)
+	if err != nil {
+		return common.Receipt{}, err
+	}
+	if m.failReceipt[int(number)] {
+		return common.Receipt{}, errors.New("rpc timeout")
+	}
+	if m.throttleReceipts {
+		return common.Receipt{}, util.ErrRateLimited
+	}
+	return common.Receipt{TransactionHash: hash, Status: common.RECEIPTSUCCESS, GasUsed: "0x5208", EffectiveGasPrice: "0x3b9aca00"}, nil
+}
+
+func (m *mockChain) GetLogs(ctx context.Context, filter common.LogFilter) ([]common.Log, error) {
+	if m.failLogs {
+		return nil, errors.New("rpc timeout")
+	}
+	if m.throttleLogs {
+		return nil, util.ErrRateLimited
+	}
+	// the transfers to the test address match the filter on the third topic only
+	if len(filter.Topics) != 3 {
+		return []common.Log{}, nil
+	}
+	from, _ := strconv.ParseInt(filter.FromBlock, 0, 64)
+	to, _ := strconv.ParseInt(filter.ToBlock, 0, 64)
+	if m.logsRange > 0 && int(to-from+1) > m.logsRange {
+		return nil, errors.New("block range too wide")
+	}
+	logs := make([]common.Log, 0)
+	for number := from; number <= to; number++ {
+		logs = append(logs, common.Log{
+			Address:         "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+			Topics:          []string{common.TRANSFERTOPIC, token.AddressTopic("0xE946502872DA09009Aa6dc975272AC24Ab5B4f36"), token.AddressTopic(testAddress)},
+			Data:            "0x0000000000000000000000000000000000000000000000000000000000000001",
+			BlockNumber:     fmt.Sprintf("0x%x", number),
+			TransactionHash: fmt.Sprintf("0x%x", number),
+			LogIndex:        "0x0",
+		})
+	}
+	return logs, nil
+}
+
+// mockStorage implements the methods of common.Storage used by the scanner for a single
+// account, the embedded nil common.Storage panics on the others
+type mockStorage struct {
+	common.Storage
+	mu           sync.Mutex
+	checkpoint   int
+	tokenBlock   int
+	transactions []common.Transaction
+	transfers    []common.TokenTransfer
+	headers      map[string]common.Header
+}
+
+func (m *mockStorage) SaveTransactions(ctx context.Context, address string, transactions []common.Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transactions = append(m.transactions, transactions...)
+	return nil
+}
+
+func (m *mockStorage) SaveCheckpoint(ctx context.Context, address string, block int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoint = block
+	return nil
+}
+
+func (m *mockStorage) SaveTokenBlock(ctx context.Context, address string, block int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokenBlock = block
+	return nil
+}
+
+func (m *mockStorage) GetTokenBlock(ctx context.Context, address string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tokenBlock, nil
+}
+
+func (m *mockStorage) GetAccounts(ctx context.Context) ([]string, error) {
+	return []string{testAddress}, nil
+}
+
+func (m *mockStorage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkpoint, nil
+}
+
+func (m *mockStorage) SaveHeader(ctx context.Context, header common.Header) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.headers == nil {
+		m.headers = make(map[string]common.Header)
+	}
+	m.headers[header.Number] = header
+	return nil
+}
+
+func (m *mockStorage) SaveTokenTransfers(ctx context.Context, address string, transfers []common.TokenTransfer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transfers = append(m.transfers, transfers...)
+	return nil
+}
+
+func blockNumbers(trans []common.Transaction) []string {
+	nums := make([]string, 0, len(trans))
+	for _, tr := range trans {
+		nums = append(nums, tr.BlockNumber)
+	}
+	return nums
+}
+
+func TestScanner_ScanAccount(t *testing.T) {
+	tests := []struct {
+		name           string
+		chain          *mockChain
+		tokenBlock     int
+		logsRange      int
+		want           int
+		wantTokenBlock int
+		wantBlocks     []string
+		wantErr        bool
+		blocksPerRound int
+	}{
+		{
+			name:       "All blocks of the round scanned in order",
+			chain:      &mockChain{head: 1000},
+			want:       110,
+			wantBlocks: []string{"0x64", "0x65", "0x66", "0x67", "0x68", "0x69", "0x6a", "0x6b", "0x6c", "0x6d"},
+		}, {
+			name:       "Round stops at chain head",
+			chain:      &mockChain{head: 102},
+			want:       103,
+			wantBlocks: []string{"0x64", "0x65", "0x66"},
+		}, {
+			name:       "Checkpoint stops at the first failed block",
+			chain:      &mockChain{head: 1000, fail: map[int]bool{104: true, 108: true}},
+			want:       104,
+			wantBlocks: []string{"0x64", "0x65", "0x66", "0x67"},
+			wantErr:    true,
+		}, {
+			name:       "Checkpoint stops at the block whose receipt fails",
+			chain:      &mockChain{head: 1000, failReceipt: map[int]bool{103: true}},
+			want:       103,
+			wantBlocks: []string{"0x64", "0x65", "0x66"},
+			wantErr:    true,
+		}, {
+			name:           "Checkpoint advances when the token transfers cannot be retrieved",
+			chain:          &mockChain{head: 1000, failLogs: true},
+			want:           110,
+			wantTokenBlock: 100,
+			wantBlocks:     []string{"0x64", "0x65", "0x66", "0x67", "0x68", "0x69", "0x6a", "0x6b", "0x6c", "0x6d"},
+			wantErr:        true,
+		}, {
+			name:       "Token transfers read in ranges of LogsRange blocks",
+			chain:      &mockChain{head: 1000, logsRange: 4},
+			logsRange:  4,
+			want:       110,
+			wantBlocks: []string{"0x64", "0x65", "0x66", "0x67", "0x68", "0x69", "0x6a", "0x6b", "0x6c", "0x6d"},
+		}, {
+			name:           "Token block stops at the range the provider rejects",
+			chain:          &mockChain{head: 1000, logsRange: 4},
+			want:           110,
+			wantTokenBlock: 100,
+			wantBlocks:     []string{"0x64", "0x65", "0x66", "0x67", "0x68", "0x69", "0x6a", "0x6b", "0x6c", "0x6d"},
+			wantErr:        true,
+		}, {
+			name:       "Token block behind the checkpoint catches up",
+			chain:      &mockChain{head: 1000},
+			tokenBlock: 80,
+			logsRange:  8,
+			want:       110,
+			wantBlocks: []string{"0x64", "0x65", "0x66", "0x67", "0x68", "0x69", "0x6a", "0x6b", "0x6c", "0x6d"},
+		}, {
+			name:       "Checkpoint kept when the first block fails",
+			chain:      &mockChain{head: 1000, fail: map[int]bool{100: true}},
+			want:       100,
+			wantBlocks: []string{},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStart := tt.tokenBlock
+			if tokenStart == 0 {
+				tokenStart = 100
+			}
+			wantTokenBlock := tt.wantTokenBlock
+			if wantTokenBlock == 0 {
+				wantTokenBlock = tt.want
+			}
+			s := &mockStorage{checkpoint: 100, tokenBlock: tokenStart}
+			sc := &Scanner{Storage: s, Chain: tt.chain, Workers: 3, BlocksPerRound: 10, LogsRange: tt.logsRange}
+			err := sc.ScanAccount(context.Background(), testAddress, tt.chain.head)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Scanner.ScanAccount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.checkpoint != tt.want {
+				t.Errorf("Scanner.ScanAccount() checkpoint = %d, want %d", s.checkpoint, tt.want)
+			}
+			if got := blockNumbers(s.transactions); !reflect.DeepEqual(got, tt.wantBlocks) {
+				t.Errorf("Scanner.ScanAccount() transactions = %v, want %v", got, tt.wantBlocks)
+			}
+			for _, tr := range s.transactions {
+				header, ok := s.headers[tr.BlockNumber]
+				if !ok || tr.Timestamp == "" || header.Timestamp != tr.Timestamp {
+					t.Errorf("Scanner.ScanAccount() block [%s] timestamp = %q, header = %v", tr.BlockNumber, tr.Timestamp, header)
+				}
+				if tr.Status != common.RECEIPTSUCCESS || tr.GasUsed == "" || tr.EffectiveGasPrice == "" {
+					t.Errorf("Scanner.ScanAccount() block [%s] receipt fields not set: %+v", tr.BlockNumber, tr)
+				}
+			}
+			if s.tokenBlock != wantTokenBlock {
+				t.Errorf("Scanner.ScanAccount() token block = %d, want %d", s.tokenBlock, wantTokenBlock)
+			}
+			// one transfer per block past the token block the round started from
+			if len(s.transfers) != s.tokenBlock-tokenStart {
+				t.Errorf("Scanner.ScanAccount() transfers = %d, want %d", len(s.transfers), s.tokenBlock-tokenStart)
+			}
+		})
+	}
+}
+
+func TestScanner_ScanAccount_Throttled(t *testing.T) {
+	bounds := Bounds{MinWorkers: 1, MaxWorkers: 8, MinBatch: 10, MaxBatch: 200}
+	tests := []struct {
+		name        string
+		chain       *mockChain
+		wantWorkers int
+		wantBatch   int
+	}{
+		{name: "Throttled receipts halve", chain: &mockChain{head: 1000, throttleReceipts: true}, wantWorkers: 2, wantBatch: 50},
+		{name: "Throttled logs halve", chain: &mockChain{head: 1000, throttleLogs: true}, wantWorkers: 2, wantBatch: 50},
+		{name: "Successful requests increase", chain: &mockChain{head: 1000}, wantWorkers: 5, wantBatch: 100 + common.ADAPTIVEBATCHSTEP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController("test", bounds, 4, 100)
+			sc := &Scanner{Storage: &mockStorage{checkpoint: 100, tokenBlock: 100}, Chain: tt.chain, Controller: c}
+			sc.ScanAccount(context.Background(), testAddress, tt.chain.head)
+			c.Adjust()
+			if workers, batch := c.Limits(); workers != tt.wantWorkers || batch != tt.wantBatch {
+				t.Errorf("Controller.Limits() = %d, %d, want %d, %d", workers, batch, tt.wantWorkers, tt.wantBatch)
+			}
+		})
+	}
+}
+
+func Test_partition(t *testing.T) {
+	tests := []struct {
+		name  string
+		start int
+		end   int
+		n     int
+		want  [][2]int
+	}{
+		{
+			name:  "Even split",
+			start: 100, end: 106, n: 3,
+			want: [][2]int{{100, 102}, {102, 104}, {104, 106}},
+		}, {
+			name:  "Remainder spread over the first ranges",
+			start: 0, end: 8, n: 3,
+			want: [][2]int{{0, 3}, {3, 6}, {6, 8}},
+		}, {
+			name:  "More workers than blocks",
+			start: 10, end: 12, n: 6,
+			want: [][2]int{{10, 11}, {11, 12}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partition(tt.start, tt.end, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("partition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestController_Adjust(t *testing.T) {
+	bounds := Bounds{MinWorkers: 1, MaxWorkers: 8, MinBatch: 10, MaxBatch: 200}
+	tests := []struct {
+		name        string
+		workers     int
+		batch       int
+		latency     time.Duration
+		errs        []error
+		wantWorkers int
+		wantBatch   int
+	}{
+		{
+			name:    "Healthy round grows additively",
+			workers: 4, batch: 100,
+			latency:     10 * time.Millisecond,
+			errs:        []error{nil, nil, nil},
+			wantWorkers: 5, wantBatch: 150,
+		}, {
+			name:    "Growth bounded by max",
+			workers: 8, batch: 180,
+			latency:     10 * time.Millisecond,
+			errs:        []error{nil},
+			wantWorkers: 8, wantBatch: 200,
+		}, {
+			name:    "Throttled round halves",
+			workers: 6, batch: 100,
+			latency:     10 * time.Millisecond,
+			errs:        []error{nil, fmt.Errorf("%w: http status 429", util.ErrRateLimited)},
+			wantWorkers: 3, wantBatch: 50,
+		}, {
+			name:    "Json RPC limit error halves",
+			workers: 6, batch: 100,
+			latency:     10 * time.Millisecond,
+			errs:        []error{&util.RPCError{Code: -32005, Message: "daily request count exceeded"}},
+			wantWorkers: 3, wantBatch: 50,
+		}, {
+			name:    "Timed out round halves down to min",
+			workers: 1, batch: 15,
+			latency:     10 * time.Millisecond,
+			errs:        []error{context.DeadlineExceeded},
+			wantWorkers: 1, wantBatch: 10,
+		}, {
+			name:    "Slow round halves",
+			workers: 4, batch: 100,
+			latency:     5 * time.Second,
+			errs:        []error{nil},
+			wantWorkers: 2, wantBatch: 50,
+		}, {
+			name:    "No request keeps limits",
+			workers: 4, batch: 100,
+			wantWorkers: 4, wantBatch: 100,
+		},
+	}
+	NewController("other", bounds, 8, 100)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController("test", bounds, tt.workers, tt.batch)
+			for _, err := range tt.errs {
+				c.Record(tt.latency, err)
+			}
+			c.Adjust()
+			if workers, batch := c.Limits(); workers != tt.wantWorkers || batch != tt.wantBatch {
+				t.Errorf("Controller.Limits() = %d, %d, want %d, %d", workers, batch, tt.wantWorkers, tt.wantBatch)
+			}
+			// the gauges of another network are left as is
+			if workers := testutil.ToFloat64(metrics.ScannerWorkers.WithLabelValues("test")); workers != float64(tt.wantWorkers) {
+				t.Errorf("scanner_workers{chain=\"test\"} = %v, want %d", workers, tt.wantWorkers)
+			}
+			if workers := testutil.ToFloat64(metrics.ScannerWorkers.WithLabelValues("other")); workers != 8 {
+				t.Errorf("scanner_workers{chain=\"other\"} = %v, want 8", workers)
+			}
+		})
+	}
+}
+
+func TestScanner_ScanAll(t *testing.T) {
+	tests := []struct {
+		name           string
+		head           int
+		want           Mode
+		wantCatchingUp bool
+	}{
+		{
+			name:           "Account far behind head catches up",
+			head:           100 + 10 + common.CATCHUPTHRESHOLD + 1,
+			want:           CatchUp,
+			wantCatchingUp: true,
+		}, {
+			name:           "Account near head follows",
+			head:           100 + 10 + common.CATCHUPTHRESHOLD,
+			want:           Following,
+			wantCatchingUp: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &mockStorage{checkpoint: 100}
+			sc := &Scanner{Storage: s, Chain: &mockChain{head: tt.head}, Workers: 2, BlocksPerRound: 10}
+			head, err := sc.ScanAll(context.Background())
+			if err != nil || head != tt.head {
+				t.Errorf("Scanner.ScanAll() = %d, %v, want %d", head, err, tt.head)
+				return
+			}
+			if got := sc.Modes()[testAddress]; got != tt.want {
+				t.Errorf("Scanner.Modes() = %v, want %v", got, tt.want)
+			}
+			if got := sc.CatchingUp(); got != tt.wantCatchingUp {
+				t.Errorf("Scanner.CatchingUp() = %v, want %v", got, tt.wantCatchingUp)
+			}
+		})
+	}
+}
+
+func TestScanner_ScanAll_Confirmations(t *testing.T) {
+	tests := []struct {
+		name           string
+		confirmations  int
+		wantCheckpoint int
+	}{
+		{
+			name:           "Blocks scanned up to head",
+			confirmations:  0,
+			wantCheckpoint: 106,
+		}, {
+			name:           "Unconfirmed blocks left for the next rounds",
+			confirmations:  3,
+			wantCheckpoint: 103,
+		}, {
+			name:           "No block confirmed yet",
+			confirmations:  10,
+			wantCheckpoint: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &mockStorage{checkpoint: 100}
+			sc := &Scanner{Storage: s, Chain: &mockChain{head: 105}, Workers: 2, BlocksPerRound: 10, Confirmations: tt.confirmations}
+			if _, err := sc.ScanAll(context.Background()); err != nil {
+				t.Fatalf("Scanner.ScanAll() error = %v", err)
+			}
+			if s.checkpoint != tt.wantCheckpoint {
+				t.Errorf("Scanner.ScanAll() checkpoint = %d, want %d", s.checkpoint, tt.wantCheckpoint)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	common "github.com/tonyxu1/transactionhistory/common"
 	storage "github.com/tonyxu1/transactionhistory/storage"
 )
@@ -12,16 +14,21 @@ type Parser struct {
 }
 
 func (p Parser) GetCurrentBlock() (int, error) {
-	return p.Storage.GetCurrentBlock(p.Address)
+	return p.Storage.GetCurrentBlock(context.Background(), p.Address)
 }
 
 // Subscribe add address into account map and retrieve all transactions from chain
 func (p Parser) Subscribe() error {
-	return p.Storage.CreateAccount(p.Address)
+	return p.Storage.CreateAccount(context.Background(), p.Address)
+}
+
+// Unsubscribe remove address from account map along with its transactions
+func (p Parser) Unsubscribe() error {
+	return p.Storage.RemoveAccount(context.Background(), p.Address)
 }
 
 // GetTransactions return all transaction history record from both on chain and local storage
 func (p Parser) GetTransactions() ([]common.Transaction, error) {
 
-	return p.Storage.GetTransactions(p.Address)
+	return p.Storage.GetTransactions(context.Background(), p.Address)
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -11,7 +12,7 @@ import (
 func TestParser_GetCurrentBlock(t *testing.T) {
 
 	s := storage.New()
-	s.CreateAccount("0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")
+	s.CreateAccount(context.Background(), "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")
 
 	type fields struct {
 		Address string
@@ -116,7 +117,7 @@ func TestParser_Subscribe(t *testing.T) {
 			} else if tt.name == "Account already subscribed" {
 
 				s := storage.New()
-				s.CreateAccount("0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")
+				s.CreateAccount(context.Background(), "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")
 				p := Parser{
 					Address: tt.fields.Address,
 					Storage: &s,
@@ -194,7 +195,7 @@ func TestParser_GetTransactions(t *testing.T) {
 				}
 			} else if tt.name == "Return transaction array order by block number desc" {
 				s := storage.New()
-				s.CreateAccount("0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")
+				s.CreateAccount(context.Background(), "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")
 				trans := []common.Transaction{
 					{
 						BlockNumber: "0x123",
@@ -206,7 +207,7 @@ func TestParser_GetTransactions(t *testing.T) {
 						BlockNumber: "0x121",
 					},
 				}
-				err := s.SaveTransactions("0xE946502872DA09009Aa6dc975272AC24Ab5B4f36", trans)
+				err := s.SaveTransactions(context.Background(), "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36", trans)
 				if err != nil {
 					t.Errorf("s.SaveTransactions() error : %v", err)
 				}
@@ -224,7 +225,7 @@ func TestParser_GetTransactions(t *testing.T) {
 				}
 			} else if tt.name == "Return empty transaction array" {
 				s := storage.New()
-				s.CreateAccount("0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
+				s.CreateAccount(context.Background(), "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
 				p := Parser{
 					Address: tt.fields.Address,
 					Storage: &s,
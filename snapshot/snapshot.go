@@ -0,0 +1,156 @@
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	archive "github.com/tonyxu1/transactionhistory/archive"
+	auth "github.com/tonyxu1/transactionhistory/auth"
+	common "github.com/tonyxu1/transactionhistory/common"
+)
+
+// File defines a snapshot file by its path and the function writing its content
+type File struct {
+	Path  string
+	Write func(w io.Writer) error
+}
+
+// Storage returns the snapshot file of the accounts, checkpoints, transactions, headers and
+// token metadata of the storage, a gzip compressed JSON Lines archive
+func Storage(ctx context.Context, path string, s common.Storage) File {
+	return File{Path: path, Write: func(w io.Writer) error { return archive.Write(ctx, w, s) }}
+}
+
+// Keys returns the snapshot file of the API keys with the accounts subscribed with them, gzip
+// compressed JSON Lines with a key per line. The keys are secrets, the file is only readable
+// by its owner.
+func Keys(path string, ks *auth.KeyStore) File {
+	return File{Path: path, Write: func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, record := range ks.Records() {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// Save writes the snapshot of the storage to path, as SaveAll
+func Save(ctx context.Context, path string, s common.Storage) error {
+	return SaveAll(Storage(ctx, path, s))
+}
+
+// SaveAll writes each file to a temporary file, the temporary files are renamed over their paths
+// once all of them are complete, so a failed save keeps all the previous snapshots. Writes made
+// while saving may be missed, callers stop the writes to the storages and the key store so that
+// the files are a view of the same point in time.
+func SaveAll(files ...File) error {
+	tmps := make([]string, 0, len(files))
+	defer func() {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}()
+	for _, f := range files {
+		tmp, err := writeTemp(f)
+		if tmp != "" {
+			tmps = append(tmps, tmp)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+	}
+	for i, f := range files {
+		if err := os.Rename(tmps[i], f.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTemp writes the gzip compressed content of the file to a temporary file next to
+// it and returns its name, empty when it cannot be created
+func writeTemp(f File) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+
+	zw := gzip.NewWriter(tmp)
+	if err := f.Write(zw); err != nil {
+		tmp.Close()
+		return tmp.Name(), err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return tmp.Name(), err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return tmp.Name(), err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// Load loads the snapshot at path into the storage, false is returned when there is no snapshot
+func Load(ctx context.Context, path string, s common.Storage) (archive.Stats, bool, error) {
+	var stats archive.Stats
+	found, err := load(path, func(r io.Reader) error {
+		var err error
+		stats, err = archive.Import(ctx, r, s)
+		return err
+	})
+	return stats, found, err
+}
+
+// LoadKeys restores the API keys saved at path with Keys into the key store and returns
+// their number, false is returned when there is no snapshot
+func LoadKeys(path string, ks *auth.KeyStore) (int, bool, error) {
+	records := make([]auth.Record, 0)
+	found, err := load(path, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var record auth.Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return fmt.Errorf("line %d: %s", line, err.Error())
+			}
+			records = append(records, record)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return ks.Restore(records)
+	})
+	return len(records), found, err
+}
+
+// load reads the gzip compressed file at path with read, false is returned when there is no file
+func load(path string, read func(r io.Reader) error) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	defer zr.Close()
+	return true, read(zr)
+}
@@ -0,0 +1,216 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	archive "github.com/tonyxu1/transactionhistory/archive"
+	auth "github.com/tonyxu1/transactionhistory/auth"
+	common "github.com/tonyxu1/transactionhistory/common"
+	storage "github.com/tonyxu1/transactionhistory/storage"
+)
+
+const testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+
+func TestSave_Load(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.Storage{}
+	if err := s.RestoreAccount(ctx, testAddress, 15000000); err != nil {
+		t.Fatalf("Storage.RestoreAccount() error = %v", err)
+	}
+	tr := common.Transaction{
+		BlockHash:   "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+		BlockNumber: "0xe4e1bf",
+		Hash:        "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+		To:          testAddress,
+		Timestamp:   "0x6553d2c0",
+	}
+	if err := s.SaveTransactions(ctx, testAddress, []common.Transaction{tr}); err != nil {
+		t.Fatalf("Storage.SaveTransactions() error = %v", err)
+	}
+	header := common.Header{Number: tr.BlockNumber, Hash: tr.BlockHash, Timestamp: tr.Timestamp}
+	if err := s.SaveHeader(ctx, header); err != nil {
+		t.Fatalf("Storage.SaveHeader() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.gz")
+	if err := os.WriteFile(corrupt, []byte("not gzip"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	saved := filepath.Join(dir, "snapshot.gz")
+	if err := Save(ctx, saved, s); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		want      archive.Stats
+		wantFound bool
+		wantErr   bool
+	}{
+		{
+			name:      "Saved snapshot restored",
+			path:      saved,
+			want:      archive.Stats{Accounts: 1, Transactions: 1, Headers: 1},
+			wantFound: true,
+		}, {
+			name: "No snapshot",
+			path: filepath.Join(dir, "missing.gz"),
+		}, {
+			name:    "Corrupt snapshot",
+			path:    corrupt,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored := &storage.Storage{}
+			got, found, err := Load(ctx, tt.path, restored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if found != tt.wantFound || got != tt.want {
+				t.Errorf("Load() = %+v, %v, want %+v, %v", got, found, tt.want, tt.wantFound)
+			}
+			if !tt.wantFound {
+				return
+			}
+			checkpoint, _ := restored.GetCurrentBlock(ctx, testAddress)
+			trans, _ := restored.GetTransactions(ctx, testAddress)
+			headers, _ := restored.GetHeaders(ctx)
+			if checkpoint != 15000000 || !reflect.DeepEqual(trans, []common.Transaction{tr}) || !reflect.DeepEqual(headers, []common.Header{header}) {
+				t.Errorf("Load() restored checkpoint %d, transactions %v, headers %v", checkpoint, trans, headers)
+			}
+		})
+	}
+}
+
+func TestKeys_LoadKeys(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.Storage{}
+	ks := auth.NewKeyStore()
+	owner, err := ks.Issue("owner", 1)
+	if err != nil {
+		t.Fatalf("KeyStore.Issue() error = %v", err)
+	}
+	other, err := ks.Issue("other", 1)
+	if err != nil {
+		t.Fatalf("KeyStore.Issue() error = %v", err)
+	}
+	scoped := &auth.ScopedStorage{Keys: ks, Token: owner.Token, Chain: common.DEFAULTNETWORK, Storage: s}
+	if err := scoped.RestoreAccount(ctx, testAddress, 15000000); err != nil {
+		t.Fatalf("ScopedStorage.RestoreAccount() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	snapshotPath, keysPath := filepath.Join(dir, "snapshot.gz"), filepath.Join(dir, "snapshot.gz.keys")
+	if err := SaveAll(Storage(ctx, snapshotPath, s), Keys(keysPath, ks)); err != nil {
+		t.Fatalf("SaveAll() error = %v", err)
+	}
+	restored, restoredKeys := &storage.Storage{}, auth.NewKeyStore()
+	if _, _, err := Load(ctx, snapshotPath, restored); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	n, found, err := LoadKeys(keysPath, restoredKeys)
+	if err != nil || !found || n != 2 {
+		t.Fatalf("LoadKeys() = %v, %v, %v, want 2 keys", n, found, err)
+	}
+	if n, found, err := LoadKeys(filepath.Join(dir, "missing.keys"), auth.NewKeyStore()); err != nil || found || n != 0 {
+		t.Errorf("LoadKeys() of a missing snapshot = %v, %v, %v, want not found", n, found, err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:  "Scoped key reads its account",
+			token: owner.Token,
+			want:  15000000,
+		}, {
+			name:    "Other key does not see the account",
+			token:   other.Token,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !restoredKeys.Valid(tt.token) {
+				t.Fatalf("KeyStore.Valid() = false after restore")
+			}
+			scoped := &auth.ScopedStorage{Keys: restoredKeys, Token: tt.token, Chain: common.DEFAULTNETWORK, Storage: restored}
+			got, err := scoped.GetCurrentBlock(ctx, testAddress)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ScopedStorage.GetCurrentBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ScopedStorage.GetCurrentBlock() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !restoredKeys.IsOwned(common.DEFAULTNETWORK, testAddress) {
+		t.Errorf("KeyStore.IsOwned() = false after restore")
+	}
+	if _, err := restoredKeys.Issue("new", 1); err != nil {
+		t.Errorf("KeyStore.Issue() after restore error = %v", err)
+	}
+	for _, key := range restoredKeys.Keys() {
+		if key.Token == owner.Token && key.Subscriptions != 1 {
+			t.Errorf("KeyStore.Keys() subscriptions = %v, want 1", key.Subscriptions)
+		}
+	}
+}
+
+func TestSaveAll(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.gz"), filepath.Join(dir, "second.gz")
+	write := func(content string) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		}
+	}
+	fail := func(w io.Writer) error { return errors.New("storage not readable") }
+	if err := SaveAll(File{Path: first, Write: write("previous")}, File{Path: second, Write: write("previous")}); err != nil {
+		t.Fatalf("SaveAll() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		second  func(w io.Writer) error
+		want    string
+		wantErr bool
+	}{
+		{name: "Failed file keeps all the previous files", second: fail, want: "previous", wantErr: true},
+		{name: "Files replaced together", second: write("next"), want: "next"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SaveAll(File{Path: first, Write: write("next")}, File{Path: second, Write: tt.second}); (err != nil) != tt.wantErr {
+				t.Fatalf("SaveAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, path := range []string{first, second} {
+				var got []byte
+				if _, err := load(path, func(r io.Reader) error {
+					var err error
+					got, err = io.ReadAll(r)
+					return err
+				}); err != nil || string(got) != tt.want {
+					t.Errorf("%s = %q, %v, want %q", filepath.Base(path), got, err, tt.want)
+				}
+			}
+			if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(tmps) > 0 {
+				t.Errorf("SaveAll() left temporary files %v", tmps)
+			}
+		})
+	}
+}
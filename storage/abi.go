@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// SaveABI saves the ABI of the contract, replacing the ABI registered for the same address
+func (s *Storage) SaveABI(ctx context.Context, contract common.ContractABI) error {
+	if err := util.ValidateAddress(contract.Address); err != nil {
+		return err
+	}
+	if len(contract.ABI) == 0 {
+		return errors.New("empty abi")
+	}
+	contract.Address = strings.ToLower(contract.Address)
+	s.abis.Store(contract.Address, contract)
+	return nil
+}
+
+// GetABI returns the ABI registered for the contract address
+func (s *Storage) GetABI(ctx context.Context, address string) (common.ContractABI, error) {
+	if data, ok := s.abis.Load(strings.ToLower(address)); ok {
+		return data.(common.ContractABI), nil
+	}
+	return common.ContractABI{}, fmt.Errorf("no abi registered for [%s]", address)
+}
+
+// GetABIs returns the ABIs of all the contracts ordered by address
+func (s *Storage) GetABIs(ctx context.Context) ([]common.ContractABI, error) {
+	abis := make([]common.ContractABI, 0)
+	s.abis.Range(func(key, value any) bool {
+		abis = append(abis, value.(common.ContractABI))
+		return true
+	})
+	sort.Slice(abis, func(i, j int) bool { return abis[i].Address < abis[j].Address })
+	return abis, nil
+}
+
+// RemoveABI removes the ABI registered for the contract address
+func (s *Storage) RemoveABI(ctx context.Context, address string) error {
+	if _, ok := s.abis.LoadAndDelete(strings.ToLower(address)); !ok {
+		return fmt.Errorf("no abi registered for [%s]", address)
+	}
+	return nil
+}
+
+// SaveEventABI saves the ABI of the event, replacing the event ABI registered for the same topic
+func (s *Storage) SaveEventABI(ctx context.Context, event common.EventABI) error {
+	if err := util.ValidateHash(event.Topic); err != nil {
+		return err
+	}
+	if len(event.ABI) == 0 {
+		return errors.New("empty abi")
+	}
+	event.Topic = strings.ToLower(event.Topic)
+	s.eventABIs.Store(event.Topic, event)
+	return nil
+}
+
+// GetEventABI returns the event ABI registered for the topic
+func (s *Storage) GetEventABI(ctx context.Context, topic string) (common.EventABI, error) {
+	if data, ok := s.eventABIs.Load(strings.ToLower(topic)); ok {
+		return data.(common.EventABI), nil
+	}
+	return common.EventABI{}, fmt.Errorf("no event abi registered for [%s]", topic)
+}
+
+// GetEventABIs returns all the event ABIs ordered by topic
+func (s *Storage) GetEventABIs(ctx context.Context) ([]common.EventABI, error) {
+	events := make([]common.EventABI, 0)
+	s.eventABIs.Range(func(key, value any) bool {
+		events = append(events, value.(common.EventABI))
+		return true
+	})
+	sort.Slice(events, func(i, j int) bool { return events[i].Topic < events[j].Topic })
+	return events, nil
+}
+
+// RemoveEventABI removes the event ABI registered for the topic
+func (s *Storage) RemoveEventABI(ctx context.Context, topic string) error {
+	if _, ok := s.eventABIs.LoadAndDelete(strings.ToLower(topic)); !ok {
+		return fmt.Errorf("no event abi registered for [%s]", topic)
+	}
+	return nil
+}
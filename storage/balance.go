@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// SaveBalance saves a balance checkpoint of the address, the checkpoint of the same block is replaced
+func (s *Storage) SaveBalance(ctx context.Context, address string, checkpoint common.BalanceCheckpoint) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if s.IsNewAccount(address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var checkpoints []common.BalanceCheckpoint
+	if data, ok := s.balances.Load(address); ok {
+		checkpoints = data.([]common.BalanceCheckpoint)
+	}
+	i := sort.Search(len(checkpoints), func(i int) bool { return checkpoints[i].Block >= checkpoint.Block })
+	// copy on write, readers keep the slice they loaded
+	updated := make([]common.BalanceCheckpoint, 0, len(checkpoints)+1)
+	updated = append(updated, checkpoints[:i]...)
+	updated = append(updated, checkpoint)
+	if i < len(checkpoints) && checkpoints[i].Block == checkpoint.Block {
+		i++
+	}
+	updated = append(updated, checkpoints[i:]...)
+	s.balances.Store(address, updated)
+	return nil
+}
+
+// GetBalances returns the balance checkpoints of the address ordered by block ascending
+func (s *Storage) GetBalances(ctx context.Context, address string) ([]common.BalanceCheckpoint, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.IsNewAccount(address) {
+		return nil, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	if data, ok := s.balances.Load(address); ok {
+		return data.([]common.BalanceCheckpoint), nil
+	}
+	return []common.BalanceCheckpoint{}, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+// headerCache keeps up to HEADERCACHESIZE block headers by number,
+// the headers saved first are evicted first
+type headerCache struct {
+	mu      sync.Mutex
+	headers map[int]common.Header
+	// order holds the block numbers in the order they were saved
+	order []int
+}
+
+// SaveHeader : save the header of a block to the header cache, a header saved
+// again for the same number replaces the previous one
+func (s *Storage) SaveHeader(ctx context.Context, header common.Header) error {
+	num, err := hexutil.DecodeUint64(header.Number)
+	if err != nil {
+		return fmt.Errorf("invalid block number %s: %s", header.Number, err.Error())
+	}
+
+	c := &s.headers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.headers == nil {
+		c.headers = make(map[int]common.Header)
+	}
+	if _, ok := c.headers[int(num)]; !ok {
+		c.order = append(c.order, int(num))
+	}
+	c.headers[int(num)] = header
+
+	for len(c.order) > common.HEADERCACHESIZE {
+		delete(c.headers, c.order[0])
+		c.order = c.order[1:]
+	}
+	return nil
+}
+
+// GetHeaders : get the headers of the header cache in the order they were saved
+func (s *Storage) GetHeaders(ctx context.Context) ([]common.Header, error) {
+	c := &s.headers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	headers := make([]common.Header, 0, len(c.order))
+	for _, num := range c.order {
+		headers = append(headers, c.headers[num])
+	}
+	return headers, nil
+}
+
+// GetHeader : get the header of the block with the given number from the header cache
+func (s *Storage) GetHeader(ctx context.Context, number int) (common.Header, error) {
+	c := &s.headers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header, ok := c.headers[number]
+	if !ok {
+		return common.Header{}, fmt.Errorf("header of block [%d] not cached", number)
+	}
+	return header, nil
+}
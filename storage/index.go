@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+// position of a transaction in the primary index, transactions are ordered
+// by block number then transaction index, both descending
+type position struct {
+	block int64
+	index int64
+}
+
+// positionOf decodes the block number and transaction index of the transaction,
+// a field that cannot be decoded is -1 so the transaction is ordered last
+func positionOf(tr common.Transaction) position {
+	p := position{block: -1, index: -1}
+	if n, err := hexutil.DecodeUint64(tr.BlockNumber); err == nil {
+		p.block = int64(n)
+	}
+	if n, err := hexutil.DecodeUint64(tr.TransactionIndex); err == nil {
+		p.index = int64(n)
+	}
+	return p
+}
+
+// before reports whether p is ordered before q in the primary index
+func (p position) before(q position) bool {
+	if p.block != q.block {
+		return p.block > q.block
+	}
+	return p.index > q.index
+}
+
+// accountIndex holds the secondary indexes of the transactions of an account,
+// guarded by Storage.mu
+type accountIndex struct {
+	// keys of the stored transactions, block hash and hash
+	keys map[string]struct{}
+	// hashes maps the lower case transaction hash to the transaction
+	hashes map[string]common.Transaction
+	// counterparties maps the lower case address of the other party to its
+	// transactions, ordered as the primary index
+	counterparties map[string][]common.Transaction
+}
+
+// newAccountIndex builds the indexes of trans, which must be ordered as the primary index
+func newAccountIndex(address string, trans []common.Transaction) *accountIndex {
+	idx := &accountIndex{
+		keys:           make(map[string]struct{}, len(trans)),
+		hashes:         make(map[string]common.Transaction, len(trans)),
+		counterparties: make(map[string][]common.Transaction),
+	}
+	for _, tr := range trans {
+		if key, ok := txKey(tr); ok {
+			idx.keys[key] = struct{}{}
+			idx.hashes[strings.ToLower(tr.Hash)] = tr
+		}
+		cp := counterpartyOf(address, tr)
+		idx.counterparties[cp] = append(idx.counterparties[cp], tr)
+	}
+	return idx
+}
+
+// add indexes the new transactions, which must be ordered as the primary index
+func (idx *accountIndex) add(address string, trans []common.Transaction) {
+	groups := make(map[string][]common.Transaction)
+	for _, tr := range trans {
+		if key, ok := txKey(tr); ok {
+			idx.keys[key] = struct{}{}
+			idx.hashes[strings.ToLower(tr.Hash)] = tr
+		}
+		cp := counterpartyOf(address, tr)
+		groups[cp] = append(groups[cp], tr)
+	}
+	for cp, group := range groups {
+		idx.counterparties[cp] = merge(idx.counterparties[cp], group)
+	}
+}
+
+// counterpartyOf returns the lower case address of the other party of the transaction,
+// the account itself for a self transfer
+func counterpartyOf(address string, tr common.Transaction) string {
+	if strings.EqualFold(tr.From, address) {
+		if tr.To == "" {
+			return strings.ToLower(address)
+		}
+		return strings.ToLower(tr.To)
+	}
+	return strings.ToLower(tr.From)
+}
+
+// sortTransactions orders trans as the primary index, in place
+func sortTransactions(trans []common.Transaction) {
+	sort.SliceStable(trans, func(i, j int) bool {
+		return positionOf(trans[i]).before(positionOf(trans[j]))
+	})
+}
+
+// merge returns a new slice with the transactions of a and b, both ordered as the
+// primary index, transactions of a come first at the same position
+func merge(a, b []common.Transaction) []common.Transaction {
+	merged := make([]common.Transaction, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if positionOf(b[j]).before(positionOf(a[i])) {
+			merged = append(merged, b[j])
+			j++
+		} else {
+			merged = append(merged, a[i])
+			i++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	return append(merged, b[j:]...)
+}
+
+// blockRange returns the transactions of trans, ordered as the primary index,
+// within the inclusive block range
+func blockRange(trans []common.Transaction, fromBlock, toBlock int) []common.Transaction {
+	start := sort.Search(len(trans), func(i int) bool {
+		return positionOf(trans[i]).block <= int64(toBlock)
+	})
+	end := sort.Search(len(trans), func(i int) bool {
+		return positionOf(trans[i]).block < int64(fromBlock)
+	})
+	if start >= end {
+		return []common.Transaction{}
+	}
+	return trans[start:end]
+}
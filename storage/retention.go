@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// RemoveTransactions removes the given transactions of the address, identified by block hash
+// and hash, and returns the number removed. Transactions without hash cannot be identified and
+// are never removed.
+func (s *Storage) RemoveTransactions(ctx context.Context, address string, transactions []common.Transaction) (int, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.IsNewAccount(address) {
+		return 0, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	remove := make(map[string]struct{}, len(transactions))
+	for _, tr := range transactions {
+		if key, ok := txKey(tr); ok {
+			remove[key] = struct{}{}
+		}
+	}
+	if len(remove) == 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.transaction.Load(address)
+	if !ok {
+		return 0, nil
+	}
+	trans := data.([]common.Transaction)
+	kept := make([]common.Transaction, 0, len(trans))
+	for _, tr := range trans {
+		if key, ok := txKey(tr); ok {
+			if _, found := remove[key]; found {
+				continue
+			}
+		}
+		kept = append(kept, tr)
+	}
+	removed := len(trans) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	// the order of the primary index is kept, only the secondary indexes are rebuilt
+	s.transaction.Store(address, kept)
+	s.indexes.Store(address, newAccountIndex(address, kept))
+	metrics.StoredTransactions.Sub(float64(removed))
+	return removed, nil
+}
+
+// SetRetention sets the retention policy of the address, negative limits are rejected
+func (s *Storage) SetRetention(ctx context.Context, address string, policy common.RetentionPolicy) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if err := util.ValidateRetention(policy); err != nil {
+		return err
+	}
+
+	if s.IsNewAccount(address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	if policy == (common.RetentionPolicy{}) {
+		s.retention.Delete(address)
+		return nil
+	}
+	s.retention.Store(address, policy)
+	return nil
+}
+
+// GetRetention returns the retention policy of the address, the zero policy when none is set
+func (s *Storage) GetRetention(ctx context.Context, address string) (common.RetentionPolicy, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return common.RetentionPolicy{}, err
+	}
+
+	if s.IsNewAccount(address) {
+		return common.RetentionPolicy{}, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	if data, ok := s.retention.Load(address); ok {
+		return data.(common.RetentionPolicy), nil
+	}
+	return common.RetentionPolicy{}, nil
+}
@@ -1,25 +1,76 @@
 package storage
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"log"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	common "github.com/tonyxu1/transactionhistory/common"
+	logging "github.com/tonyxu1/transactionhistory/logging"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
 	util "github.com/tonyxu1/transactionhistory/util"
-
-	"github.com/ubiq/go-ubiq/common/hexutil"
 )
 
 type Storage struct {
-	account     sync.Map
+	account sync.Map
+	// transaction is the primary index, the transactions of each address
+	// ordered by block number and transaction index descending
 	transaction sync.Map
+	watchers    sync.Map
+
+	// indexes holds the *accountIndex of each address, mu guards the indexes
+	// and the writes of transaction
+	indexes sync.Map
+	mu      sync.RWMutex
+
+	headers headerCache
+
+	// retention holds the common.RetentionPolicy of the addresses having one
+	retention sync.Map
+
+	// balances holds the []common.BalanceCheckpoint of each address ordered by block ascending,
+	// mu guards its writes
+	balances sync.Map
+
+	// tokenTransfers holds the []common.TokenTransfer of each address ordered by block and log index,
+	// tokenCheckpoints the []common.TokenCheckpoint of each address ordered by token and block,
+	// mu guards their writes. tokenBlocks holds the first block whose token transfers of each
+	// address are not retrieved yet. tokens caches the common.Token metadata by contract address.
+	tokenTransfers   sync.Map
+	tokenCheckpoints sync.Map
+	tokenBlocks      sync.Map
+	tokens           sync.Map
+
+	// abis holds the common.ContractABI registered for each contract address,
+	// eventABIs the common.EventABI registered for each event topic
+	abis      sync.Map
+	eventABIs sync.Map
+}
+
+// txKey identifies a transaction by block hash and hash, a transaction
+// without hash cannot be identified and has no key
+func txKey(tr common.Transaction) (string, bool) {
+	if tr.Hash == "" {
+		return "", false
+	}
+	return tr.BlockHash + "|" + tr.Hash, true
+}
+
+// watcherList holds the channels of the clients watching an address, closed once the
+// address is removed
+type watcherList struct {
+	mu       sync.Mutex
+	channels map[chan common.Transaction]struct{}
+	closed   bool
 }
 
+// watcherBufferSize is the number of transactions buffered for each watcher
+// before new transactions are dropped for that watcher
+const watcherBufferSize = 64
+
 // Initiate a new storage
 // Storage implementation, can be replaceed by other storage methods
 
@@ -38,26 +89,52 @@ func (s *Storage) IsNewAccount(address string) bool {
 }
 
 // SaveAccountInfo Save account information
-func (s *Storage) CreateAccount(address string) error {
+func (s *Storage) CreateAccount(ctx context.Context, address string) error {
 	err := util.ValidateAddress(address)
 	if err != nil {
 		return err
 	}
 
 	if s.IsNewAccount(address) {
-		blockNum, err := getBlockNumFromChain()
+		blockNum, head, err := getBlockNumFromChain(ctx)
 		if err != nil {
 			return err
 		}
+		anchor := s.readAnchor(ctx, address, head)
 		s.account.Store(address, blockNum)
 		s.transaction.Store(address, []common.Transaction{}) //Empty transaction for the new account
+		metrics.Subscriptions.Inc()
+		logging.FromContext(ctx).Info("account subscribed", logging.AddressKey, address, logging.BlockKey, blockNum)
+		if anchor != nil {
+			if err := s.SaveBalance(ctx, address, *anchor); err != nil {
+				logging.FromContext(ctx).Warn("balance not anchored at subscription", logging.AddressKey, address, logging.ErrorKey, err)
+			}
+		}
 		return nil
 	}
 	return fmt.Errorf("account for address [%s] already subscribed", address)
 }
 
-// SaveTransactions append transactions to the account
-func (s *Storage) SaveTransactions(address string, transactions []common.Transaction) error {
+// RestoreAccount creates the account with the given checkpoint, used to load accounts
+// from an archive or a snapshot without reading the chain
+func (s *Storage) RestoreAccount(ctx context.Context, address string, block int) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if _, loaded := s.account.LoadOrStore(address, block); loaded {
+		return fmt.Errorf("account for address [%s] already subscribed", address)
+	}
+	s.transaction.Store(address, []common.Transaction{})
+	metrics.Subscriptions.Inc()
+	logging.FromContext(ctx).Info("account restored", logging.AddressKey, address, logging.BlockKey, block)
+	return nil
+}
+
+// RemoveAccount removes the account and its transactions from the storage,
+// watchers of the address are closed.
+func (s *Storage) RemoveAccount(ctx context.Context, address string) error {
 	err := util.ValidateAddress(address)
 	if err != nil {
 		return err
@@ -67,20 +144,226 @@ func (s *Storage) SaveTransactions(address string, transactions []common.Transac
 		return fmt.Errorf("account for address [%s] does not exist", address)
 	}
 
+	s.mu.Lock()
+	s.account.Delete(address)
+	s.indexes.Delete(address)
+	s.retention.Delete(address)
+	s.balances.Delete(address)
+	s.tokenTransfers.Delete(address)
+	s.tokenCheckpoints.Delete(address)
+	s.tokenBlocks.Delete(address)
+	data, ok := s.transaction.LoadAndDelete(address)
+	s.mu.Unlock()
+	if ok {
+		metrics.StoredTransactions.Sub(float64(len(data.([]common.Transaction))))
+	}
+	metrics.Subscriptions.Dec()
+	logging.FromContext(ctx).Info("account unsubscribed", logging.AddressKey, address)
+
+	if data, ok := s.watchers.LoadAndDelete(address); ok {
+		list := data.(*watcherList)
+		list.mu.Lock()
+		for ch := range list.channels {
+			close(ch)
+		}
+		list.channels = nil
+		list.closed = true
+		list.mu.Unlock()
+	}
+	return nil
+}
+
+// SaveTransactions adds transactions to the account in block order, transactions already
+// stored with the same block hash and hash are skipped so saving is idempotent
+func (s *Storage) SaveTransactions(ctx context.Context, address string, transactions []common.Transaction) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if s.IsNewAccount(address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	s.mu.Lock()
+	// the account may have been removed meanwhile
+	if s.IsNewAccount(address) {
+		s.mu.Unlock()
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+	existingTrans, idx := s.indexOf(address)
+
+	newTrans := make([]common.Transaction, 0, len(transactions))
+	batchKeys := make(map[string]struct{}, len(transactions))
+	for _, tr := range transactions {
+		if key, ok := txKey(tr); ok {
+			if _, exists := idx.keys[key]; exists {
+				continue
+			}
+			if _, exists := batchKeys[key]; exists {
+				continue
+			}
+			batchKeys[key] = struct{}{}
+		}
+		newTrans = append(newTrans, tr)
+	}
+	if skipped := len(transactions) - len(newTrans); skipped > 0 {
+		logging.FromContext(ctx).Debug("duplicate transactions skipped", logging.AddressKey, address, "count", skipped)
+	}
+
+	// watchers receive the transactions in the order they were saved
+	sorted := make([]common.Transaction, len(newTrans))
+	copy(sorted, newTrans)
+	sortTransactions(sorted)
+	idx.add(address, sorted)
+	s.transaction.Store(address, merge(existingTrans, sorted))
+	s.mu.Unlock()
+
+	metrics.StoredTransactions.Add(float64(len(newTrans)))
+	if len(newTrans) > 0 {
+		s.notifyWatchers(ctx, address, newTrans)
+	}
+	return nil
+}
+
+// indexOf returns the primary index and the secondary indexes of the address,
+// they are rebuilt when the indexes are missing, s.mu must be held for writing
+func (s *Storage) indexOf(address string) ([]common.Transaction, *accountIndex) {
+	trans := []common.Transaction{}
+	if data, ok := s.transaction.Load(address); ok {
+		trans = data.([]common.Transaction)
+	}
+	if data, ok := s.indexes.Load(address); ok {
+		return trans, data.(*accountIndex)
+	}
+
+	sorted := make([]common.Transaction, len(trans))
+	copy(sorted, trans)
+	sortTransactions(sorted)
+	s.transaction.Store(address, sorted)
+	idx := newAccountIndex(address, sorted)
+	s.indexes.Store(address, idx)
+	return sorted, idx
+}
+
+// DeduplicateTransactions removes the stored transactions of the address with the same
+// block hash and hash as an earlier one, it returns the number of transactions removed
+func (s *Storage) DeduplicateTransactions(ctx context.Context, address string) (int, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.IsNewAccount(address) {
+		return 0, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	data, ok := s.transaction.Load(address)
+	if !ok {
+		return 0, nil
+	}
+	trans := data.([]common.Transaction)
+	keys := make(map[string]struct{}, len(trans))
+	unique := make([]common.Transaction, 0, len(trans))
+	for _, tr := range trans {
+		if key, ok := txKey(tr); ok {
+			if _, exists := keys[key]; exists {
+				continue
+			}
+			keys[key] = struct{}{}
+		}
+		unique = append(unique, tr)
+	}
+	sortTransactions(unique)
+	s.transaction.Store(address, unique)
+	s.indexes.Store(address, newAccountIndex(address, unique))
+
+	removed := len(trans) - len(unique)
+	metrics.StoredTransactions.Sub(float64(removed))
+	if removed > 0 {
+		logging.FromContext(ctx).Info("duplicate transactions removed", logging.AddressKey, address, "count", removed)
+	}
+	return removed, nil
+}
+
+// WatchTransactions returns a channel receiving the transactions saved for the given
+// address from now on, the returned function must be called to stop watching.
+// The channel is closed when the watch is stopped or the account is removed.
+func (s *Storage) WatchTransactions(ctx context.Context, address string) (<-chan common.Transaction, func(), error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.IsNewAccount(address) {
+		return nil, nil, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	data, _ := s.watchers.LoadOrStore(address, &watcherList{})
+	list := data.(*watcherList)
+
+	ch := make(chan common.Transaction, watcherBufferSize)
+	list.mu.Lock()
+	// the account may be removed since it was checked, its list is then closed, or deleted
+	// before this one was stored
+	if list.closed || s.IsNewAccount(address) {
+		list.mu.Unlock()
+		return nil, nil, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+	if list.channels == nil {
+		list.channels = make(map[chan common.Transaction]struct{})
+	}
+	list.channels[ch] = struct{}{}
+	list.mu.Unlock()
+
+	stop := func() {
+		list.mu.Lock()
+		defer list.mu.Unlock()
+		if _, ok := list.channels[ch]; ok {
+			delete(list.channels, ch)
+			close(ch)
+		}
+	}
+	return ch, stop, nil
+}
 
+// notifyWatchers sends the new transactions to the watchers of the address,
+// a watcher that is not keeping up misses the transactions.
+func (s *Storage) notifyWatchers(ctx context.Context, address string, transactions []common.Transaction) {
+	data, ok := s.watchers.Load(address)
 	if !ok {
-		s.transaction.Store(address, transactions)
-		return nil
+		return
+	}
+	list := data.(*watcherList)
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	for ch := range list.channels {
+		for _, tr := range transactions {
+			select {
+			case ch <- tr:
+			default:
+				logging.FromContext(ctx).Warn("watcher is full, transaction dropped", logging.AddressKey, address, "hash", tr.Hash)
+			}
+		}
 	}
-	existingTrans := data.([]common.Transaction)
-	allTrans := append(existingTrans, transactions...)
-	s.transaction.Store(address, allTrans)
-	return nil
+}
+
+// GetAccounts : list the addresses of all accounts in the storage
+func (s *Storage) GetAccounts(ctx context.Context) ([]string, error) {
+	addresses := make([]string, 0)
+	s.account.Range(func(key, value any) bool {
+		addresses = append(addresses, key.(string))
+		return true
+	})
+	sort.Strings(addresses)
+	return addresses, nil
 }
 
 // GetCurrentBlock : get most recent block number in the storage for the given address
-func (s *Storage) GetCurrentBlock(address string) (int, error) {
+func (s *Storage) GetCurrentBlock(ctx context.Context, address string) (int, error) {
 	err := util.ValidateAddress(address)
 	if err != nil {
 		return -1, err
@@ -94,8 +377,9 @@ func (s *Storage) GetCurrentBlock(address string) (int, error) {
 	return d.(int), nil
 }
 
-// GetTransactions : retrieve the transaction history information from the storage
-func (s *Storage) GetTransactions(address string) ([]common.Transaction, error) {
+// GetTransactions : retrieve the transaction history information from the storage,
+// ordered by block number descending
+func (s *Storage) GetTransactions(ctx context.Context, address string) ([]common.Transaction, error) {
 	err := util.ValidateAddress(address)
 	if err != nil {
 		return []common.Transaction{}, err
@@ -104,141 +388,172 @@ func (s *Storage) GetTransactions(address string) ([]common.Transaction, error)
 		return []common.Transaction{}, fmt.Errorf("account for address [%s] does not exist", address)
 	}
 
-	data, _ := s.transaction.Load(address)
-	transHistory := data.([]common.Transaction)
+	return s.readIndex(address, func(trans []common.Transaction, idx *accountIndex) []common.Transaction {
+		return trans
+	}), nil
+}
 
-	//Order by Block Number descending
-	sort.Slice(transHistory, func(i, j int) bool {
-		a, err := hexutil.DecodeBig(transHistory[i].BlockNumber)
-		if err != nil {
-			return false
-		}
-		b, err := hexutil.DecodeBig(transHistory[j].BlockNumber)
-		if err != nil {
-			return false
-		}
+// GetTransactionsByBlockRange : retrieve the transactions of the address within the
+// inclusive block range, ordered by block number descending
+func (s *Storage) GetTransactionsByBlockRange(ctx context.Context, address string, fromBlock, toBlock int) ([]common.Transaction, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return []common.Transaction{}, err
+	}
+	if s.IsNewAccount(address) {
+		return []common.Transaction{}, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	return s.readIndex(address, func(trans []common.Transaction, idx *accountIndex) []common.Transaction {
+		return blockRange(trans, fromBlock, toBlock)
+	}), nil
+}
+
+// GetTransactionsByCounterparty : retrieve the transactions between the address and the
+// counterparty address, ordered by block number descending
+func (s *Storage) GetTransactionsByCounterparty(ctx context.Context, address string, counterparty string) ([]common.Transaction, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return []common.Transaction{}, err
+	}
+	err = util.ValidateAddress(counterparty)
+	if err != nil {
+		return []common.Transaction{}, err
+	}
+	if s.IsNewAccount(address) {
+		return []common.Transaction{}, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	return s.readIndex(address, func(trans []common.Transaction, idx *accountIndex) []common.Transaction {
+		return idx.counterparties[strings.ToLower(counterparty)]
+	}), nil
+}
 
-		return a.Int64() > b.Int64()
+// GetTransaction : retrieve the transaction of the address with the given hash, in any case
+func (s *Storage) GetTransaction(ctx context.Context, address string, hash string) (common.Transaction, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return common.Transaction{}, err
+	}
+	if s.IsNewAccount(address) {
+		return common.Transaction{}, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	var tr common.Transaction
+	found := false
+	s.readIndex(address, func(trans []common.Transaction, idx *accountIndex) []common.Transaction {
+		tr, found = idx.hashes[strings.ToLower(hash)]
+		return nil
 	})
+	if !found {
+		return common.Transaction{}, fmt.Errorf("transaction [%s] not found for address [%s]", hash, address)
+	}
+	return tr, nil
+}
 
-	return transHistory, nil
-}
-
-// getTransFromChain retrieve transaction data in batch manner
-// for given adddress
-func (s *Storage) UpdateAccountWithChainData(address string) error {
-
-	var (
-		wg         sync.WaitGroup
-		mu         = &sync.Mutex{}
-		blocksToGo = common.BLOCKSPERROUND
-	)
-
-	resultTrans := make([]common.Transaction, 0)
-	errs := make([]error, 0)
-	//search transactions from chain with  goroutines
-	for i := 0; i < common.NUMOFROUTINES; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for blocksToGo > 0 {
-				blockNum, _ := s.account.Load(address)
-				currentBlock := blockNum.(int)
-
-				blockNumStr := "0x" + strconv.FormatInt(int64(currentBlock), 16)
-				data, err := util.GetDataFromChain(fmt.Sprintf(common.GETBLOCKBYNUMBER, blockNumStr), common.TIMEOUT)
-				if err != nil {
-					mu.Lock()
-					errs = append(errs, err)
-					blocksToGo--
-					mu.Unlock()
-					s.account.Store(address, currentBlock+1)
-					return
-				}
-				var blockInfo common.Block
-				err = json.Unmarshal([]byte(data), &blockInfo)
-				if err != nil {
-					errResp := common.ResponseError{}
-					err1 := json.Unmarshal(data, &errResp)
-					if err1 != nil {
-						mu.Lock()
-						errs = append(errs, fmt.Errorf("%s | %s", err.Error(), err1.Error()))
-						blocksToGo--
-						mu.Unlock()
-						s.account.Store(address, currentBlock+1)
-						return
-					}
-					mu.Lock()
-					errs = append(errs, errors.New(errResp.Error.Message))
-					blocksToGo--
-					mu.Unlock()
-					s.account.Store(address, currentBlock+1)
-					return
-				}
-				trx := blockInfo.Result.Transactions
-
-				for _, tr := range trx {
-					if tr.From == address || tr.To == address {
-						mu.Lock()
-						resultTrans = append(resultTrans, tr)
-						mu.Unlock()
-					}
-				}
-				mu.Lock()
-				blocksToGo--
-				mu.Unlock()
-				s.account.Store(address, currentBlock+1)
-			}
-		}()
+// FindTransaction : find the stored transaction with the given hash in all the accounts,
+// with the role of each account it belongs to
+func (s *Storage) FindTransaction(ctx context.Context, hash string) (common.Transaction, []common.AccountMatch, error) {
+	err := util.ValidateHash(hash)
+	if err != nil {
+		return common.Transaction{}, nil, err
 	}
-	wg.Wait()
 
-	if len(errs) > 0 {
-		errMsg := ""
-		for _, v := range errs {
-			errMsg += v.Error()
-			if len(errMsg) > 200 {
-				break
-			}
+	addresses, err := s.GetAccounts(ctx)
+	if err != nil {
+		return common.Transaction{}, nil, err
+	}
+
+	var found common.Transaction
+	matches := []common.AccountMatch{}
+	for _, address := range addresses {
+		tr, err := s.GetTransaction(ctx, address, hash)
+		if err != nil {
+			continue
+		}
+		found = tr
+		if match, ok := util.MatchAccount(tr, address); ok {
+			matches = append(matches, match)
 		}
-		log.Println("errors:", errMsg)
-		return errors.New(errMsg)
 	}
-	if len(resultTrans) > 0 {
-		return s.SaveTransactions(address, resultTrans)
+	if found.Hash == "" {
+		return common.Transaction{}, nil, fmt.Errorf("transaction [%s] not found", hash)
 	}
-	return nil
+	return found, matches, nil
 }
 
-func (s *Storage) UpdateAllAccount() {
-	s.account.Range(func(key, value any) bool {
-		addr := key.(string)
-		log.Printf("Update account for address [%s] and block number [%d]\n", addr, value.(int))
-		err := s.UpdateAccountWithChainData(addr)
-		if err != nil {
-			log.Println("UpdateAllAccount() err: ", err)
+// readIndex returns a copy of the transactions selected by read from the primary index
+// and the secondary indexes of the address, the indexes are built first if missing
+func (s *Storage) readIndex(address string, read func(trans []common.Transaction, idx *accountIndex) []common.Transaction) []common.Transaction {
+	s.mu.RLock()
+	_, ok := s.indexes.Load(address)
+	s.mu.RUnlock()
+	if !ok {
+		s.mu.Lock()
+		if !s.IsNewAccount(address) {
+			s.indexOf(address)
 		}
-		return true
-	})
+		s.mu.Unlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trans := []common.Transaction{}
+	if data, ok := s.transaction.Load(address); ok {
+		trans = data.([]common.Transaction)
+	}
+	idx := &accountIndex{}
+	if data, ok := s.indexes.Load(address); ok {
+		idx = data.(*accountIndex)
+	}
+	selected := read(trans, idx)
+	result := make([]common.Transaction, len(selected))
+	copy(result, selected)
+	return result
 }
 
-func getBlockNumFromChain() (int, error) {
-	// get most current block number minus look
-	// back blocks currently set it to 1000000
-	// TODO: make it configurable
-	// & transaction count for the address
-	data, err := util.GetDataFromChain(common.BLOCKNUMBER, common.TIMEOUT)
+// SaveCheckpoint : save the next block to scan for the given address
+func (s *Storage) SaveCheckpoint(ctx context.Context, address string, block int) error {
+	err := util.ValidateAddress(address)
 	if err != nil {
-		return -1, err
+		return err
 	}
-	resp, err := util.ValidateChainData(data)
-	if err != nil {
-		return -1, err
+	// compare and swap so that an account removed meanwhile is not stored again
+	for {
+		old, ok := s.account.Load(address)
+		if !ok {
+			return fmt.Errorf("account for address [%s] does not exist", address)
+		}
+		if s.account.CompareAndSwap(address, old, block) {
+			return nil
+		}
 	}
+}
 
-	blockInHex := resp.Result
+// readAnchor reads the native balance of the new account at the chain head of its subscription,
+// less the confirmation depth of the network so that the block is not reorganized. Nil is returned
+// when the balance cannot be read, the reconciler anchors the account after the next scanner round instead.
+func (s *Storage) readAnchor(ctx context.Context, address string, head int) *common.BalanceCheckpoint {
+	block := head - util.NetworkFromContext(ctx).ConfirmationDepth
+	if block < 0 {
+		block = 0
+	}
+	balance, err := util.GetBalance(ctx, address, block)
+	if err != nil {
+		logging.FromContext(ctx).Warn("balance not anchored at subscription", logging.AddressKey, address, logging.BlockKey, block, logging.ErrorKey, err)
+		return nil
+	}
+	logging.FromContext(ctx).Info("balance anchored", logging.AddressKey, address, logging.BlockKey, block, "balance", balance.String())
+	return &common.BalanceCheckpoint{Block: block, Balance: balance.String(), FetchedAt: time.Now().UTC()}
+}
 
-	num, _ := strconv.ParseInt(blockInHex, 0, 64)
-	num -= common.LOOKBACKBLOCKS
-	return int(num), nil
+// getBlockNumFromChain returns the first block of a new account with the chain head, the
+// first block is the lookback blocks of the network carried by ctx behind the head, or the
+// genesis block of a chain younger than the lookback
+func getBlockNumFromChain(ctx context.Context) (int, int, error) {
+	head, err := util.GetChainHead(ctx)
+	if err != nil {
+		return -1, -1, err
+	}
+	return max(head-util.NetworkFromContext(ctx).LookbackBlocks, 0), head, nil
 }
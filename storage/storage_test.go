@@ -1,62 +1,55 @@
 package storage
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
-	"sync"
+	"strings"
 	"testing"
 
 	"github.com/tonyxu1/transactionhistory/common"
+	"github.com/tonyxu1/transactionhistory/util"
 )
 
 func TestNew(t *testing.T) {
 	tests := []struct {
 		name string
-		want Storage
+		want *Storage
 	}{
 		{
 			name: "New storage is created",
-			want: Storage{},
+			want: &Storage{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := New(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("New() = %v, want %v", got, tt.want)
+			if got := New(); !reflect.DeepEqual(&got, tt.want) {
+				t.Errorf("New() = %v, want %v", &got, tt.want)
 			}
 		})
 	}
 }
 
 func TestStorage_IsNewAccount(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
-	}
 	type args struct {
 		address string
 	}
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   bool
+		name string
+		args args
+		want bool
 	}{
 		{
 			name: "New Account",
-			fields: fields{
-				account:     sync.Map{},
-				transaction: sync.Map{},
-			},
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 			},
 			want: true,
 		}, {
 			name: "Existing Account",
-			fields: fields{
-				account:     sync.Map{},
-				transaction: sync.Map{},
-			},
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 			},
@@ -66,16 +59,13 @@ func TestStorage_IsNewAccount(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.name == "New Account" {
-				s := &Storage{
-					account:     tt.fields.account,
-					transaction: tt.fields.transaction,
-				}
+				s := New()
 				if got := s.IsNewAccount(tt.args.address); got != tt.want {
 					t.Errorf("Storage.IsNewAccount() = %v, want %v", got, tt.want)
 				}
 			} else if tt.name == "Existing Account" {
 				s := New()
-				err := s.CreateAccount("0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
+				err := s.CreateAccount(context.Background(), "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
 				if err != nil {
 					t.Errorf("s.CreateAccount() error : %v", err)
 				}
@@ -89,32 +79,22 @@ func TestStorage_IsNewAccount(t *testing.T) {
 }
 
 func TestStorage_CreateAccount(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
-	}
 	type args struct {
 		address string
 	}
 	tests := []struct {
 		name    string
-		fields  fields
 		args    args
 		wantErr bool
 	}{
 		{
 			name: "New account created",
-			fields: fields{
-				account:     sync.Map{},
-				transaction: sync.Map{},
-			},
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 			},
 			wantErr: false,
 		}, {
-			name:   "Existing Account",
-			fields: fields{},
+			name: "Existing Account",
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 			},
@@ -124,23 +104,17 @@ func TestStorage_CreateAccount(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.name == "Existing Account" {
-				s := &Storage{
-					account:     tt.fields.account,
-					transaction: tt.fields.transaction,
-				}
-				err := s.CreateAccount("0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
+				s := New()
+				err := s.CreateAccount(context.Background(), "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b")
 				if err != nil {
 					t.Errorf("s.CreateAccount() error : %v", err)
 				}
-				if err := s.CreateAccount(tt.args.address); (err != nil) != tt.wantErr {
+				if err := s.CreateAccount(context.Background(), tt.args.address); (err != nil) != tt.wantErr {
 					t.Errorf("Storage.CreateAccount() error = %v, wantErr %v", err, tt.wantErr)
 				}
 			} else {
-				s := &Storage{
-					account:     tt.fields.account,
-					transaction: tt.fields.transaction,
-				}
-				if err := s.CreateAccount(tt.args.address); (err != nil) != tt.wantErr {
+				s := New()
+				if err := s.CreateAccount(context.Background(), tt.args.address); (err != nil) != tt.wantErr {
 					t.Errorf("Storage.CreateAccount() error = %v, wantErr %v", err, tt.wantErr)
 				}
 			}
@@ -149,27 +123,67 @@ func TestStorage_CreateAccount(t *testing.T) {
 	}
 }
 
-func TestStorage_SaveTransactions(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
+func TestStorage_CreateAccount_Anchor(t *testing.T) {
+	const testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name       string
+		balance    string
+		wantBlocks []int
+	}{
+		{
+			name:       "Balance anchored at the confirmed head of the subscription",
+			balance:    `{"jsonrpc":"2.0","result":"0x64","id":4}`,
+			wantBlocks: []int{0x3e8 - 12},
+		}, {
+			name:       "Balance not read",
+			balance:    `{"jsonrpc":"2.0","error":{"code":-32000,"message":"missing trie node"},"id":4}`,
+			wantBlocks: []int{},
+		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if strings.Contains(string(body), "eth_blockNumber") {
+					w.Write([]byte(`{"jsonrpc":"2.0","result":"0x3e8","id":1}`))
+					return
+				}
+				w.Write([]byte(tt.balance))
+			}))
+			defer srv.Close()
+			ctx := util.WithNetwork(context.Background(), common.Network{Name: "test", RPCEndpoints: []string{srv.URL}, LookbackBlocks: 100, ConfirmationDepth: 12})
+
+			s := New()
+			if err := s.CreateAccount(ctx, testAddress); err != nil {
+				t.Fatalf("Storage.CreateAccount() error = %v", err)
+			}
+			if got, _ := s.GetCurrentBlock(ctx, testAddress); got != 0x3e8-100 {
+				t.Errorf("Storage.GetCurrentBlock() = %v, want %v", got, 0x3e8-100)
+			}
+			checkpoints, _ := s.GetBalances(ctx, testAddress)
+			blocks := make([]int, 0, len(checkpoints))
+			for _, c := range checkpoints {
+				blocks = append(blocks, c.Block)
+			}
+			if !reflect.DeepEqual(blocks, tt.wantBlocks) {
+				t.Errorf("Storage.GetBalances() blocks = %v, want %v", blocks, tt.wantBlocks)
+			}
+		})
+	}
+}
+
+func TestStorage_SaveTransactions(t *testing.T) {
 	type args struct {
 		address      string
 		transactions []common.Transaction
 	}
 	tests := []struct {
 		name    string
-		fields  fields
 		args    args
 		wantErr bool
 	}{
 		{
 			name: "Append transactions to existing account",
-			fields: fields{
-				account:     sync.Map{},
-				transaction: sync.Map{},
-			},
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 				transactions: []common.Transaction{
@@ -181,15 +195,12 @@ func TestStorage_SaveTransactions(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Storage{
-				account:     tt.fields.account,
-				transaction: tt.fields.transaction,
-			}
-			err := s.CreateAccount(tt.args.address)
+			s := New()
+			err := s.CreateAccount(context.Background(), tt.args.address)
 			if err != nil {
 				t.Errorf("s.CreateAccount() error : %v", err)
 			}
-			if err := s.SaveTransactions(tt.args.address, tt.args.transactions); (err != nil) != tt.wantErr {
+			if err := s.SaveTransactions(context.Background(), tt.args.address, tt.args.transactions); (err != nil) != tt.wantErr {
 				t.Errorf("Storage.SaveTransactions() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			count := 0
@@ -210,26 +221,17 @@ func TestStorage_SaveTransactions(t *testing.T) {
 }
 
 func TestStorage_GetCurrentBlock(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
-	}
 	type args struct {
 		address string
 	}
 	tests := []struct {
 		name    string
-		fields  fields
 		args    args
 		want    int
 		wantErr bool
 	}{
 		{
 			name: "Current block in storage",
-			fields: fields{
-				account:     sync.Map{},
-				transaction: sync.Map{},
-			},
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 			},
@@ -239,12 +241,9 @@ func TestStorage_GetCurrentBlock(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Storage{
-				account:     tt.fields.account,
-				transaction: tt.fields.transaction,
-			}
-			s.CreateAccount(tt.args.address)
-			got, err := s.GetCurrentBlock(tt.args.address)
+			s := New()
+			s.CreateAccount(context.Background(), tt.args.address)
+			got, err := s.GetCurrentBlock(context.Background(), tt.args.address)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Storage.GetCurrentBlock() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -257,26 +256,17 @@ func TestStorage_GetCurrentBlock(t *testing.T) {
 }
 
 func TestStorage_GetTransactions(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
-	}
 	type args struct {
 		address string
 	}
 	tests := []struct {
 		name    string
-		fields  fields
 		args    args
 		want    []common.Transaction
 		wantErr bool
 	}{
 		{
 			name: "Return sorted transaction array by block number desc",
-			fields: fields{
-				account:     sync.Map{},
-				transaction: sync.Map{},
-			},
 			args: args{
 				address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b",
 			},
@@ -291,15 +281,12 @@ func TestStorage_GetTransactions(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Storage{
-				account:     tt.fields.account,
-				transaction: tt.fields.transaction,
-			}
-			err := s.CreateAccount(tt.args.address)
+			s := New()
+			err := s.CreateAccount(context.Background(), tt.args.address)
 			if err != nil {
 				t.Errorf("s.CreateAccount() error : %v", err)
 			}
-			err = s.SaveTransactions(tt.args.address, []common.Transaction{
+			err = s.SaveTransactions(context.Background(), tt.args.address, []common.Transaction{
 				{BlockNumber: "0x1234"},
 				{BlockNumber: "0x1236"},
 				{BlockNumber: "0x1235"},
@@ -309,7 +296,7 @@ func TestStorage_GetTransactions(t *testing.T) {
 				t.Errorf("s.SaveTransactions() error : %v", err)
 			}
 
-			got, err := s.GetTransactions(tt.args.address)
+			got, err := s.GetTransactions(context.Background(), tt.args.address)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Storage.GetTransactions() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -321,78 +308,804 @@ func TestStorage_GetTransactions(t *testing.T) {
 	}
 }
 
-// TODO: Add test cases.
-func TestStorage_UpdateAccountWithChainData(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
+func Test_getBlockNumFromChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     string
+		want     int
+		wantHead int
+		wantErr  bool
+	}{
+		{name: "Lookback blocks behind the head", head: `{"jsonrpc":"2.0","result":"0x3e8","id":1}`, want: 0x3e8 - 100, wantHead: 0x3e8},
+		{name: "Chain younger than the lookback", head: `{"jsonrpc":"2.0","result":"0x32","id":1}`, want: 0, wantHead: 0x32},
+		{name: "Head not read", head: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"header not found"},"id":1}`, want: -1, wantHead: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.head))
+			}))
+			defer srv.Close()
+			ctx := util.WithNetwork(context.Background(), common.Network{Name: "test", RPCEndpoints: []string{srv.URL}, LookbackBlocks: 100})
+
+			got, head, err := getBlockNumFromChain(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getBlockNumFromChain() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want || head != tt.wantHead {
+				t.Errorf("getBlockNumFromChain() = %v, %v, want %v, %v", got, head, tt.want, tt.wantHead)
+			}
+		})
 	}
+}
+
+func TestStorage_RemoveAccount(t *testing.T) {
 	type args struct {
 		address string
 	}
 	tests := []struct {
 		name    string
-		fields  fields
+		exists  bool
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "Invalid address format",
+			args:    args{address: "0x134856623"},
+			wantErr: true,
+		}, {
+			name:    "Account does not exist",
+			args:    args{address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"},
+			wantErr: true,
+		}, {
+			name:    "Existing account removed",
+			exists:  true,
+			args:    args{address: "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Storage{
-				account:     tt.fields.account,
-				transaction: tt.fields.transaction,
+			s := &Storage{}
+			if tt.exists {
+				s.account.Store(tt.args.address, 14000000)
+				s.transaction.Store(tt.args.address, []common.Transaction{{BlockNumber: "0x1234"}})
 			}
-			if err := s.UpdateAccountWithChainData(tt.args.address); (err != nil) != tt.wantErr {
-				t.Errorf("Storage.UpdateAccountWithChainData() error = %v, wantErr %v", err, tt.wantErr)
+			if err := s.RemoveAccount(context.Background(), tt.args.address); (err != nil) != tt.wantErr {
+				t.Errorf("Storage.RemoveAccount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !s.IsNewAccount(tt.args.address) {
+				t.Errorf("Storage.RemoveAccount() account for address [%s] still exists", tt.args.address)
 			}
 		})
 	}
 }
 
-// TODO: Add test cases.
-func TestStorage_UpdateAllAccount(t *testing.T) {
-	type fields struct {
-		account     sync.Map
-		transaction sync.Map
+func TestStorage_WatchTransactions(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name    string
+		remove  bool
+		closed  bool
+		want    []common.Transaction
+		wantErr bool
+	}{
+		{
+			name: "Saved transactions are received",
+			want: []common.Transaction{{BlockNumber: "0x1234"}, {BlockNumber: "0x1235"}},
+		}, {
+			name:   "Channel closed when account removed",
+			remove: true,
+		}, {
+			name:    "List closed by a removal after it was loaded",
+			closed:  true,
+			wantErr: true,
+		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Storage{}
+			s.account.Store(address, 14000000)
+			s.transaction.Store(address, []common.Transaction{})
+			if tt.closed {
+				s.watchers.Store(address, &watcherList{closed: true})
+			}
+
+			ch, stop, err := s.WatchTransactions(context.Background(), address)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.WatchTransactions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			defer stop()
+
+			if tt.remove {
+				if err := s.RemoveAccount(context.Background(), address); err != nil {
+					t.Errorf("s.RemoveAccount() error : %v", err)
+				}
+				if _, ok := <-ch; ok {
+					t.Errorf("Storage.WatchTransactions() channel is not closed")
+				}
+				return
+			}
+
+			if err := s.SaveTransactions(context.Background(), address, tt.want); err != nil {
+				t.Errorf("s.SaveTransactions() error : %v", err)
+			}
+			got := []common.Transaction{}
+			for range tt.want {
+				got = append(got, <-ch)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Storage.WatchTransactions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_SaveTransactions_Idempotent(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
 	tests := []struct {
-		name   string
-		fields fields
+		name    string
+		batches [][]common.Transaction
+		want    int
 	}{
-		// TODO: Add test cases.
+		{
+			name: "Same batch saved twice",
+			batches: [][]common.Transaction{
+				{{BlockHash: "0xb1", Hash: "0x01"}, {BlockHash: "0xb1", Hash: "0x02"}},
+				{{BlockHash: "0xb1", Hash: "0x01"}, {BlockHash: "0xb1", Hash: "0x02"}},
+			},
+			want: 2,
+		}, {
+			name: "Duplicates within a batch",
+			batches: [][]common.Transaction{
+				{{BlockHash: "0xb1", Hash: "0x01"}, {BlockHash: "0xb1", Hash: "0x01"}},
+			},
+			want: 1,
+		}, {
+			name: "Same hash in another block is kept",
+			batches: [][]common.Transaction{
+				{{BlockHash: "0xb1", Hash: "0x01"}},
+				{{BlockHash: "0xb2", Hash: "0x01"}},
+			},
+			want: 2,
+		}, {
+			name: "Transactions without hash are kept",
+			batches: [][]common.Transaction{
+				{{BlockNumber: "0x1234"}},
+				{{BlockNumber: "0x1234"}},
+			},
+			want: 2,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Storage{
-				account:     tt.fields.account,
-				transaction: tt.fields.transaction,
+			s := &Storage{}
+			s.account.Store(address, 14000000)
+			for _, batch := range tt.batches {
+				if err := s.SaveTransactions(context.Background(), address, batch); err != nil {
+					t.Fatalf("Storage.SaveTransactions() error = %v", err)
+				}
+			}
+			data, _ := s.transaction.Load(address)
+			if got := len(data.([]common.Transaction)); got != tt.want {
+				t.Errorf("Storage.SaveTransactions() stored %d transactions, want %d", got, tt.want)
 			}
-			s.UpdateAllAccount()
 		})
 	}
 }
 
-// TODO: Add test cases.
-func Test_getBlockNumFromChain(t *testing.T) {
+func TestStorage_DeduplicateTransactions(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
 	tests := []struct {
 		name    string
+		exists  bool
+		stored  []common.Transaction
 		want    int
+		wantLen int
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "Account does not exist",
+			wantErr: true,
+		}, {
+			name:   "Duplicates removed",
+			exists: true,
+			stored: []common.Transaction{
+				{BlockHash: "0xb1", Hash: "0x01"},
+				{BlockHash: "0xb1", Hash: "0x02"},
+				{BlockHash: "0xb1", Hash: "0x01"},
+				{BlockHash: "0xb2", Hash: "0x01"},
+			},
+			want:    1,
+			wantLen: 3,
+		}, {
+			name:    "No duplicates",
+			exists:  true,
+			stored:  []common.Transaction{{BlockHash: "0xb1", Hash: "0x01"}},
+			want:    0,
+			wantLen: 1,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := getBlockNumFromChain()
+			s := &Storage{}
+			if tt.exists {
+				s.account.Store(address, 14000000)
+				s.transaction.Store(address, tt.stored)
+			}
+			got, err := s.DeduplicateTransactions(context.Background(), address)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("getBlockNumFromChain() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Storage.DeduplicateTransactions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Storage.DeduplicateTransactions() = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			data, _ := s.transaction.Load(address)
+			if gotLen := len(data.([]common.Transaction)); gotLen != tt.wantLen {
+				t.Errorf("Storage.DeduplicateTransactions() left %d transactions, want %d", gotLen, tt.wantLen)
+			}
+
+			// saving a removed duplicate again is a no-op
+			if err := s.SaveTransactions(context.Background(), address, tt.stored); err != nil {
+				t.Fatalf("Storage.SaveTransactions() error = %v", err)
+			}
+			data, _ = s.transaction.Load(address)
+			if gotLen := len(data.([]common.Transaction)); gotLen != tt.wantLen {
+				t.Errorf("Storage.SaveTransactions() after dedup stored %d transactions, want %d", gotLen, tt.wantLen)
+			}
+		})
+	}
+}
+
+// indexedStorage returns a storage with the account of address holding transactions
+// in blocks 0x10 to 0x13, saved out of order
+func indexedStorage(t *testing.T, address string) *Storage {
+	s := &Storage{}
+	s.account.Store(address, 14000000)
+	err := s.SaveTransactions(context.Background(), address, []common.Transaction{
+		{BlockHash: "0xb12", BlockNumber: "0x12", TransactionIndex: "0x1", Hash: "0x03", From: address, To: "0x1111111111111111111111111111111111111111"},
+		{BlockHash: "0xb10", BlockNumber: "0x10", TransactionIndex: "0x0", Hash: "0x01", From: "0x2222222222222222222222222222222222222222", To: address},
+		{BlockHash: "0xb13", BlockNumber: "0x13", TransactionIndex: "0x0", Hash: "0x05", From: "0x1111111111111111111111111111111111111111", To: address},
+	})
+	if err != nil {
+		t.Fatalf("Storage.SaveTransactions() error = %v", err)
+	}
+	err = s.SaveTransactions(context.Background(), address, []common.Transaction{
+		{BlockHash: "0xb12", BlockNumber: "0x12", TransactionIndex: "0x0", Hash: "0x02", From: address, To: address},
+		{BlockHash: "0xb12", BlockNumber: "0x12", TransactionIndex: "0x2", Hash: "0x04", From: address, To: "0x1111111111111111111111111111111111111111"},
+	})
+	if err != nil {
+		t.Fatalf("Storage.SaveTransactions() error = %v", err)
+	}
+	return s
+}
+
+func hashesOf(trans []common.Transaction) []string {
+	hashes := make([]string, 0, len(trans))
+	for _, tr := range trans {
+		hashes = append(hashes, tr.Hash)
+	}
+	return hashes
+}
+
+func TestStorage_GetTransactionsByBlockRange(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name      string
+		fromBlock int
+		toBlock   int
+		want      []string
+	}{
+		{
+			name:      "All blocks ordered by block and index",
+			fromBlock: 0,
+			toBlock:   100,
+			want:      []string{"0x05", "0x04", "0x03", "0x02", "0x01"},
+		}, {
+			name:      "Single block",
+			fromBlock: 0x12,
+			toBlock:   0x12,
+			want:      []string{"0x04", "0x03", "0x02"},
+		}, {
+			name:      "Open range bounds",
+			fromBlock: 0x11,
+			toBlock:   0x12,
+			want:      []string{"0x04", "0x03", "0x02"},
+		}, {
+			name:      "Empty range",
+			fromBlock: 0x14,
+			toBlock:   0x20,
+			want:      []string{},
+		},
+	}
+	s := indexedStorage(t, address)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetTransactionsByBlockRange(context.Background(), address, tt.fromBlock, tt.toBlock)
+			if err != nil {
+				t.Fatalf("Storage.GetTransactionsByBlockRange() error = %v", err)
+			}
+			if !reflect.DeepEqual(hashesOf(got), tt.want) {
+				t.Errorf("Storage.GetTransactionsByBlockRange() = %v, want %v", hashesOf(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_GetTransactionsByCounterparty(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name         string
+		counterparty string
+		want         []string
+		wantErr      bool
+	}{
+		{
+			name:         "Both directions",
+			counterparty: "0x1111111111111111111111111111111111111111",
+			want:         []string{"0x05", "0x04", "0x03"},
+		}, {
+			name:         "Self transfer",
+			counterparty: "0x23CA95B9DE14A83CBF4A43B11C2C3825E72C7D9B",
+			want:         []string{"0x02"},
+		}, {
+			name:         "Unknown counterparty",
+			counterparty: "0x3333333333333333333333333333333333333333",
+			want:         []string{},
+		}, {
+			name:         "Invalid counterparty",
+			counterparty: "0x1234",
+			wantErr:      true,
+		},
+	}
+	s := indexedStorage(t, address)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetTransactionsByCounterparty(context.Background(), address, tt.counterparty)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Storage.GetTransactionsByCounterparty() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(hashesOf(got), tt.want) {
+				t.Errorf("Storage.GetTransactionsByCounterparty() = %v, want %v", hashesOf(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_GetTransaction(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name      string
+		hash      string
+		wantBlock string
+		wantErr   bool
+	}{
+		{
+			name:      "Stored transaction",
+			hash:      "0x03",
+			wantBlock: "0x12",
+		}, {
+			name:    "Unknown transaction",
+			hash:    "0x06",
+			wantErr: true,
+		},
+	}
+	s := indexedStorage(t, address)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetTransaction(context.Background(), address, tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Storage.GetTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.BlockNumber != tt.wantBlock {
+				t.Errorf("Storage.GetTransaction() block = %v, want %v", got.BlockNumber, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestStorage_FindTransaction(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	other := "0x1111111111111111111111111111111111111111"
+	hash := "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+	tests := []struct {
+		name    string
+		hash    string
+		want    []common.AccountMatch
+		wantErr bool
+	}{
+		{
+			name: "Stored in both accounts",
+			hash: hash,
+			want: []common.AccountMatch{
+				{Address: other, Role: common.ROLERECIPIENT},
+				{Address: address, Role: common.ROLESENDER},
+			},
+		}, {
+			name: "Stored with a mixed case hash",
+			hash: "0x88DF016429689C079F3B2F6AD39FA052532C56795B733DA78A91EBE6A713944b",
+			want: []common.AccountMatch{
+				{Address: other, Role: common.ROLERECIPIENT},
+				{Address: address, Role: common.ROLESENDER},
+			},
+		}, {
+			name:    "Not stored",
+			hash:    "0x98df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b",
+			wantErr: true,
+		}, {
+			name:    "Invalid hash",
+			hash:    "0x1234",
+			wantErr: true,
+		},
+	}
+	s := &Storage{}
+	tr := common.Transaction{BlockHash: "0xb1", BlockNumber: "0x10", Hash: hash, From: address, To: other}
+	for _, a := range []string{address, other} {
+		s.account.Store(a, 14000000)
+		if err := s.SaveTransactions(context.Background(), a, []common.Transaction{tr}); err != nil {
+			t.Fatalf("Storage.SaveTransactions() error = %v", err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matches, err := s.FindTransaction(context.Background(), tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Storage.FindTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
 				return
 			}
+			if got.Hash != hash {
+				t.Errorf("Storage.FindTransaction() hash = %v, want %v", got.Hash, hash)
+			}
+			if !reflect.DeepEqual(matches, tt.want) {
+				t.Errorf("Storage.FindTransaction() accounts = %v, want %v", matches, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_SaveHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		saved   int
+		get     int
+		wantErr bool
+	}{
+		{
+			name:  "Cached header",
+			saved: 10,
+			get:   5,
+		}, {
+			name:    "Header not saved",
+			saved:   10,
+			get:     10,
+			wantErr: true,
+		}, {
+			name:    "Oldest header evicted",
+			saved:   common.HEADERCACHESIZE + 1,
+			get:     0,
+			wantErr: true,
+		}, {
+			name:  "Newest header kept",
+			saved: common.HEADERCACHESIZE + 1,
+			get:   common.HEADERCACHESIZE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Storage{}
+			for i := 0; i < tt.saved; i++ {
+				header := common.Header{Number: fmt.Sprintf("0x%x", i), Timestamp: fmt.Sprintf("0x%x", 1700000000+i)}
+				if err := s.SaveHeader(context.Background(), header); err != nil {
+					t.Fatalf("Storage.SaveHeader() error = %v", err)
+				}
+			}
+			got, err := s.GetHeader(context.Background(), tt.get)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Storage.GetHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Number != fmt.Sprintf("0x%x", tt.get) {
+				t.Errorf("Storage.GetHeader() = %v, want block %d", got, tt.get)
+			}
+		})
+	}
+}
+
+func TestStorage_RemoveTransactions(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name        string
+		remove      []common.Transaction
+		want        int
+		wantHashes  []string
+		wantCounter []string
+	}{
+		{
+			name:        "Transactions removed by block hash and hash",
+			remove:      []common.Transaction{{BlockHash: "0xb12", Hash: "0x03"}, {BlockHash: "0xb10", Hash: "0x01"}},
+			want:        2,
+			wantHashes:  []string{"0x05", "0x04", "0x02"},
+			wantCounter: []string{"0x05", "0x04"},
+		}, {
+			name:        "Transaction of another block kept",
+			remove:      []common.Transaction{{BlockHash: "0xb13", Hash: "0x03"}, {Hash: ""}},
+			want:        0,
+			wantHashes:  []string{"0x05", "0x04", "0x03", "0x02", "0x01"},
+			wantCounter: []string{"0x05", "0x04", "0x03"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := indexedStorage(t, address)
+			got, err := s.RemoveTransactions(context.Background(), address, tt.remove)
+			if err != nil {
+				t.Fatalf("Storage.RemoveTransactions() error = %v", err)
+			}
 			if got != tt.want {
-				t.Errorf("getBlockNumFromChain() = %v, want %v", got, tt.want)
+				t.Errorf("Storage.RemoveTransactions() = %v, want %v", got, tt.want)
+			}
+			trans, _ := s.GetTransactions(context.Background(), address)
+			if !reflect.DeepEqual(hashesOf(trans), tt.wantHashes) {
+				t.Errorf("Storage.GetTransactions() = %v, want %v", hashesOf(trans), tt.wantHashes)
+			}
+			trans, _ = s.GetTransactionsByCounterparty(context.Background(), address, "0x1111111111111111111111111111111111111111")
+			if !reflect.DeepEqual(hashesOf(trans), tt.wantCounter) {
+				t.Errorf("Storage.GetTransactionsByCounterparty() = %v, want %v", hashesOf(trans), tt.wantCounter)
 			}
 		})
 	}
 }
+
+func TestStorage_SetRetention(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name    string
+		address string
+		policy  common.RetentionPolicy
+		want    common.RetentionPolicy
+		wantErr bool
+	}{
+		{
+			name:    "Policy set",
+			address: address,
+			policy:  common.RetentionPolicy{Days: 30, Transactions: 1000},
+			want:    common.RetentionPolicy{Days: 30, Transactions: 1000},
+		}, {
+			name:    "Negative limit rejected",
+			address: address,
+			policy:  common.RetentionPolicy{Blocks: -1},
+			want:    common.RetentionPolicy{Days: 30, Transactions: 1000},
+			wantErr: true,
+		}, {
+			name:    "Zero policy keeps everything",
+			address: address,
+			policy:  common.RetentionPolicy{},
+			want:    common.RetentionPolicy{},
+		}, {
+			name:    "Account does not exist",
+			address: "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36",
+			policy:  common.RetentionPolicy{Days: 1},
+			wantErr: true,
+		},
+	}
+	s := &Storage{}
+	s.account.Store(address, 14000000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SetRetention(context.Background(), tt.address, tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("Storage.SetRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, _ := s.GetRetention(context.Background(), tt.address)
+			if got != tt.want {
+				t.Errorf("Storage.GetRetention() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_SaveBalance(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	tests := []struct {
+		name       string
+		checkpoint common.BalanceCheckpoint
+		address    string
+		want       []int
+		wantErr    bool
+	}{
+		{
+			name:       "First checkpoint saved",
+			address:    address,
+			checkpoint: common.BalanceCheckpoint{Block: 20, Balance: "100"},
+			want:       []int{20},
+		}, {
+			name:       "Earlier checkpoint ordered first",
+			address:    address,
+			checkpoint: common.BalanceCheckpoint{Block: 10, Balance: "50"},
+			want:       []int{10, 20},
+		}, {
+			name:       "Checkpoint of the same block replaced",
+			address:    address,
+			checkpoint: common.BalanceCheckpoint{Block: 20, Balance: "120"},
+			want:       []int{10, 20},
+		}, {
+			name:       "Account does not exist",
+			address:    "0xE946502872DA09009Aa6dc975272AC24Ab5B4f36",
+			checkpoint: common.BalanceCheckpoint{Block: 20},
+			wantErr:    true,
+		},
+	}
+	s := &Storage{}
+	s.account.Store(address, 14000000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SaveBalance(context.Background(), tt.address, tt.checkpoint); (err != nil) != tt.wantErr {
+				t.Errorf("Storage.SaveBalance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _ := s.GetBalances(context.Background(), tt.address)
+			blocks := make([]int, 0, len(got))
+			for _, c := range got {
+				blocks = append(blocks, c.Block)
+			}
+			if !reflect.DeepEqual(blocks, tt.want) {
+				t.Errorf("Storage.GetBalances() = %v, want %v", blocks, tt.want)
+			}
+		})
+	}
+	got, _ := s.GetBalances(context.Background(), address)
+	if got[1].Balance != "120" {
+		t.Errorf("Storage.GetBalances() balance = %s, want 120", got[1].Balance)
+	}
+}
+
+func TestStorage_SaveTokenTransfers(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	token := "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	tests := []struct {
+		name      string
+		transfers []common.TokenTransfer
+		want      []string
+	}{
+		{
+			name: "Transfers ordered by block and log index",
+			transfers: []common.TokenTransfer{
+				{Token: token, BlockNumber: 12, BlockHash: "0xb12", TransactionHash: "0x03", LogIndex: 1},
+				{Token: token, BlockNumber: 10, BlockHash: "0xb10", TransactionHash: "0x01", LogIndex: 5},
+				{Token: token, BlockNumber: 12, BlockHash: "0xb12", TransactionHash: "0x02", LogIndex: 0},
+			},
+			want: []string{"0x01", "0x02", "0x03"},
+		}, {
+			name: "Transfers already stored skipped",
+			transfers: []common.TokenTransfer{
+				{Token: token, BlockNumber: 10, BlockHash: "0xb10", TransactionHash: "0x01", LogIndex: 5},
+				{Token: token, BlockNumber: 11, BlockHash: "0xb11", TransactionHash: "0x04", LogIndex: 0},
+				{Token: token, BlockNumber: 11, BlockHash: "0xb11", TransactionHash: "0x04", LogIndex: 0},
+			},
+			want: []string{"0x01", "0x04", "0x02", "0x03"},
+		},
+	}
+	s := &Storage{}
+	s.account.Store(address, 14000000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SaveTokenTransfers(context.Background(), address, tt.transfers); err != nil {
+				t.Fatalf("Storage.SaveTokenTransfers() error = %v", err)
+			}
+			transfers, _ := s.GetTokenTransfers(context.Background(), address)
+			got := make([]string, 0, len(transfers))
+			for _, tr := range transfers {
+				got = append(got, tr.TransactionHash)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Storage.GetTokenTransfers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_SaveTokenCheckpoint(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	usdc := "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	dai := "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+	s := &Storage{}
+	s.account.Store(address, 14000000)
+	checkpoints := []common.TokenCheckpoint{
+		{Token: usdc, BalanceCheckpoint: common.BalanceCheckpoint{Block: 20, Balance: "1"}},
+		{Token: dai, BalanceCheckpoint: common.BalanceCheckpoint{Block: 30, Balance: "2"}},
+		{Token: usdc, BalanceCheckpoint: common.BalanceCheckpoint{Block: 10, Balance: "3"}},
+		{Token: usdc, BalanceCheckpoint: common.BalanceCheckpoint{Block: 20, Balance: "4"}},
+	}
+	for _, c := range checkpoints {
+		if err := s.SaveTokenCheckpoint(context.Background(), address, c); err != nil {
+			t.Fatalf("Storage.SaveTokenCheckpoint() error = %v", err)
+		}
+	}
+	got, _ := s.GetTokenCheckpoints(context.Background(), address)
+	want := []string{"0x6b175474e89094c44da98b954eedeac495271d0f@30=2", usdc + "@10=3", usdc + "@20=4"}
+	gotKeys := make([]string, 0, len(got))
+	for _, c := range got {
+		gotKeys = append(gotKeys, fmt.Sprintf("%s@%d=%s", c.Token, c.Block, c.Balance))
+	}
+	if !reflect.DeepEqual(gotKeys, want) {
+		t.Errorf("Storage.GetTokenCheckpoints() = %v, want %v", gotKeys, want)
+	}
+	if err := s.SaveTokenCheckpoint(context.Background(), address, common.TokenCheckpoint{Token: "usdc"}); err == nil {
+		t.Errorf("Storage.SaveTokenCheckpoint() error = nil for invalid token address")
+	}
+}
+
+func TestStorage_SaveABI(t *testing.T) {
+	usdc := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	dai := "0x6b175474e89094c44da98b954eedeac495271d0f"
+	s := &Storage{}
+	tests := []struct {
+		name     string
+		contract common.ContractABI
+		wantErr  bool
+	}{
+		{name: "Registered", contract: common.ContractABI{Address: usdc, ABI: []byte(`[]`)}},
+		{name: "Replaced", contract: common.ContractABI{Address: usdc, ABI: []byte(`[{"type":"function","name":"transfer"}]`)}},
+		{name: "Second contract", contract: common.ContractABI{Address: dai, ABI: []byte(`[]`)}},
+		{name: "Invalid address", contract: common.ContractABI{Address: "dai", ABI: []byte(`[]`)}, wantErr: true},
+		{name: "Empty abi", contract: common.ContractABI{Address: dai}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SaveABI(context.Background(), tt.contract); (err != nil) != tt.wantErr {
+				t.Errorf("Storage.SaveABI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.GetABI(context.Background(), strings.ToLower(usdc))
+	if err != nil || string(got.ABI) != `[{"type":"function","name":"transfer"}]` {
+		t.Errorf("Storage.GetABI() = %s, %v, want the replaced abi", got.ABI, err)
+	}
+	all, _ := s.GetABIs(context.Background())
+	if len(all) != 2 || all[0].Address != dai || all[1].Address != strings.ToLower(usdc) {
+		t.Errorf("Storage.GetABIs() = %v, want dai then usdc", all)
+	}
+	if err := s.RemoveABI(context.Background(), usdc); err != nil {
+		t.Errorf("Storage.RemoveABI() error = %v", err)
+	}
+	if _, err := s.GetABI(context.Background(), usdc); err == nil {
+		t.Errorf("Storage.GetABI() error = nil after Storage.RemoveABI()")
+	}
+}
+
+func TestStorage_SaveEventABI(t *testing.T) {
+	transfer := "0xDDF252AD1BE2C89B69C2B068FC378DAA952BA7F163C4A11628F55A4DF523B3EF"
+	s := &Storage{}
+	if err := s.SaveEventABI(context.Background(), common.EventABI{Topic: transfer, ABI: []byte(`[]`)}); err != nil {
+		t.Fatalf("Storage.SaveEventABI() error = %v", err)
+	}
+	if err := s.SaveEventABI(context.Background(), common.EventABI{Topic: "0xddf252ad", ABI: []byte(`[]`)}); err == nil {
+		t.Errorf("Storage.SaveEventABI() error = nil for invalid topic")
+	}
+	got, err := s.GetEventABI(context.Background(), strings.ToLower(transfer))
+	if err != nil || got.Topic != strings.ToLower(transfer) {
+		t.Errorf("Storage.GetEventABI() = %v, %v, want the event abi of %s", got, err, transfer)
+	}
+	if all, _ := s.GetEventABIs(context.Background()); len(all) != 1 {
+		t.Errorf("Storage.GetEventABIs() = %v, want 1 event abi", all)
+	}
+	if err := s.RemoveEventABI(context.Background(), transfer); err != nil {
+		t.Errorf("Storage.RemoveEventABI() error = %v", err)
+	}
+	if err := s.RemoveEventABI(context.Background(), transfer); err == nil {
+		t.Errorf("Storage.RemoveEventABI() error = nil for a removed event abi")
+	}
+}
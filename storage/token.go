@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+// transferKey identifies a token transfer by block hash, transaction hash and log index
+func transferKey(t common.TokenTransfer) string {
+	return fmt.Sprintf("%s|%s|%d", t.BlockHash, t.TransactionHash, t.LogIndex)
+}
+
+// SaveTokenTransfers saves the token transfers of the address, the transfers already stored are skipped
+func (s *Storage) SaveTokenTransfers(ctx context.Context, address string, transfers []common.TokenTransfer) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if s.IsNewAccount(address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var existing []common.TokenTransfer
+	if data, ok := s.tokenTransfers.Load(address); ok {
+		existing = data.([]common.TokenTransfer)
+	}
+	keys := make(map[string]struct{}, len(existing)+len(transfers))
+	for _, t := range existing {
+		keys[transferKey(t)] = struct{}{}
+	}
+	// copy on write, readers keep the slice they loaded
+	merged := make([]common.TokenTransfer, len(existing), len(existing)+len(transfers))
+	copy(merged, existing)
+	for _, t := range transfers {
+		key := transferKey(t)
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		keys[key] = struct{}{}
+		merged = append(merged, t)
+	}
+	if len(merged) == len(existing) {
+		return nil
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].BlockNumber != merged[j].BlockNumber {
+			return merged[i].BlockNumber < merged[j].BlockNumber
+		}
+		return merged[i].LogIndex < merged[j].LogIndex
+	})
+	s.tokenTransfers.Store(address, merged)
+	return nil
+}
+
+// GetTokenTransfers returns the token transfers of the address ordered by block and log index ascending
+func (s *Storage) GetTokenTransfers(ctx context.Context, address string) ([]common.TokenTransfer, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.IsNewAccount(address) {
+		return nil, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	if data, ok := s.tokenTransfers.Load(address); ok {
+		return data.([]common.TokenTransfer), nil
+	}
+	return []common.TokenTransfer{}, nil
+}
+
+// SaveTokenCheckpoint saves a token balance checkpoint of the address, the checkpoint of the
+// same token and block is replaced
+func (s *Storage) SaveTokenCheckpoint(ctx context.Context, address string, checkpoint common.TokenCheckpoint) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if err := util.ValidateAddress(checkpoint.Token); err != nil {
+		return err
+	}
+
+	if s.IsNewAccount(address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	checkpoint.Token = strings.ToLower(checkpoint.Token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var checkpoints []common.TokenCheckpoint
+	if data, ok := s.tokenCheckpoints.Load(address); ok {
+		checkpoints = data.([]common.TokenCheckpoint)
+	}
+	i := sort.Search(len(checkpoints), func(i int) bool {
+		if checkpoints[i].Token != checkpoint.Token {
+			return checkpoints[i].Token > checkpoint.Token
+		}
+		return checkpoints[i].Block >= checkpoint.Block
+	})
+	updated := make([]common.TokenCheckpoint, 0, len(checkpoints)+1)
+	updated = append(updated, checkpoints[:i]...)
+	updated = append(updated, checkpoint)
+	if i < len(checkpoints) && checkpoints[i].Token == checkpoint.Token && checkpoints[i].Block == checkpoint.Block {
+		i++
+	}
+	updated = append(updated, checkpoints[i:]...)
+	s.tokenCheckpoints.Store(address, updated)
+	return nil
+}
+
+// GetTokenCheckpoints returns the token balance checkpoints of the address ordered by token then block ascending
+func (s *Storage) GetTokenCheckpoints(ctx context.Context, address string) ([]common.TokenCheckpoint, error) {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.IsNewAccount(address) {
+		return nil, fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	if data, ok := s.tokenCheckpoints.Load(address); ok {
+		return data.([]common.TokenCheckpoint), nil
+	}
+	return []common.TokenCheckpoint{}, nil
+}
+
+// SaveTokenBlock saves the first block whose token transfers of the address are not retrieved yet
+func (s *Storage) SaveTokenBlock(ctx context.Context, address string, block int) error {
+	err := util.ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if s.IsNewAccount(address) {
+		return fmt.Errorf("account for address [%s] does not exist", address)
+	}
+
+	s.tokenBlocks.Store(address, block)
+	return nil
+}
+
+// GetTokenBlock returns the first block whose token transfers of the address are not retrieved
+// yet, the checkpoint of the account until a token block is saved
+func (s *Storage) GetTokenBlock(ctx context.Context, address string) (int, error) {
+	if data, ok := s.tokenBlocks.Load(address); ok {
+		return data.(int), nil
+	}
+	return s.GetCurrentBlock(ctx, address)
+}
+
+// SaveToken saves the metadata of a token to the token cache
+func (s *Storage) SaveToken(ctx context.Context, token common.Token) error {
+	if err := util.ValidateAddress(token.Address); err != nil {
+		return err
+	}
+	token.Address = strings.ToLower(token.Address)
+	s.tokens.Store(token.Address, token)
+	return nil
+}
+
+// GetToken returns the cached metadata of the token
+func (s *Storage) GetToken(ctx context.Context, address string) (common.Token, error) {
+	if data, ok := s.tokens.Load(strings.ToLower(address)); ok {
+		return data.(common.Token), nil
+	}
+	return common.Token{}, fmt.Errorf("token [%s] not cached", address)
+}
+
+// GetTokens returns the cached metadata of all the tokens ordered by address
+func (s *Storage) GetTokens(ctx context.Context) ([]common.Token, error) {
+	tokens := make([]common.Token, 0)
+	s.tokens.Range(func(key, value any) bool {
+		tokens = append(tokens, value.(common.Token))
+		return true
+	})
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Address < tokens[j].Address })
+	return tokens, nil
+}
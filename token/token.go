@@ -0,0 +1,186 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+
+	"github.com/ubiq/go-ubiq/common/hexutil"
+)
+
+// AddressTopic returns the address left padded to a 32 bytes topic
+func AddressTopic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
+
+// TransferFilters returns the eth_getLogs filters of the ERC-20 transfers sent from
+// and to the address within the inclusive block range
+func TransferFilters(address string, fromBlock, toBlock int) []common.LogFilter {
+	from := "0x" + strconv.FormatInt(int64(fromBlock), 16)
+	to := "0x" + strconv.FormatInt(int64(toBlock), 16)
+	topic := AddressTopic(address)
+	return []common.LogFilter{
+		{FromBlock: from, ToBlock: to, Topics: []interface{}{common.TRANSFERTOPIC, topic}},
+		{FromBlock: from, ToBlock: to, Topics: []interface{}{common.TRANSFERTOPIC, nil, topic}},
+	}
+}
+
+// DecodeTransfer decodes an ERC-20 Transfer log, false for the other logs, the removed ones
+// and the ERC-721 transfers whose token id is indexed as a fourth topic
+func DecodeTransfer(l common.Log) (common.TokenTransfer, bool) {
+	if l.Removed || len(l.Topics) != 3 || !strings.EqualFold(l.Topics[0], common.TRANSFERTOPIC) {
+		return common.TokenTransfer{}, false
+	}
+	from, ok := topicAddress(l.Topics[1])
+	if !ok {
+		return common.TokenTransfer{}, false
+	}
+	to, ok := topicAddress(l.Topics[2])
+	if !ok {
+		return common.TokenTransfer{}, false
+	}
+	if len(l.Data) != 66 {
+		return common.TokenTransfer{}, false
+	}
+	value, ok := new(big.Int).SetString(l.Data[2:], 16)
+	if !ok {
+		return common.TokenTransfer{}, false
+	}
+	block, err := hexutil.DecodeUint64(l.BlockNumber)
+	if err != nil {
+		return common.TokenTransfer{}, false
+	}
+	index, err := hexutil.DecodeUint64(l.LogIndex)
+	if err != nil {
+		return common.TokenTransfer{}, false
+	}
+	return common.TokenTransfer{
+		Token:           strings.ToLower(l.Address),
+		From:            from,
+		To:              to,
+		Value:           value.String(),
+		BlockNumber:     int(block),
+		BlockHash:       l.BlockHash,
+		TransactionHash: l.TransactionHash,
+		LogIndex:        int(index),
+	}, true
+}
+
+// topicAddress decodes an address padded to a 32 bytes topic
+func topicAddress(topic string) (string, bool) {
+	if len(topic) != 66 || !strings.HasPrefix(topic, "0x") || strings.Trim(topic[2:26], "0") != "" {
+		return "", false
+	}
+	address := "0x" + strings.ToLower(topic[26:])
+	if util.ValidateAddress(address) != nil {
+		return "", false
+	}
+	return address, true
+}
+
+// BalanceOf returns the balance of the holder read with the balanceOf function of the token at the block
+func BalanceOf(ctx context.Context, token string, holder string, block int) (*big.Int, error) {
+	data := common.BALANCEOFSELECTOR + AddressTopic(holder)[2:]
+	result, err := util.Call(ctx, token, data, "0x"+strconv.FormatInt(int64(block), 16))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) < 66 {
+		return nil, fmt.Errorf("invalid balanceOf result [%s] of token [%s]", result, token)
+	}
+	balance, ok := new(big.Int).SetString(result[2:66], 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid balanceOf result [%s] of token [%s]", result, token)
+	}
+	return balance, nil
+}
+
+// Metadata reads the symbol, name and decimals of the token at the latest block. A function the
+// contract does not expose leaves its field empty, and decimals -1, while a transport, throttling
+// or other Json RPC error fails.
+func Metadata(ctx context.Context, token string) (common.Token, error) {
+	meta := common.Token{Address: strings.ToLower(token), Decimals: -1}
+
+	symbol, err := callString(ctx, token, common.SYMBOLSELECTOR)
+	if err != nil {
+		return common.Token{}, err
+	}
+	name, err := callString(ctx, token, common.NAMESELECTOR)
+	if err != nil {
+		return common.Token{}, err
+	}
+	meta.Symbol, meta.Name = symbol, name
+
+	result, err := call(ctx, token, common.DECIMALSSELECTOR)
+	if err != nil {
+		return common.Token{}, err
+	}
+	if len(result) >= 66 {
+		if decimals, ok := new(big.Int).SetString(result[2:66], 16); ok && decimals.IsInt64() && decimals.Int64() <= 255 {
+			meta.Decimals = int(decimals.Int64())
+		}
+	}
+	return meta, nil
+}
+
+// call calls the function of the token at the latest block, a reverted call or a contract
+// without the function returns an empty result, the other errors such as throttling fail
+func call(ctx context.Context, token string, selector string) (string, error) {
+	result, err := util.Call(ctx, token, selector, "latest")
+	if err != nil {
+		if reverted(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return result, nil
+}
+
+// reverted checks the Json RPC error reports a reverted execution, the code 3 of
+// geth or the message of the other clients
+func reverted(err error) bool {
+	var rpcErr *util.RPCError
+	if !errors.As(err, &rpcErr) || util.IsThrottled(err) {
+		return false
+	}
+	return rpcErr.Code == 3 || strings.Contains(strings.ToLower(rpcErr.Message), "revert")
+}
+
+func callString(ctx context.Context, token string, selector string) (string, error) {
+	result, err := call(ctx, token, selector)
+	if err != nil {
+		return "", err
+	}
+	return decodeString(result), nil
+}
+
+// decodeString decodes an ABI encoded string, or a bytes32 returned by older tokens,
+// an invalid value decodes to an empty string
+func decodeString(result string) string {
+	data, err := hexutil.Decode(result)
+	if err != nil {
+		return ""
+	}
+	if len(data) == 32 {
+		return strings.TrimRight(string(data), "\x00")
+	}
+	if len(data) < 64 {
+		return ""
+	}
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsInt64() || offset.Int64()+32 > int64(len(data)) {
+		return ""
+	}
+	start := int(offset.Int64())
+	length := new(big.Int).SetBytes(data[start : start+32])
+	if !length.IsInt64() || int64(start+32)+length.Int64() > int64(len(data)) {
+		return ""
+	}
+	return string(data[start+32 : start+32+int(length.Int64())])
+}
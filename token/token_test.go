@@ -0,0 +1,167 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	common "github.com/tonyxu1/transactionhistory/common"
+	util "github.com/tonyxu1/transactionhistory/util"
+)
+
+const (
+	testAddress = "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	testToken   = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+)
+
+func TestDecodeTransfer(t *testing.T) {
+	transfer := common.Log{
+		Address:         "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+		Topics:          []string{common.TRANSFERTOPIC, AddressTopic(testAddress), AddressTopic("0xE946502872DA09009Aa6dc975272AC24Ab5B4f36")},
+		Data:            "0x00000000000000000000000000000000000000000000000000000000000f4240",
+		BlockNumber:     "0x10",
+		BlockHash:       "0xb10",
+		TransactionHash: "0x01",
+		LogIndex:        "0x2",
+	}
+	nft := transfer
+	nft.Topics = append(append([]string{}, transfer.Topics...), "0x0000000000000000000000000000000000000000000000000000000000000001")
+	nft.Data = "0x"
+	removed := transfer
+	removed.Removed = true
+	approval := transfer
+	approval.Topics = append([]string{"0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"}, transfer.Topics[1:]...)
+
+	tests := []struct {
+		name   string
+		log    common.Log
+		want   common.TokenTransfer
+		wantOk bool
+	}{
+		{
+			name: "ERC-20 transfer",
+			log:  transfer,
+			want: common.TokenTransfer{
+				Token:           testToken,
+				From:            "0x23ca95b9de14a83cbf4a43b11c2c3825e72c7d9b",
+				To:              "0xe946502872da09009aa6dc975272ac24ab5b4f36",
+				Value:           "1000000",
+				BlockNumber:     16,
+				BlockHash:       "0xb10",
+				TransactionHash: "0x01",
+				LogIndex:        2,
+			},
+			wantOk: true,
+		},
+		{name: "ERC-721 transfer skipped", log: nft},
+		{name: "Removed log skipped", log: removed},
+		{name: "Other event skipped", log: approval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DecodeTransfer(tt.log)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeTransfer() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_decodeString(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		want   string
+	}{
+		{
+			name:   "ABI encoded string",
+			result: "0x0000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000455534443" + "00000000000000000000000000000000000000000000000000000000",
+			want:   "USDC",
+		}, {
+			name:   "bytes32",
+			result: "0x4d4b520000000000000000000000000000000000000000000000000000000000",
+			want:   "MKR",
+		}, {
+			name:   "Empty result",
+			result: "0x",
+			want:   "",
+		}, {
+			name:   "Offset out of range",
+			result: "0x00000000000000000000000000000000000000000000000000000000000000ff0000000000000000000000000000000000000000000000000000000000000004",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeString(tt.result); got != tt.want {
+				t.Errorf("decodeString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		want     common.Token
+		wantErr  bool
+	}{
+		{
+			// the token exposes symbol and decimals but reverts name
+			name:     "Reverted function left empty",
+			fallback: `{"jsonrpc":"2.0","error":{"code":3,"message":"execution reverted"},"id":7}`,
+			want:     common.Token{Address: testToken, Symbol: "USDC", Decimals: 6},
+		},
+		{
+			name:     "Reverted function with message only",
+			fallback: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"execution reverted"},"id":7}`,
+			want:     common.Token{Address: testToken, Symbol: "USDC", Decimals: 6},
+		},
+		{
+			name:     "Throttled call fails",
+			fallback: `{"jsonrpc":"2.0","error":{"code":-32005,"message":"request rate exceeded"},"id":7}`,
+			wantErr:  true,
+		},
+		{
+			name:     "Other Json RPC error fails",
+			fallback: `{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal error"},"id":7}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Params []json.RawMessage `json:"params"`
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				var call struct {
+					Data string `json:"data"`
+				}
+				json.Unmarshal(req.Params[0], &call)
+				switch call.Data {
+				case common.SYMBOLSELECTOR:
+					w.Write([]byte(`{"jsonrpc":"2.0","result":"0x000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000045553444300000000000000000000000000000000000000000000000000000000","id":7}`))
+				case common.DECIMALSSELECTOR:
+					w.Write([]byte(`{"jsonrpc":"2.0","result":"0x0000000000000000000000000000000000000000000000000000000000000006","id":7}`))
+				default:
+					w.Write([]byte(tt.fallback))
+				}
+			}))
+			defer srv.Close()
+
+			ctx := util.WithNetwork(context.Background(), common.Network{Name: "test", RPCEndpoints: []string{srv.URL}})
+			got, err := Metadata(ctx, testToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Metadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Metadata() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
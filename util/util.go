@@ -8,16 +8,83 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	common "github.com/tonyxu1/transactionhistory/common"
+	metrics "github.com/tonyxu1/transactionhistory/metrics"
 
 	"github.com/ubiq/go-ubiq/common/hexutil"
 )
 
+// ErrRateLimited is returned when the Json RPC endpoint throttles the request
+var ErrRateLimited = errors.New("json rpc rate limited")
+
+type networkKey struct{}
+
+// DefaultNetwork returns the network served when none is configured
+func DefaultNetwork() common.Network {
+	return common.Network{
+		Name:           common.DEFAULTNETWORK,
+		ChainID:        common.DEFAULTCHAINID,
+		RPCEndpoints:   []string{common.RPCENDPOINT},
+		LookbackBlocks: common.LOOKBACKBLOCKS,
+		BlockTime:      common.INTERVALINSECONDS,
+	}
+}
+
+// WithNetwork returns a copy of ctx carrying the network, Json RPC requests made
+// with the returned context are sent to the endpoints of the network
+func WithNetwork(ctx context.Context, n common.Network) context.Context {
+	return context.WithValue(ctx, networkKey{}, n)
+}
+
+// NetworkFromContext returns the network carried by ctx or the default network
+func NetworkFromContext(ctx context.Context) common.Network {
+	if n, ok := ctx.Value(networkKey{}).(common.Network); ok {
+		return n
+	}
+	return DefaultNetwork()
+}
+
+// RPCError is the error object of a Json RPC response
+type RPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// IsThrottled checks the error is a rate limit rejection from the Json RPC endpoint,
+// either HTTP 429 or a Json RPC limit error
+func IsThrottled(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		msg := strings.ToLower(rpcErr.Message)
+		return rpcErr.Code == -32005 || strings.Contains(msg, "rate limit") ||
+			strings.Contains(msg, "too many requests") || strings.Contains(msg, "limit exceeded")
+	}
+	return false
+}
+
+// IsTimeout checks the error is a timeout of the Json RPC request
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // GetPreviousBlock returns previous block in hex string starts with "0x"
 // input parameter: currentBlock: hex string starts with "0x"
 func GetPreviousBlock(currentBlock string) (string, error) {
@@ -41,12 +108,42 @@ func GetPreviousBlock(currentBlock string) (string, error) {
 
 }
 
-// GetDataFromChain retrieve current block number and transactions from th chain
-func GetDataFromChain(payLoad string, timeout time.Duration) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// GetDataFromChain retrieve current block number and transactions from th chain, the
+// endpoints of the network carried by ctx are tried in order until one answers. Each
+// request is cancelled when ctx is done or after timeout.
+func GetDataFromChain(ctx context.Context, payLoad string, timeout time.Duration) (body []byte, err error) {
+	method := rpcMethod(payLoad)
+	start := time.Now()
+	defer func() {
+		metrics.RPCDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.RPCErrors.WithLabelValues(method).Inc()
+		}
+	}()
+
+	endpoints := NetworkFromContext(ctx).RPCEndpoints
+	if len(endpoints) == 0 {
+		return nil, errors.New("no json rpc endpoint configured")
+	}
+	// Json RPC errors are in the body, only the endpoints failing to answer are skipped
+	for _, endpoint := range endpoints {
+		body, err = postRPC(ctx, endpoint, payLoad, timeout)
+		if err == nil || ctx.Err() != nil {
+			return body, err
+		}
+	}
+	return nil, err
+}
+
+// ErrUnavailable is returned when the Json RPC endpoint fails with a server error
+var ErrUnavailable = errors.New("json rpc endpoint unavailable")
+
+// postRPC posts the Json RPC payload to the endpoint
+func postRPC(ctx context.Context, endpoint string, payLoad string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, common.RPCENDPOINT, bytes.NewBufferString(payLoad))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBufferString(payLoad))
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +156,13 @@ func GetDataFromChain(payLoad string, timeout time.Duration) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: http status %d", ErrRateLimited, resp.StatusCode)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: http status %d", ErrUnavailable, resp.StatusCode)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -67,6 +171,295 @@ func GetDataFromChain(payLoad string, timeout time.Duration) ([]byte, error) {
 
 }
 
+// rpcMethod returns the method of the Json RPC payload for metrics labels
+func rpcMethod(payLoad string) string {
+	var req struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal([]byte(payLoad), &req); err != nil || req.Method == "" {
+		return "unknown"
+	}
+	return req.Method
+}
+
+// GetChainHead returns the most recent block number of the chain
+func GetChainHead(ctx context.Context) (int, error) {
+	data, err := GetDataFromChain(ctx, common.BLOCKNUMBER, common.TIMEOUT)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := ValidateChainData(data)
+	if err != nil {
+		return -1, err
+	}
+
+	num, err := strconv.ParseInt(resp.Result, 0, 64)
+	if err != nil {
+		return -1, fmt.Errorf("invalid block number %s: %s", resp.Result, err.Error())
+	}
+	return int(num), nil
+}
+
+// GetChainID returns the chain id served by the Json RPC endpoints
+func GetChainID(ctx context.Context) (int64, error) {
+	data, err := GetDataFromChain(ctx, common.CHAINID, common.TIMEOUT)
+	if err != nil {
+		return 0, err
+	}
+	errResp := common.ResponseError{}
+	if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+		return 0, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+	}
+	resp, err := ValidateChainData(data)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseInt(resp.Result, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chain id %s: %s", resp.Result, err.Error())
+	}
+	return id, nil
+}
+
+// GetBlockByNumber retrieve the block with its transactions from the chain
+func GetBlockByNumber(ctx context.Context, number int) (common.Block, error) {
+	blockNumStr := "0x" + strconv.FormatInt(int64(number), 16)
+	data, err := GetDataFromChain(ctx, fmt.Sprintf(common.GETBLOCKBYNUMBER, blockNumStr), common.TIMEOUT)
+	if err != nil {
+		return common.Block{}, err
+	}
+
+	var blockInfo common.Block
+	err = json.Unmarshal(data, &blockInfo)
+	if err != nil {
+		errResp := common.ResponseError{}
+		err1 := json.Unmarshal(data, &errResp)
+		if err1 != nil {
+			return common.Block{}, fmt.Errorf("%s | %s", err.Error(), err1.Error())
+		}
+		return common.Block{}, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+	}
+	if blockInfo.Result.Number == "" {
+		// null result for a block not produced yet, or an error response
+		errResp := common.ResponseError{}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			return common.Block{}, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+		}
+		return common.Block{}, fmt.Errorf("block [%s] not found", blockNumStr)
+	}
+	return blockInfo, nil
+}
+
+// HeaderOf returns the header fields of the block
+func HeaderOf(block common.Block) common.Header {
+	return common.Header{
+		Number:        block.Result.Number,
+		Hash:          block.Result.Hash,
+		ParentHash:    block.Result.ParentHash,
+		Timestamp:     block.Result.Timestamp,
+		BaseFeePerGas: block.Result.BaseFeePerGas,
+	}
+}
+
+// GetTransactionByHash retrieve the transaction with the given hash from the chain,
+// the block number of a pending transaction is empty
+func GetTransactionByHash(ctx context.Context, hash string) (common.Transaction, error) {
+	data, err := GetDataFromChain(ctx, fmt.Sprintf(common.GETTRANSACTIONBYHASH, hash), common.TIMEOUT)
+	if err != nil {
+		return common.Transaction{}, err
+	}
+
+	var resp struct {
+		Result *common.Transaction `json:"result"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return common.Transaction{}, err
+	}
+	if resp.Result == nil {
+		errResp := common.ResponseError{}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			return common.Transaction{}, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+		}
+		return common.Transaction{}, fmt.Errorf("transaction [%s] not found", hash)
+	}
+	return *resp.Result, nil
+}
+
+// GetTransactionReceipt retrieve the receipt of the transaction with the given hash from the chain
+func GetTransactionReceipt(ctx context.Context, hash string) (common.Receipt, error) {
+	data, err := GetDataFromChain(ctx, fmt.Sprintf(common.GETTRANSACTIONRECEIPT, hash), common.TIMEOUT)
+	if err != nil {
+		return common.Receipt{}, err
+	}
+
+	var resp struct {
+		Result *common.Receipt `json:"result"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return common.Receipt{}, err
+	}
+	if resp.Result == nil {
+		errResp := common.ResponseError{}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			return common.Receipt{}, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+		}
+		return common.Receipt{}, fmt.Errorf("receipt of transaction [%s] not found", hash)
+	}
+	return *resp.Result, nil
+}
+
+// GetBalance returns the native balance in wei of the address at the given block
+func GetBalance(ctx context.Context, address string, block int) (*big.Int, error) {
+	blockNumStr := "0x" + strconv.FormatInt(int64(block), 16)
+	data, err := GetDataFromChain(ctx, fmt.Sprintf(common.GETBALANCE, address, blockNumStr), common.TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	errResp := common.ResponseError{}
+	if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+		return nil, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+	}
+	resp, err := ValidateChainData(data)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := hexutil.DecodeBig(resp.Result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid balance %s: %s", resp.Result, err.Error())
+	}
+	return balance, nil
+}
+
+// GetLogs retrieve the logs matching the filter from the chain
+func GetLogs(ctx context.Context, filter common.LogFilter) ([]common.Log, error) {
+	params, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	data, err := GetDataFromChain(ctx, fmt.Sprintf(common.GETLOGS, params), common.TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Result []common.Log `json:"result"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Result == nil {
+		errResp := common.ResponseError{}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			return nil, &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+		}
+		return []common.Log{}, nil
+	}
+	return resp.Result, nil
+}
+
+// Call calls the contract with the hex encoded data at the block, given as a hex number or
+// a tag such as "latest", and returns the hex encoded result
+func Call(ctx context.Context, to string, data string, block string) (string, error) {
+	body, err := GetDataFromChain(ctx, fmt.Sprintf(common.ETHCALL, to, data, block), common.TIMEOUT)
+	if err != nil {
+		return "", err
+	}
+
+	errResp := common.ResponseError{}
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
+		return "", &RPCError{Code: errResp.Error.Code, Message: errResp.Error.Message}
+	}
+	resp, err := ValidateChainData(body)
+	if err != nil {
+		return "", err
+	}
+	return resp.Result, nil
+}
+
+// MatchAccount returns the role of the address in the transaction, false when
+// the address is neither the sender nor the recipient
+func MatchAccount(tr common.Transaction, address string) (common.AccountMatch, bool) {
+	from := strings.EqualFold(tr.From, address)
+	to := strings.EqualFold(tr.To, address)
+	switch {
+	case from && to:
+		return common.AccountMatch{Address: address, Role: common.ROLEBOTH}, true
+	case from:
+		return common.AccountMatch{Address: address, Role: common.ROLESENDER}, true
+	case to:
+		return common.AccountMatch{Address: address, Role: common.ROLERECIPIENT}, true
+	}
+	return common.AccountMatch{}, false
+}
+
+// ParseTime parses a time given as unix seconds, RFC 3339 or a 2006-01-02 date in UTC
+func ParseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time [%s], expecting unix seconds, RFC 3339 or YYYY-MM-DD", v)
+}
+
+// InTimeRange checks the block timestamp of the transaction is within [from, to),
+// a zero bound is open and a transaction without timestamp is out of any bounded range
+func InTimeRange(tr common.Transaction, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	secs, err := hexutil.DecodeUint64(tr.Timestamp)
+	if err != nil {
+		return false
+	}
+	t := time.Unix(int64(secs), 0)
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
+
+// DecodeQuantity decodes a hex quantity of a Json RPC response, an empty quantity is 0
+func DecodeQuantity(quantity string) (*big.Int, error) {
+	if quantity == "" {
+		return new(big.Int), nil
+	}
+	return hexutil.DecodeBig(quantity)
+}
+
+// FormatUnits formats the integer amount in the smallest unit as a decimal number
+// with the given number of decimals, trailing zeros of the fraction are removed
+func FormatUnits(amount *big.Int, decimals int) string {
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	digits := new(big.Int).Abs(amount).String()
+	if decimals <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
 // Validate Ethereum contract address format
 func ValidateAddress(address string) error {
 	re := regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
@@ -77,6 +470,25 @@ func ValidateAddress(address string) error {
 	return nil
 }
 
+// Validate transaction and block hash format
+func ValidateHash(hash string) error {
+	re := regexp.MustCompile("^0x[0-9a-fA-F]{64}$")
+	if !re.MatchString(hash) {
+		return fmt.Errorf("input hash [%s] is invalid", hash)
+	}
+
+	return nil
+}
+
+// Validate the limits of the retention policy, zero is unlimited
+func ValidateRetention(policy common.RetentionPolicy) error {
+	if policy.Days < 0 || policy.Blocks < 0 || policy.Transactions < 0 {
+		return fmt.Errorf("retention limits [%d days, %d blocks, %d transactions] must not be negative", policy.Days, policy.Blocks, policy.Transactions)
+	}
+
+	return nil
+}
+
 // ValidateChainData validate the data format
 func ValidateChainData(data []byte) (common.ResponseData, error) {
 	r := common.ResponseData{}
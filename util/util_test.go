@@ -1,7 +1,13 @@
 package util
 
 import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -80,7 +86,7 @@ func TestGetDataFromChain(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := GetDataFromChain(tt.args.payLoad, tt.args.timeout)
+			got, err := GetDataFromChain(context.Background(), tt.args.payLoad, tt.args.timeout)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetDataFromChain() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -160,3 +166,214 @@ func TestValidateChainData(t *testing.T) {
 		})
 	}
 }
+
+func Test_rpcMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		payLoad string
+		want    string
+	}{
+		{
+			name:    "Block number payload",
+			payLoad: common.BLOCKNUMBER,
+			want:    "eth_blockNumber",
+		}, {
+			name:    "Invalid payload",
+			payLoad: "abc",
+			want:    "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rpcMethod(tt.payLoad); got != tt.want {
+				t.Errorf("rpcMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetDataFromChain_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := GetDataFromChain(ctx, common.BLOCKNUMBER, 10*time.Second)
+	if err == nil {
+		t.Errorf("GetDataFromChain() error = nil for cancelled context")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("GetDataFromChain() took %v for cancelled context", time.Since(start))
+	}
+}
+
+func TestGetDataFromChain_Failover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","result":"0x10","id":1}`))
+	}))
+	defer up.Close()
+
+	tests := []struct {
+		name      string
+		endpoints []string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "Next endpoint answers",
+			endpoints: []string{down.URL, throttled.URL, up.URL},
+			want:      `{"jsonrpc":"2.0","result":"0x10","id":1}`,
+		}, {
+			name:      "All endpoints failing",
+			endpoints: []string{down.URL, throttled.URL},
+			wantErr:   true,
+		}, {
+			name:      "No endpoint",
+			endpoints: []string{},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithNetwork(context.Background(), common.Network{Name: "test", RPCEndpoints: tt.endpoints})
+			got, err := GetDataFromChain(ctx, common.BLOCKNUMBER, common.TIMEOUT)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetDataFromChain() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("GetDataFromChain() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    string
+		want    string
+		wantErr bool
+	}{
+		{name: "Balance decoded", resp: `{"jsonrpc":"2.0","result":"0xde0b6b3a7640000","id":4}`, want: "1000000000000000000"},
+		{name: "Missing state", resp: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"missing trie node"},"id":4}`, wantErr: true},
+		{name: "Invalid balance", resp: `{"jsonrpc":"2.0","result":"1.5","id":4}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.resp))
+			}))
+			defer srv.Close()
+			ctx := WithNetwork(context.Background(), common.Network{Name: "test", RPCEndpoints: []string{srv.URL}})
+			got, err := GetBalance(ctx, "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b", 0x10)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetBalance() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("GetBalance() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "HTTP 429", err: fmt.Errorf("%w: http status 429", ErrRateLimited), want: true},
+		{name: "Json RPC limit code", err: &RPCError{Code: -32005, Message: "limit"}, want: true},
+		{name: "Json RPC rate limit message", err: &RPCError{Code: -32000, Message: "Rate limit reached"}, want: true},
+		{name: "Other Json RPC error", err: &RPCError{Code: -32602, Message: "invalid argument"}, want: false},
+		{name: "Timeout", err: context.DeadlineExceeded, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsThrottled(tt.err); got != tt.want {
+				t.Errorf("IsThrottled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchAccount(t *testing.T) {
+	address := "0x23ca95B9dE14A83CBF4A43b11C2C3825e72c7d9b"
+	other := "0x1111111111111111111111111111111111111111"
+	tests := []struct {
+		name   string
+		tr     common.Transaction
+		want   string
+		wantOk bool
+	}{
+		{name: "Sender", tr: common.Transaction{From: strings.ToLower(address), To: other}, want: common.ROLESENDER, wantOk: true},
+		{name: "Recipient", tr: common.Transaction{From: other, To: address}, want: common.ROLERECIPIENT, wantOk: true},
+		{name: "Self transfer", tr: common.Transaction{From: address, To: address}, want: common.ROLEBOTH, wantOk: true},
+		{name: "Not involved", tr: common.Transaction{From: other, To: other}, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := MatchAccount(tt.tr, address)
+			if ok != tt.wantOk || got.Role != tt.want {
+				t.Errorf("MatchAccount() = %v, %v, want %v, %v", got.Role, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestInTimeRange(t *testing.T) {
+	from, _ := ParseTime("2023-11-14")
+	to, _ := ParseTime("2023-11-15T00:00:00Z")
+	tests := []struct {
+		name string
+		tr   common.Transaction
+		from time.Time
+		to   time.Time
+		want bool
+	}{
+		{name: "No bounds", tr: common.Transaction{}, want: true},
+		{name: "Within the day", tr: common.Transaction{Timestamp: "0x65539b00"}, from: from, to: to, want: true},
+		{name: "Upper bound excluded", tr: common.Transaction{Timestamp: "0x65540a00"}, from: from, to: to, want: false},
+		{name: "Before the lower bound", tr: common.Transaction{Timestamp: "0x6552b87f"}, from: from, want: false},
+		{name: "Missing timestamp", tr: common.Transaction{}, from: from, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InTimeRange(tt.tr, tt.from, tt.to); got != tt.want {
+				t.Errorf("InTimeRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatUnits(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   *big.Int
+		decimals int
+		want     string
+	}{
+		{name: "One ether", amount: big.NewInt(1000000000000000000), decimals: 18, want: "1"},
+		{name: "Fraction", amount: big.NewInt(420000000000000), decimals: 18, want: "0.00042"},
+		{name: "Whole and fraction", amount: big.NewInt(1500), decimals: 3, want: "1.5"},
+		{name: "Zero", amount: big.NewInt(0), decimals: 18, want: "0"},
+		{name: "Negative", amount: big.NewInt(-25), decimals: 2, want: "-0.25"},
+		{name: "No decimals", amount: big.NewInt(42), decimals: 0, want: "42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatUnits(tt.amount, tt.decimals); got != tt.want {
+				t.Errorf("FormatUnits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}